@@ -6,7 +6,10 @@ package notify
 
 import (
 	"fmt"
+	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Event represents the type of filesystem action.
@@ -31,6 +34,55 @@ const (
 	All = Create | Remove | Write | Rename
 )
 
+// Overflow is delivered when the underlying watcher dropped events, e.g. when
+// the inotify queue overflowed because events were not read fast enough. It
+// is delivered to every channel with any watchpoint, regardless of the events
+// the channel was registered for, so it does not need to be passed to Watch.
+// Its path is empty under inotify, under FSEvents it is the path of the
+// affected directory. The Overflow is not reported under other platforms.
+//
+// After an overflow the view of the filesystem built from the events may be
+// stale, the only safe way to recover is to re-stat the whole watched tree.
+const Overflow = osSpecificOverflow
+
+// Attrib is delivered when metadata of a watched file or directory changed,
+// e.g. its permissions, ownership or timestamps. It is not part of All, so it
+// has to be passed to Watch explicitly. It maps to IN_ATTRIB under inotify,
+// to NOTE_ATTRIB under kqueue and to FILE_ATTRIB under FEN.
+//
+// FSEvents reports it only coarsely - there it is the same value as
+// FSEventsInodeMetaMod, which is also reported alongside plain writes. The
+// Attrib is not reported under other platforms.
+const Attrib = osSpecificAttrib
+
+// CloseWrite is delivered when a file which was opened for writing got
+// closed, which is usually when it is safe to process it. It is not part of
+// All, so it has to be passed to Watch explicitly. It maps to IN_CLOSE_WRITE
+// under inotify.
+//
+// Other platforms have no equivalent, there subscribing to CloseWrite is
+// allowed but yields no events - use Write instead.
+const CloseWrite = osSpecificCloseWrite
+
+// Truncate is delivered when a watched file got truncated. It is not part of
+// All, so it has to be passed to Watch explicitly. It maps to FILE_TRUNC under
+// FEN.
+//
+// Under inotify and kqueue it is synthesized: the size of a file is compared
+// with the one seen before, when the file is written to or its attributes
+// change, and Truncate is reported instead of Write when the file shrank.
+// It is best-effort - a truncation which is followed by a write before the
+// event is handled, or of a file whose size was never seen, is reported as
+// a Write, if at all. Consumers which need certainty should stat the file.
+// The Truncate is not reported under other platforms.
+const Truncate = osSpecificTruncate
+
+// Any is the event delivered by watchpoints set up with WithUnifiedEvent in
+// place of the events which actually happened, telling only that something
+// changed at the path. It is never reported by the underlying watchers, so
+// it is not meant to be passed to Watch.
+const Any = osSpecificAny
+
 const internal = recursive | omit
 
 // String implements fmt.Stringer interface.
@@ -90,33 +142,169 @@ func (e Event) String() string {
 //	http://man7.org/linux/man-pages/man7/inotify.7.html
 //
 // Under Darwin, DragonFlyBSD, FreeBSD, NetBSD, OpenBSD (kqueue) Sys() always
-// returns a non-nil *notify.Kevent value, which is defined as:
+// returns a non-nil syscall.Kevent_t value, which is the kqueue structure the
+// event was created from. More information about syscall.Kevent_t can be
+// found at:
 //
-//	type Kevent struct {
-//	    Kevent *syscall.Kevent_t // Kevent is a kqueue specific structure
-//	    FI     os.FileInfo       // FI describes file/dir
-//	}
+//	https://www.freebsd.org/cgi/man.cgi?query=kqueue
 //
-// More information about syscall.Kevent_t can be found at:
+// Under Solaris and illumos (FEN) Sys() always returns a non-nil
+// notify.PortEvent value, which is defined as:
 //
-//	https://www.freebsd.org/cgi/man.cgi?query=kqueue
+//	type PortEvent struct {
+//	    PortevEvents int         // PortevEvents is an equivalent of portev_events.
+//	    PortevSource uint8       // PortevSource is an equivalent of portev_source.
+//	    PortevPad    uint8       // Portevpad is an equivalent of portev_pad.
+//	    PortevObject interface{} // PortevObject is an equivalent of portev_object.
+//	    PortevUser   uintptr     // PortevUser is an equivalent of portev_user.
+//	}
 //
-// Under Windows (ReadDirectoryChangesW) Sys() always returns nil. The documentation
-// of watcher's WinAPI function can be found at:
+// Under Windows (ReadDirectoryChangesW) the raw FILE_NOTIFY_INFORMATION
+// structure is not retained, Sys() returns an uint8 value telling whether the
+// event concerns a file (1), a directory (2) or it is unknown (0). The
+// documentation of watcher's WinAPI function can be found at:
 //
 //	https://msdn.microsoft.com/en-us/library/windows/desktop/aa365465%28v=vs.85%29.aspx
+//
+// On every platform, events of polling watchpoints (see WatchPoll) return
+// a non-nil os.FileInfo value describing the file.
+//
+// Path() always uses the separator of the platform, os.PathSeparator, also
+// for watchpoints set up with slash-separated paths under Windows. Ignore
+// patterns are written with slashes regardless, see IgnoreMatcher.
 type EventInfo interface {
 	Event() Event     // event value for the filesystem action
 	Path() string     // real path of the file or directory
 	Sys() interface{} // underlying data source (can return nil)
 }
 
+// RenamedInfo is implemented by EventInfo values describing a file being moved,
+// which allows for following the file rather than treating the move as removal
+// and creation of two unrelated files. OldPath gives the path of the file
+// before the move, Path the one after it, each of them only if it lies within
+// the watched directories:
+//
+//   - A file moved within the watched directories is reported with two events,
+//     Rename for the old path and Create for the new one, both with OldPath
+//     set to the old path.
+//   - A file moved out of them is reported with a single Rename, which Path
+//     is empty, since the file is gone from the point of view of the
+//     watchpoint, and OldPath tells where it was.
+//   - A file moved in from outside is reported with a single Create, which
+//     OldPath is empty, telling the file was moved in rather than created.
+//
+// For example, a consumer following the files can tell the cases apart by:
+//
+//	if ri, ok := ei.(notify.RenamedInfo); ok {
+//		switch {
+//		case ei.Path() == "":
+//			// ri.OldPath() was moved out of the watched directories
+//		case ei.Event() == notify.Create && ri.OldPath() != "":
+//			// ri.OldPath() was moved to ei.Path()
+//		}
+//	}
+//
+// Currently only inotify watcher reports RenamedInfo, under Linux the events
+// for InMovedFrom and InMovedTo implement it as well.
+type RenamedInfo interface {
+	EventInfo
+	OldPath() string // path of the file before the move
+}
+
+// eventPath gives the path ei was reported for by the watcher, which is the
+// old path of a file moved out of the watched directories, see RenamedInfo.
+// Events are dispatched and matched by it.
+func eventPath(ei EventInfo) string {
+	if e, ok := ei.(RenamedInfo); ok && ei.Path() == "" {
+		return e.OldPath()
+	}
+	return ei.Path()
+}
+
+// DirInfo is implemented by EventInfo values which tell whether the event
+// concerns a directory. All the events delivered by notify implement it.
+//
+// The information comes from the underlying watcher wherever it provides it,
+// which does not race with the file being removed in the meantime. Under
+// Windows it is not known for Write events, in which case the path is stat'ed.
+// IsDir returns false if the stat fails.
+type DirInfo interface {
+	EventInfo
+	IsDir() bool // whether the event concerns a directory
+}
+
+// StatInfo is implemented by EventInfo values which can describe the file the
+// event concerns. All the events delivered by notify implement it.
+//
+// The file is stat'ed, without following symlinks, the first time FileInfo is
+// called and the result is cached, so all the channels receiving the event
+// get the same one. FileInfo fails for Remove events, since the file is gone.
+type StatInfo interface {
+	EventInfo
+	FileInfo() (os.FileInfo, error) // description of the file
+}
+
+// Timestamp is implemented by EventInfo values which tell when the event was
+// observed. All the events delivered by notify implement it.
+//
+// The time is taken by notify when it reads the event from the underlying
+// watcher, or when a polling watchpoint detects the change, so it is not the
+// time the file was modified. None of the watchers provides the time of the
+// change itself, e.g. FSEvents event IDs only tell the order of the events.
+// Events read at once, in a single batch, share the same time.
+type Timestamp interface {
+	EventInfo
+	Time() time.Time // when the event was observed by notify
+}
+
+// RelInfo is implemented by EventInfo values of watchpoints set up with
+// WithTrimRoot. Rel gives the path of the event relative to the watched path,
+// slash-separated, like "src/main.go". For recursive watchpoints it is
+// relative to the root of the tree, not to the directory the event happened
+// in. It is "." for events of the watched path itself, and it fails for
+// events which do not concern a path within it, like Overflow.
+type RelInfo interface {
+	EventInfo
+	Rel() (string, error) // path relative to the watched path
+}
+
+// fileStat caches the description of the file an event concerns.
+type fileStat struct {
+	once sync.Once
+	fi   os.FileInfo
+	err  error
+}
+
 type isDirer interface {
 	isDir() (bool, error)
 }
 
 var _ fmt.Stringer = (*event)(nil)
 var _ isDirer = (*event)(nil)
+var _ DirInfo = (*event)(nil)
+var _ StatInfo = (*event)(nil)
+var _ Timestamp = (*event)(nil)
+
+// Time implements Timestamp interface.
+func (e *event) Time() time.Time { return e.time }
+
+// FileInfo implements StatInfo interface.
+func (e *event) FileInfo() (os.FileInfo, error) {
+	e.stat.once.Do(func() {
+		if e.Event()&Remove != 0 {
+			e.stat.err = &os.PathError{Op: "lstat", Path: e.Path(), Err: os.ErrNotExist}
+			return
+		}
+		e.stat.fi, e.stat.err = os.Lstat(e.Path())
+	})
+	return e.stat.fi, e.stat.err
+}
+
+// IsDir implements DirInfo interface.
+func (e *event) IsDir() bool {
+	ok, err := e.isDir()
+	return ok && err == nil
+}
 
 // String implements fmt.Stringer interface.
 func (e *event) String() string {
@@ -128,6 +316,12 @@ var estr = map[Event]string{
 	Remove: "notify.Remove",
 	Write:  "notify.Write",
 	Rename: "notify.Rename",
+
+	Overflow:   "notify.Overflow",
+	Attrib:     "notify.Attrib",
+	CloseWrite: "notify.CloseWrite",
+	Truncate:   "notify.Truncate",
+	Any:        "notify.Any",
 	// Display name for recursive event is added only for debugging
 	// purposes. It's an internal event after all and won't be exposed to the
 	// user. Having Recursive event printable is helpful, e.g. for reading
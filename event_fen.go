@@ -20,6 +20,15 @@ const (
 	omit
 )
 
+// Platform independent event values which are not part of All.
+const (
+	osSpecificOverflow Event = 0x4000 << iota
+	osSpecificAttrib
+	osSpecificCloseWrite
+	osSpecificTruncate
+	osSpecificAny
+)
+
 const (
 	// FileAccess is an event reported when monitored file/directory was accessed.
 	FileAccess = fileAccess
@@ -7,6 +7,8 @@
 
 package notify
 
+import "time"
+
 const (
 	osSpecificCreate = Event(FSEventsCreated)
 	osSpecificRemove = Event(FSEventsRemoved)
@@ -18,6 +20,12 @@ const (
 	// omit is used for dispatching internal events; only those events are sent
 	// for which both the event and the watchpoint has omit in theirs event sets.
 	omit = Event(0x400000)
+
+	osSpecificOverflow   = Event(0x800000)
+	osSpecificAttrib     = Event(FSEventsInodeMetaMod)
+	osSpecificCloseWrite = Event(0x1000000)
+	osSpecificTruncate   = Event(0x2000000)
+	osSpecificAny        = Event(0x4000000)
 )
 
 // FSEvents specific event values.
@@ -64,6 +72,8 @@ var osestr = map[Event]string{
 type event struct {
 	fse   FSEvent
 	event Event
+	stat  fileStat
+	time  time.Time
 }
 
 func (ei *event) Event() Event         { return ei.event }
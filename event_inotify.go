@@ -7,7 +7,11 @@
 
 package notify
 
-import "golang.org/x/sys/unix"
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
 
 // Platform independent event values.
 const (
@@ -23,6 +27,18 @@ const (
 	omit
 )
 
+// Platform independent event values which are not part of All. They use bits
+// that are never set in inotify masks.
+const (
+	osSpecificOverflow Event = 0x10000 << iota
+	osSpecificAttrib
+	osSpecificCloseWrite
+	osSpecificTruncate
+)
+
+// osSpecificAny is past recursive and omit, which follow osSpecificCreate.
+const osSpecificAny Event = 0x8000000
+
 // Inotify specific masks are legal, implemented events that are guaranteed to
 // work with notify package on linux-based systems.
 const (
@@ -68,9 +84,47 @@ type event struct {
 	sys   unix.InotifyEvent
 	path  string
 	event Event
+	pair  *event // the other half of a move, if any
+	dir   bool   // whether the event is of a watched directory itself
+	stat  fileStat
+	time  time.Time
 }
 
 func (e *event) Event() Event         { return e.event }
 func (e *event) Path() string         { return e.path }
 func (e *event) Sys() interface{}     { return &e.sys }
-func (e *event) isDir() (bool, error) { return e.sys.Mask&unix.IN_ISDIR != 0, nil }
+func (e *event) isDir() (bool, error) { return e.dir || e.sys.Mask&unix.IN_ISDIR != 0, nil }
+
+// info returns the event as it is delivered to the user, events caused by
+// a move are wrapped with moveEvent.
+func (e *event) info() EventInfo {
+	switch {
+	case e.sys.Mask&unix.IN_MOVED_FROM != 0:
+		return &moveEvent{event: e, oldpath: e.path, out: e.pair == nil}
+	case e.sys.Mask&unix.IN_MOVED_TO != 0:
+		m := &moveEvent{event: e}
+		if e.pair != nil {
+			m.oldpath = e.pair.path
+		}
+		return m
+	}
+	return e
+}
+
+// moveEvent is an event caused by a move of a file, it implements RenamedInfo.
+type moveEvent struct {
+	*event
+	oldpath string
+	out     bool // whether the file was moved out of the watched directories
+}
+
+// Path implements EventInfo interface, the path is empty for a file moved out
+// of the watched directories.
+func (e *moveEvent) Path() string {
+	if e.out {
+		return ""
+	}
+	return e.path
+}
+
+func (e *moveEvent) OldPath() string { return e.oldpath }
@@ -26,6 +26,15 @@ const (
 	omit
 )
 
+// Platform independent event values which are not part of All.
+const (
+	osSpecificOverflow Event = 0x4000 << iota
+	osSpecificAttrib
+	osSpecificCloseWrite
+	osSpecificTruncate
+	osSpecificAny
+)
+
 const (
 	// NoteDelete is an event reported when the unlink() system call was called
 	// on the file referenced by the descriptor.
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"syscall"
+	"time"
 )
 
 // Platform independent event values.
@@ -26,6 +27,11 @@ const (
 	omit
 	// dirmarker TODO(pknap)
 	dirmarker
+	osSpecificOverflow
+	osSpecificAttrib
+	osSpecificCloseWrite
+	osSpecificTruncate
+	osSpecificAny
 )
 
 // ReadDirectoryChangesW filters
@@ -101,6 +107,8 @@ type event struct {
 	action uint32
 	filter uint32
 	e      Event
+	stat   fileStat
+	time   time.Time
 }
 
 func (e *event) Event() Event     { return e.e }
@@ -7,6 +7,8 @@
 
 package notify
 
+import "time"
+
 // Platform independent event values.
 const (
 	osSpecificCreate Event = 1 << iota
@@ -19,11 +21,20 @@ const (
 	// omit is used for dispatching internal events; only those events are sent
 	// for which both the event and the watchpoint has omit in theirs event sets.
 	omit
+
+	osSpecificOverflow
+	osSpecificAttrib
+	osSpecificCloseWrite
+	osSpecificTruncate
+	osSpecificAny
 )
 
 var osestr = map[Event]string{}
 
-type event struct{}
+type event struct {
+	stat fileStat
+	time time.Time
+}
 
 func (e *event) Event() (_ Event)         { return }
 func (e *event) Path() (_ string)         { return }
@@ -7,11 +7,16 @@
 
 package notify
 
+import "time"
+
 type event struct {
 	p  string
 	e  Event
 	d  bool
 	pe interface{}
+
+	stat fileStat
+	time time.Time
 }
 
 func (e *event) Event() Event { return e.e }
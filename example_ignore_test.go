@@ -22,7 +22,7 @@ func ExampleSetIgnorePatterns() {
 		"node_modules/",
 		"*.tmp",
 		"*.log",
-		"build/",
+		"build/*",
 		"!build/important.txt", // Negation: don't ignore this specific file
 	})
 	if err != nil {
@@ -0,0 +1,52 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import "path/filepath"
+
+// excludeSet holds the absolute paths of the directories excluded from
+// a watchpoint with WithExcludePaths.
+type excludeSet []string
+
+// newExcludeSet resolves paths relative to root. A path which contains
+// symlinks is kept also with the symlinks resolved, since events are reported
+// under either of them.
+func newExcludeSet(root string, paths []string) excludeSet {
+	var x excludeSet
+	for _, p := range paths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(root, p)
+		}
+		p = filepath.Clean(p)
+		x = append(x, p)
+		if real, err := canonical(p); err == nil && real != p {
+			x = append(x, real)
+		}
+	}
+	return x
+}
+
+// has reports whether path is one of the excluded directories or lies within
+// one.
+func (x excludeSet) has(path string) bool {
+	for _, p := range x {
+		if path == p || indexrel(p, path) != -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeStage drops events for paths excluded by x.
+func excludeStage(x excludeSet) stage {
+	return func(_ *subscription, next sink) sink {
+		return func(ei EventInfo) {
+			if x.has(eventPath(ei)) {
+				return
+			}
+			next(ei)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+//go:build windows || plan9
+// +build windows plan9
+
+package notify
+
+import "os"
+
+// fileid stub.
+func fileid(os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
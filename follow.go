@@ -0,0 +1,53 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import "path/filepath"
+
+// WatchFollow sets up a watchpoint on the file at path, which follows the path
+// rather than the file. When the file is renamed or removed and a new one is
+// created in its place, like when a log file is rotated, the events of the new
+// file are delivered, and the ones of the old file are not anymore.
+//
+// The watchpoint is set up on the directory of the file, which has to exist,
+// and only events concerning path are delivered, so the file itself does not
+// need to exist yet. Under platforms which watch files one by one, like those
+// using kqueue, Create is watched in the directory as well, in order to notice
+// the new file, but it is delivered only if requested. Event paths are
+// reported with symlinks in the directory path resolved.
+//
+// Stop called on c removes the watchpoint.
+func WatchFollow(path string, c chan<- EventInfo, events ...Event) error {
+	if c == nil {
+		panic("notify: Watch using nil channel")
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dir, _, err := cleanpath(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	eset := joinevents(events)
+	stage := followStage(filepath.Join(dir, filepath.Base(path)), eset)
+	_, err = subscribe(dir, c, []Event{eset | Create}, stage)
+	return err
+}
+
+// followStage drops events other than the ones of eset for path, whichever
+// file is currently there.
+func followStage(path string, eset Event) stage {
+	return func(_ *subscription, next sink) sink {
+		return func(ei EventInfo) {
+			if ei.Event() == Overflow || eventPath(ei) == path && ei.Event()&eset != 0 {
+				next(ei)
+			}
+		}
+	}
+}
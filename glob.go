@@ -0,0 +1,130 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WatchGlob sets up a watchpoint on every directory matching the pattern, e.g.
+// "services/*/cmd". Each path component of the pattern is matched with
+// filepath.Match, additionally a component consisting of "**" matches any
+// number of directories, including none. Directories ignored by the global
+// ignore matcher are not matched.
+//
+// The pattern is expanded once, directories created after WatchGlob returns
+// are not watched even if they match it. If the pattern ends with "...",
+// like "services/*/cmd/...", every matched directory is watched recursively,
+// so the new directories created within the matched ones are watched as well.
+//
+// WatchGlob fails with os.ErrNotExist if no directory matches the pattern,
+// and with filepath.ErrBadPattern if the pattern is malformed. If any of the
+// matched directories fails to be watched, none of them is.
+//
+// The returned stop function removes all the watchpoints set up by the call,
+// other watchpoints of c are not affected. Stop called on c removes them as
+// well.
+func WatchGlob(pattern string, c chan<- EventInfo, events ...Event) (stop func(), err error) {
+	if c == nil {
+		panic("notify: Watch using nil channel")
+	}
+	isrec := false
+	if strings.HasSuffix(pattern, "...") {
+		isrec = true
+		pattern = pattern[:len(pattern)-3]
+	}
+	dirs, err := globDirs(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, &os.PathError{Op: "glob", Path: pattern, Err: os.ErrNotExist}
+	}
+	s := newSubscription(c, nil)
+	s.start()
+	for _, dir := range dirs {
+		if isrec {
+			dir = filepath.Join(dir, "...")
+		}
+		if err := watch(dir, s.in, c, events); err != nil {
+			s.Stop()
+			return nil, err
+		}
+	}
+	return s.Stop, nil
+}
+
+// globDirs gives the directories matching pattern, sorted.
+func globDirs(pattern string) ([]string, error) {
+	pattern = filepath.Clean(pattern)
+	parts := strings.Split(pattern, string(os.PathSeparator))
+	for _, part := range parts {
+		if _, err := filepath.Match(part, ""); err != nil {
+			return nil, err
+		}
+	}
+	// Start the walk from the longest leading path without any meta
+	// characters.
+	i := 0
+	for i < len(parts) && !hasGlobMeta(parts[i]) {
+		i++
+	}
+	dir := strings.Join(parts[:i], string(os.PathSeparator))
+	switch {
+	case filepath.IsAbs(pattern) && dir == filepath.VolumeName(pattern):
+		dir += string(os.PathSeparator)
+	case dir == "":
+		dir = "."
+	}
+	matches := make(map[string]struct{})
+	globWalk(dir, parts[i:], matches)
+	dirs := make([]string, 0, len(matches))
+	for dir := range matches {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// globWalk adds to matches the directories below dir which match the pattern
+// components in parts.
+func globWalk(dir string, parts []string, matches map[string]struct{}) {
+	if len(parts) == 0 {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			matches[dir] = struct{}{}
+		}
+		return
+	}
+	if parts[0] == "**" {
+		globWalk(dir, parts[1:], matches)
+	}
+	de, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, de := range de {
+		name := filepath.Join(dir, de.Name())
+		switch {
+		case parts[0] == "**":
+			// Do not follow symlinks, which may form cycles.
+			if de.IsDir() && !shouldPrune(name) {
+				globWalk(name, parts, matches)
+			}
+		default:
+			if ok, _ := filepath.Match(parts[0], de.Name()); ok && !shouldIgnoreKind(name, direntKind(de)) {
+				globWalk(name, parts[1:], matches)
+			}
+		}
+	}
+}
+
+// hasGlobMeta reports whether pattern contains any of the meta characters
+// recognized by filepath.Match.
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGlobDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, dir := range []string{
+		"services/api/cmd",
+		"services/web/cmd",
+		"services/web/internal/cmd",
+		"services/db",
+		"tools/cmd",
+	} {
+		mustT(t, os.MkdirAll(filepath.Join(tmpDir, filepath.FromSlash(dir)), 0755))
+	}
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "services", "cmd"), nil, 0666))
+
+	cases := map[string][]string{
+		"services/*/cmd":    {"services/api/cmd", "services/web/cmd"},
+		"services/**/cmd":   {"services/api/cmd", "services/web/cmd", "services/web/internal/cmd"},
+		"**/cmd":            {"services/api/cmd", "services/web/cmd", "services/web/internal/cmd", "tools/cmd"},
+		"services/[a-d]*":   {"services/api", "services/db"},
+		"services/db":       {"services/db"},
+		"services/*/nested": {},
+	}
+	for pattern, want := range cases {
+		dirs, err := globDirs(filepath.Join(tmpDir, filepath.FromSlash(pattern)))
+		if err != nil {
+			t.Errorf("%s: want err=nil, got %v", pattern, err)
+			continue
+		}
+		for i := range want {
+			want[i] = filepath.Join(tmpDir, filepath.FromSlash(want[i]))
+		}
+		if !reflect.DeepEqual(dirs, want) {
+			t.Errorf("%s: want %v, got %v", pattern, want, dirs)
+		}
+	}
+	if _, err := globDirs(filepath.Join(tmpDir, "[")); err != filepath.ErrBadPattern {
+		t.Errorf("want %v, got %v", filepath.ErrBadPattern, err)
+	}
+}
+
+func TestWatchGlob(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, dir := range []string{"a/cmd", "b/cmd", "c/other"} {
+		mustT(t, os.MkdirAll(filepath.Join(tmpDir, filepath.FromSlash(dir)), 0755))
+	}
+	if _, err := WatchGlob(filepath.Join(tmpDir, "*", "none"), make(chan EventInfo), Create); !os.IsNotExist(err) {
+		t.Fatalf("want os.ErrNotExist, got %v", err)
+	}
+	c := make(chan EventInfo, 10)
+	stop, err := WatchGlob(filepath.Join(tmpDir, "*", "cmd"), c, Create)
+	mustT(t, err)
+	defer Stop(c)
+
+	for _, path := range []string{"a/cmd/x", "b/cmd/x", "c/other/x"} {
+		mustT(t, os.WriteFile(filepath.Join(tmpDir, filepath.FromSlash(path)), nil, 0666))
+	}
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 2 {
+		t.Fatalf("want 2 events, got %v", ev)
+	}
+
+	stop()
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "a", "cmd", "y"), nil, 0666))
+	if ev := collect(c, 100*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events after stop, got %v", ev)
+	}
+}
@@ -6,44 +6,126 @@ package notify
 
 import (
 	"bufio"
+	"errors"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-// IgnoreMatcher provides gitignore-style pattern matching for paths
+// IgnoreMatcher provides gitignore-style pattern matching for paths.
+//
+// Patterns are always slash-separated, like in .gitignore files, and a
+// backslash escapes the next character, also under Windows. Matched paths use
+// the separator of the platform, they are converted with filepath.ToSlash
+// first.
+//
+// It is safe for concurrent use, patterns can be added while the matcher
+// is used by running watches.
 type IgnoreMatcher struct {
+	mu       sync.RWMutex // protects patterns, includes, nocase, nfc, hier, maxSize, custom and cache
 	patterns []ignorePattern
+	includes []ignorePattern
 	root     string
+	abs      string // root made absolute, matched against absolute paths
+	nocase   bool
+	nfc      bool
+	hier     bool
+	maxSize  int64
+	custom   func(path string) (ignore, handled bool)
+	cache    *matchCache // nil if disabled
+	filesMu  sync.Mutex  // protects files
+	files    map[string]*ignoreFile
 }
 
 type ignorePattern struct {
+	line     string // pattern as it was added, used to identify it
+	base     string // directory the pattern is relative to, "" for root
 	pattern  string
 	isNegate bool
 	isDir    bool
+	events   Event  // events the pattern applies to, 0 for all
+	basename bool   // whether the pattern matches the last element only
+	file     string // ignore file the pattern was loaded from, if tracked
+	lineno   int    // line number within the file, 0 if not loaded from one
 }
 
-// NewIgnoreMatcher creates a new ignore matcher with the given root directory
+// source describes where the pattern comes from.
+func (p *ignorePattern) source() PatternSource {
+	return PatternSource{Pattern: p.line, File: p.file, Line: p.lineno}
+}
+
+// PatternSource describes a pattern of an IgnoreMatcher together with where
+// it comes from, see MatchSource.
+type PatternSource struct {
+	Pattern string // the pattern as it was added
+	File    string // ignore file the pattern was loaded from, if known
+	Line    int    // line number within File, 0 if not loaded from a file
+}
+
+// NewIgnoreMatcher creates a new ignore matcher with the given root directory.
+// A relative root is resolved against the current working directory, so
+// absolute paths, like the ones of events, are matched relative to it too.
 func NewIgnoreMatcher(root string) *IgnoreMatcher {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		abs = ""
+	}
 	return &IgnoreMatcher{
 		root:     root,
+		abs:      abs,
 		patterns: make([]ignorePattern, 0),
 	}
 }
 
-// AddPattern adds a gitignore-style pattern to the matcher
-func (im *IgnoreMatcher) AddPattern(pattern string) {
-	pattern = strings.TrimSpace(pattern)
-	if pattern == "" || strings.HasPrefix(pattern, "#") {
-		return
+// PatternError records an invalid ignore pattern and where it came from.
+type PatternError struct {
+	File    string // ignore file the pattern was loaded from, if any
+	Line    int    // line number within File, 0 if not loaded from a file
+	Pattern string // the offending pattern
+	Err     error
+}
+
+func (e *PatternError) Error() string {
+	if e.File != "" {
+		return "notify: " + e.File + ":" + strconv.Itoa(e.Line) + ": invalid ignore pattern " +
+			strconv.Quote(e.Pattern) + ": " + e.Err.Error()
 	}
+	if e.Line != 0 {
+		return "notify: line " + strconv.Itoa(e.Line) + ": invalid ignore pattern " +
+			strconv.Quote(e.Pattern) + ": " + e.Err.Error()
+	}
+	return "notify: invalid ignore pattern " + strconv.Quote(e.Pattern) + ": " + e.Err.Error()
+}
 
-	p := ignorePattern{pattern: pattern}
+// compilePattern parses a single gitignore-style line. It returns false if the
+// line is blank or a comment and does not hold any pattern.
+func compilePattern(line string) (ignorePattern, bool, error) {
+	line = trimPattern(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false, nil
+	}
+
+	p := ignorePattern{line: line, pattern: line}
 
-	// Handle negation
-	if strings.HasPrefix(pattern, "!") {
+	switch {
+	case strings.HasPrefix(line, "!"):
 		p.isNegate = true
-		p.pattern = pattern[1:]
+		p.pattern = line[1:]
+	case strings.HasPrefix(line, `\#`), strings.HasPrefix(line, `\!`):
+		// Escaped leading hash or exclamation mark, which is matched literally.
+		p.pattern = line[1:]
+	}
+	// Unescape the trailing space kept by trimPattern, so it is matched
+	// literally.
+	if r, size := utf8.DecodeLastRuneInString(p.pattern); unicode.IsSpace(r) {
+		p.pattern = p.pattern[:len(p.pattern)-size-1] + p.pattern[len(p.pattern)-size:]
 	}
 
 	// Handle directory-only patterns
@@ -52,10 +134,486 @@ func (im *IgnoreMatcher) AddPattern(pattern string) {
 		p.pattern = strings.TrimSuffix(p.pattern, "/")
 	}
 
-	im.patterns = append(im.patterns, p)
+	if _, err := path.Match(p.pattern, ""); err != nil {
+		return ignorePattern{}, false, &PatternError{Pattern: line, Err: filepath.ErrBadPattern}
+	}
+	return p, true, nil
+}
+
+// eventNames maps the names of events used in annotations of ignore file
+// lines, see LoadIgnoreFile, to the events.
+var eventNames = map[string]Event{
+	"create":     Create,
+	"remove":     Remove,
+	"write":      Write,
+	"rename":     Rename,
+	"attrib":     Attrib,
+	"closewrite": CloseWrite,
+}
+
+var errUnknownEventName = errors.New("unknown event name in annotation")
+
+// compileLine compiles a line of an ignore file, which unlike patterns added
+// with AddPattern may end with @event annotations, like "*.lock @write".
+func compileLine(line string) (ignorePattern, bool, error) {
+	line = trimPattern(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false, nil
+	}
+	pattern, events, err := splitAnnotations(line)
+	if err != nil {
+		return ignorePattern{}, false, &PatternError{Pattern: line, Err: err}
+	}
+	p, ok, err := compilePattern(pattern)
+	if err != nil {
+		err.(*PatternError).Pattern = line
+		return ignorePattern{}, false, err
+	}
+	if !ok {
+		return ignorePattern{}, false, nil
+	}
+	p.line, p.events = line, events
+	return p, true, nil
+}
+
+// splitAnnotations splits the trimmed line into the pattern and the events
+// given by the annotations which end it, 0 if there are none. An annotation is
+// a whitespace-separated word starting with "@", the whitespace can be escaped
+// with a backslash to make it a part of the pattern.
+func splitAnnotations(line string) (pattern string, events Event, err error) {
+	for {
+		i := strings.LastIndexFunc(line, unicode.IsSpace)
+		if i == -1 || !strings.HasPrefix(line[i+1:], "@") {
+			return line, events, nil
+		}
+		// An odd number of backslashes before the space escapes it.
+		n := 0
+		for n < i && line[i-1-n] == '\\' {
+			n++
+		}
+		if n%2 == 1 {
+			return line, events, nil
+		}
+		e, ok := eventNames[strings.ToLower(line[i+2:])]
+		if !ok {
+			return "", 0, errUnknownEventName
+		}
+		events |= e
+		line = trimPattern(line[:i])
+	}
+}
+
+// trimPattern removes the leading and trailing whitespace of a gitignore-style
+// line, except for a trailing space escaped with a backslash, like in
+// "name\ ", which is a part of the pattern.
+func trimPattern(line string) string {
+	line = strings.TrimLeftFunc(line, unicode.IsSpace)
+	trimmed := strings.TrimRightFunc(line, unicode.IsSpace)
+	if trimmed == line {
+		return line
+	}
+	// An odd number of backslashes before the first trailing space escapes it.
+	n := 0
+	for n < len(trimmed) && trimmed[len(trimmed)-1-n] == '\\' {
+		n++
+	}
+	if n%2 == 0 {
+		return trimmed
+	}
+	_, size := utf8.DecodeRuneInString(line[len(trimmed):])
+	return line[:len(trimmed)+size]
+}
+
+// AddPattern adds a gitignore-style pattern to the matcher. Blank patterns
+// and comments are skipped. It returns a non-nil *PatternError if the pattern
+// is malformed, in which case the pattern is not added.
+//
+// A pattern starting with a slash, like "/build/", is anchored: it matches
+// only at the root of the matcher, or at the directory of the ignore file it
+// was read from. Other patterns match at any level.
+//
+// A "**" segment matches any number of directories, as in gitignore:
+// "**/test" matches test at any level, "a/**/b" matches a/b and a/x/y/b, and
+// "logs/**" matches everything inside logs, but not logs itself.
+//
+// Patterns are matched in the order they were added and the last matching
+// one wins, so a negation like "!keep.log" re-includes a path only if it was
+// added after the pattern ignoring it. Like in git, a path cannot be
+// re-included if its parent directory is excluded: after "build/", the
+// negation "!build/keep.log" has no effect. To keep a file of an ignored
+// directory, ignore the content of the directory rather than the directory
+// itself, like "build/*" followed by "!build/keep.log". Watches never
+// descend into ignored directories.
+//
+// The pattern is parsed like a line of an ignore file, see LoadIgnoreFile.
+func (im *IgnoreMatcher) AddPattern(pattern string) error {
+	return im.AddPatterns(pattern)
+}
+
+// AddPatterns adds several gitignore-style patterns to the matcher at once,
+// which is cheaper than adding them one by one. Malformed patterns are skipped
+// and the error describing the first of them is returned.
+func (im *IgnoreMatcher) AddPatterns(patterns ...string) error {
+	compiled, err := compilePatterns(patterns)
+	im.addCompiled(compiled...)
+	return err
+}
+
+// compilePatterns compiles patterns, skipping blank lines, comments and
+// malformed patterns. The error describing the first malformed pattern is
+// returned alongside the others.
+func compilePatterns(patterns []string) ([]ignorePattern, error) {
+	var first error
+	compiled := make([]ignorePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, ok, err := compilePattern(pattern)
+		if err != nil && first == nil {
+			first = err
+		}
+		if ok {
+			compiled = append(compiled, p)
+		}
+	}
+	return compiled, first
+}
+
+// addCompiled appends compiled patterns to the matcher.
+func (im *IgnoreMatcher) addCompiled(ps ...ignorePattern) {
+	im.mu.Lock()
+	im.cache.reset()
+	im.patterns = append(im.patterns, ps...)
+	im.mu.Unlock()
+}
+
+var errBaseNotWithinRoot = errors.New("base is not within the root of the matcher")
+
+// AddPatternsWithBase adds gitignore-style patterns which are relative to the
+// directory base instead of the root of the matcher, like the patterns of an
+// ignore file nested below the root: "build/" added with base "sub" ignores
+// sub/build and sub/dir/build, but not build, while "/gen" ignores sub/gen
+// only. The patterns apply to paths within base only.
+//
+// The base is either absolute or relative to the root, it has to lie within
+// the root. Malformed patterns are handled like by AddPatterns.
+func (im *IgnoreMatcher) AddPatternsWithBase(base string, patterns []string) error {
+	relBase, ok := im.relSlash(base)
+	if !ok {
+		return errBaseNotWithinRoot
+	}
+	if relBase == "." {
+		relBase = ""
+	}
+	compiled, err := compilePatterns(patterns)
+	for i := range compiled {
+		compiled[i].base = relBase
+	}
+	im.addCompiled(compiled...)
+	return err
+}
+
+var errBasenameSep = errors.New("basename pattern must not contain a separator")
+
+// AddBasenamePattern adds a pattern which is matched against the last element
+// of the path only, like "TODO" or "*.swp", regardless of the directory the
+// path is in. Paths within a matching directory are ignored as well, since
+// a path below an ignored directory cannot be re-included. Negation and
+// a trailing slash, which makes the pattern match directories only, work like
+// for AddPattern, and the pattern takes part in the last-match-wins order of
+// all the patterns.
+//
+// The pattern must not contain a slash, otherwise a *PatternError is returned
+// and the pattern is not added.
+func (im *IgnoreMatcher) AddBasenamePattern(name string) error {
+	p, ok, err := compilePattern(name)
+	if err != nil || !ok {
+		return err
+	}
+	if strings.Contains(p.pattern, "/") {
+		return &PatternError{Pattern: name, Err: errBasenameSep}
+	}
+	p.basename = true
+	im.addCompiled(p)
+	return nil
+}
+
+// AddPatternForEvents works like AddPattern, but the pattern applies only to
+// the given events, e.g.
+//
+//	im.AddPatternForEvents("*.lock", notify.Write)
+//
+// ignores writes to lock files, still reporting their creation and removal.
+// Such patterns are taken into account only when the event is known, that is
+// by ShouldIgnoreEvent and when filtering events of watchpoints. For other
+// methods, like ShouldIgnore, they do not match at all, so they never make
+// watches prune a directory. An event set of 0 makes the pattern apply to all
+// events, like AddPattern does.
+func (im *IgnoreMatcher) AddPatternForEvents(pattern string, events Event) error {
+	p, ok, err := compilePattern(pattern)
+	if err != nil || !ok {
+		return err
+	}
+	p.events = events
+	im.addCompiled(p)
+	return nil
+}
+
+// ErrIneffectivePattern is reported by ValidatePatterns for a well-formed
+// pattern which has no effect, like "/" which matches nothing, or a negation
+// not preceded by any pattern it could negate.
+var ErrIneffectivePattern = errors.New("pattern has no effect")
+
+// ValidatePatterns checks the patterns without adding them to any matcher.
+// It returns a slice of the same length as patterns, holding a *PatternError
+// for each malformed or ineffective pattern, see ErrIneffectivePattern, and
+// nil for the valid ones. Blank patterns and comments are valid. The patterns
+// are checked as a whole, in the order AddPatterns would add them.
+func ValidatePatterns(patterns []string) []error {
+	errs := make([]error, len(patterns))
+	ignores := false
+	for i, pattern := range patterns {
+		p, ok, err := compilePattern(pattern)
+		switch {
+		case err != nil:
+			errs[i] = err
+		case !ok:
+		case strings.Trim(p.pattern, "/") == "", p.isNegate && !ignores:
+			errs[i] = &PatternError{Pattern: p.line, Err: ErrIneffectivePattern}
+		default:
+			ignores = ignores || !p.isNegate
+		}
+	}
+	return errs
+}
+
+// RemovePattern removes the first pattern equal to the given one. It reports
+// whether any pattern was removed.
+func (im *IgnoreMatcher) RemovePattern(pattern string) bool {
+	pattern = trimPattern(pattern)
+	im.mu.Lock()
+	im.cache.reset()
+	defer im.mu.Unlock()
+	for i, p := range im.patterns {
+		if p.line == pattern {
+			im.patterns = append(im.patterns[:i], im.patterns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetCaseInsensitive enables or disables case-insensitive matching. When
+// enabled, both patterns and tested paths are lowercased before matching,
+// which is what case-insensitive filesystems (Windows, macOS) expect.
+// The matcher is case-sensitive by default.
+func (im *IgnoreMatcher) SetCaseInsensitive(nocase bool) {
+	im.mu.Lock()
+	im.cache.reset()
+	im.nocase = nocase
+	im.mu.Unlock()
+}
+
+// SetUnicodeNormalization enables or disables Unicode normalization of both
+// patterns and tested paths before matching. macOS reports file names
+// decomposed (NFD), so a pattern with an accented character written in the
+// usual composed form (NFC), like "café/", does not match them otherwise.
+// When enabled, the characters of the Latin, Greek and Cyrillic scripts are
+// composed to NFC; other decomposed characters are matched as they are.
+// Normalization is disabled by default.
+func (im *IgnoreMatcher) SetUnicodeNormalization(nfc bool) {
+	im.mu.Lock()
+	im.cache.reset()
+	im.nfc = nfc
+	im.mu.Unlock()
+}
+
+// fold gives s in the form patterns and paths are compared in: composed if
+// Unicode normalization is enabled and lowercased if the matcher is
+// case-insensitive.
+func (im *IgnoreMatcher) fold(s string) string {
+	if im.nfc {
+		s = composeNFC(s)
+	}
+	if im.nocase {
+		s = strings.ToLower(s)
+	}
+	return s
 }
 
-// LoadIgnoreFile loads patterns from a .gitignore or .notifyignore file
+// SetIncludeOnly turns the matcher into an allowlist: files which do not match
+// any of the given gitignore-style patterns are ignored. Directories are never
+// ignored by include patterns, so recursive watches can still reach the files
+// within them. Ignore patterns take precedence, a file matching both an
+// include and an ignore pattern is ignored. Passing no patterns disables
+// the allowlist.
+//
+// Like ignore patterns, include patterns may be negated with "!", the last
+// matching pattern wins. A negation takes a file out of the allowlist, e.g.
+// {"*.go", "!*_test.go"} includes Go files other than tests. Negations alone
+// include nothing.
+//
+// If any of the patterns is malformed, the include patterns are left unchanged
+// and a *PatternError is returned.
+func (im *IgnoreMatcher) SetIncludeOnly(patterns []string) error {
+	includes := make([]ignorePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, ok, err := compilePattern(pattern)
+		if err != nil {
+			return err
+		}
+		if ok {
+			includes = append(includes, p)
+		}
+	}
+	im.mu.Lock()
+	im.cache.reset()
+	im.includes = includes
+	im.mu.Unlock()
+	return nil
+}
+
+// SetMaxFileSize makes the matcher ignore regular files larger than size
+// bytes, in addition to the ones matched by patterns. Paths which no longer
+// exist, like removed files, are never ignored because of their size.
+// A size of 0 or less disables the limit, which is the default.
+func (im *IgnoreMatcher) SetMaxFileSize(size int64) {
+	if size < 0 {
+		size = 0
+	}
+	im.mu.Lock()
+	im.cache.reset()
+	im.maxSize = size
+	im.mu.Unlock()
+}
+
+// SetCustomMatcher sets a function which decides whether paths are ignored
+// before the patterns are consulted, for rules gitignore syntax cannot
+// express, like ignoring files with a sibling ".nowatch" file. If fn reports
+// the path as handled, its decision is final and the patterns, include
+// patterns and the size limit are not consulted. Otherwise matching falls
+// through to them. A nil fn removes the function, which is the default.
+//
+// The function is given the path as it was passed to the matcher, or joined
+// with the root for ShouldIgnoreRel, and it is called for every matched path,
+// results of it are not cached. It must be safe for concurrent use. A
+// directory it ignores is not watched, so paths below it are never tested.
+func (im *IgnoreMatcher) SetCustomMatcher(fn func(path string) (ignore, handled bool)) {
+	im.mu.Lock()
+	im.custom = fn
+	im.mu.Unlock()
+}
+
+// SetHierarchical enables or disables per-directory ignore files. When enabled,
+// the matcher looks for .gitignore and .notifyignore files, or the ones named
+// with SetIgnoreFileNames, in every directory between the root and the tested
+// path and applies them the way git does: patterns are relative to the
+// directory of the file they come from, and files in deeper directories take
+// precedence over shallower ones. Patterns added to the matcher directly have
+// the lowest precedence.
+//
+// Ignore files are cached and reloaded when they change on disk.
+func (im *IgnoreMatcher) SetHierarchical(hier bool) {
+	im.mu.Lock()
+	im.cache.reset()
+	im.hier = hier
+	im.mu.Unlock()
+}
+
+// SetCacheSize enables caching of the results of up to n most recently
+// matched paths, which speeds up matching of paths tested over and over, like
+// the ones of files rebuilt in a loop. The cache is cleared whenever the rules
+// of the matcher change. A non-positive n disables the cache, which is the
+// default.
+//
+// The cache assumes that the type of a path, a directory or not, does not
+// change. It is not used while the matcher depends on other properties of
+// the files, see SetMaxFileSize and SetHierarchical.
+func (im *IgnoreMatcher) SetCacheSize(n int) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if n <= 0 {
+		im.cache = nil
+		return
+	}
+	im.cache = newMatchCache(n)
+}
+
+// Clone returns an independent copy of the matcher, with the same root,
+// patterns and settings, so patterns can be added to or removed from one of
+// them without affecting the other, like when tweaking the global matcher for
+// a single watchpoint:
+//
+//	im := notify.GetIgnoreMatcher().Clone()
+//	im.AddPattern("*.tmp")
+//	notify.WatchWithIgnore(path, c, im, notify.All)
+//
+// The caches of the matcher, if any, are not copied. Clone of a nil matcher
+// is nil.
+func (im *IgnoreMatcher) Clone() *IgnoreMatcher {
+	if im == nil {
+		return nil
+	}
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	clone := &IgnoreMatcher{
+		patterns: append(make([]ignorePattern, 0, len(im.patterns)), im.patterns...),
+		includes: append([]ignorePattern(nil), im.includes...),
+		root:     im.root,
+		abs:      im.abs,
+		nocase:   im.nocase,
+		nfc:      im.nfc,
+		hier:     im.hier,
+		maxSize:  im.maxSize,
+		custom:   im.custom,
+	}
+	if im.cache != nil {
+		clone.cache = newMatchCache(im.cache.max)
+	}
+	return clone
+}
+
+// Patterns returns a copy of the patterns currently in effect, in the order
+// they were added. Blank lines and comments are not included.
+func (im *IgnoreMatcher) Patterns() []string {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	patterns := make([]string, 0, len(im.patterns))
+	for _, p := range im.patterns {
+		patterns = append(patterns, p.line)
+	}
+	return patterns
+}
+
+// ClearPatterns removes all the patterns from the matcher.
+func (im *IgnoreMatcher) ClearPatterns() {
+	im.mu.Lock()
+	im.cache.reset()
+	im.patterns = nil
+	im.mu.Unlock()
+}
+
+// LoadIgnoreFile loads patterns from a .gitignore or .notifyignore file.
+// They are added after the existing patterns, so loading a file again adds
+// its patterns twice, use LoadIgnoreFileReplace for reloading.
+//
+// Lines are parsed as gitignore does: blank lines and lines starting with "#"
+// are skipped, trailing spaces are removed unless escaped with a backslash,
+// like in "name\ ", and a leading "\#" or "\!" stands for a pattern starting
+// with a literal hash or exclamation mark rather than a comment or negation.
+//
+// A line may end with annotations naming the events the pattern applies to,
+// like AddPatternForEvents does:
+//
+//	*.lock @write
+//	tmp/ @create @remove
+//
+// An annotation is "@" followed by one of create, remove, write, rename,
+// attrib or closewrite, separated from the pattern and other annotations by
+// whitespace. A pattern ending with such a word is written with the space
+// escaped, like "name\ @write". Lines without annotations apply to all
+// events.
+//
+// Malformed patterns are skipped, the error describing the first of them
+// is returned after all the valid patterns were added.
 func (im *IgnoreMatcher) LoadIgnoreFile(path string) error {
 	file, err := os.Open(path)
 	if err != nil {
@@ -65,75 +623,673 @@ func (im *IgnoreMatcher) LoadIgnoreFile(path string) error {
 		return err
 	}
 	defer file.Close()
+	return im.loadIgnore(file, path, false, false)
+}
 
-	scanner := bufio.NewScanner(file)
+// LoadIgnoreFileTracked works like LoadIgnoreFile, but the patterns remember
+// the file and the line they were read from, which MatchSource reports. It is
+// meant for layering several ignore files, where it is hard to tell which of
+// them a pattern comes from. The file is recorded as given by path.
+func (im *IgnoreMatcher) LoadIgnoreFileTracked(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	return im.loadIgnore(file, path, false, true)
+}
+
+// LoadIgnoreFileReplace works like LoadIgnoreFile, but the patterns loaded
+// from the file replace all the patterns of the matcher, which is what
+// reloading a modified file needs. LoadIgnoreFile, which appends, is meant for
+// layering files, like a base one and an overlay. Include patterns, see
+// SetIncludeOnly, are not affected.
+//
+// The patterns are replaced at once, so concurrent matching sees either the
+// old or the new ones. If the file does not exist, all the patterns are
+// removed. If it cannot be read, the patterns are left unchanged.
+func (im *IgnoreMatcher) LoadIgnoreFileReplace(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			im.ClearPatterns()
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	return im.loadIgnore(file, path, true, false)
+}
+
+// LoadIgnoreReader loads gitignore-style patterns from r, one per line.
+// It behaves like LoadIgnoreFile, except errors for malformed patterns
+// carry only the line number.
+func (im *IgnoreMatcher) LoadIgnoreReader(r io.Reader) error {
+	return im.loadIgnore(r, "", false, false)
+}
+
+// LoadIgnoreString loads gitignore-style patterns from s, separated by sep,
+// like a colon-separated list taken from an environment variable:
+//
+//	im.LoadIgnoreString(os.Getenv("NOTIFY_IGNORE"), ":")
+//
+// An empty sep stands for a newline. Each of the patterns is parsed like
+// a line of an ignore file, see LoadIgnoreFile, so blank ones and comments are
+// skipped. The patterns are added at once, after the existing ones. Errors for
+// malformed patterns carry their position within the list as the line number.
+func (im *IgnoreMatcher) LoadIgnoreString(s, sep string) error {
+	if sep == "" {
+		sep = "\n"
+	}
+	return im.loadLines(strings.Split(s, sep), "", false, false)
+}
+
+// loadIgnore reads patterns from r and adds them to the matcher at once, or
+// replaces the existing ones with them, see loadLines.
+func (im *IgnoreMatcher) loadIgnore(r io.Reader, name string, replace, track bool) error {
+	var lines []string
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		im.AddPattern(scanner.Text())
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return im.loadLines(lines, name, replace, track)
+}
+
+// loadLines compiles the patterns and adds them to the matcher at once, or
+// replaces the existing ones with them. The name is used for error reporting,
+// and recorded in the patterns if track is true.
+func (im *IgnoreMatcher) loadLines(lines []string, name string, replace, track bool) error {
+	var (
+		patterns []ignorePattern
+		first    error
+	)
+	for i, line := range lines {
+		n := i + 1
+		p, ok, err := compileLine(line)
+		if err != nil {
+			if first == nil {
+				perr := err.(*PatternError)
+				perr.File, perr.Line = name, n
+				first = perr
+			}
+			continue
+		}
+		if ok {
+			if track {
+				p.file, p.lineno = name, n
+			}
+			patterns = append(patterns, p)
+		}
 	}
 
-	return scanner.Err()
+	im.mu.Lock()
+	im.cache.reset()
+	if replace {
+		im.patterns = patterns
+	} else {
+		im.patterns = append(im.patterns, patterns...)
+	}
+	im.mu.Unlock()
+	return first
 }
 
 // ShouldIgnore returns true if the given path should be ignored
+//
+// Whether the path is a directory, which matters for directory-only patterns
+// like "build/", is told by a trailing slash or by stat'ing the path. If it
+// does not exist, e.g. it was already removed, directory-only patterns match
+// it as if it was a directory. An existing file is never matched by
+// a directory-only pattern of its own name, like a file named build by
+// "build/". Use ShouldIgnoreDir or ShouldIgnoreFile if the type of the path
+// is known.
+//
+// A relative path is matched as relative to the root of the matcher. Paths
+// outside of the root, like its parent directories or paths on another
+// volume, are never ignored.
 func (im *IgnoreMatcher) ShouldIgnore(path string) bool {
-	if im == nil || len(im.patterns) == 0 {
+	ignored, _ := im.MatchReason(path)
+	return ignored
+}
+
+// ShouldIgnoreDir works like ShouldIgnore for a path known to be a directory.
+func (im *IgnoreMatcher) ShouldIgnoreDir(path string) bool {
+	ignored, _ := im.matchReason(path, kindDir, 0)
+	return ignored
+}
+
+// ShouldIgnoreFile works like ShouldIgnore for a path known not to be
+// a directory. Directory-only patterns match it only if they match any of its
+// parent directories.
+func (im *IgnoreMatcher) ShouldIgnoreFile(path string) bool {
+	ignored, _ := im.matchReason(path, kindFile, 0)
+	return ignored
+}
+
+// ShouldIgnoreBatch works like ShouldIgnore for each of the paths, the result
+// for a path is at the same index as the path. It is cheaper than calling
+// ShouldIgnore in a loop, especially for paths sharing their directories,
+// like the ones of a directory listing: the patterns are locked once, and the
+// per-directory ignore files, see SetHierarchical, are looked up once per
+// directory. Ignore files changed during the call may not be picked up.
+func (im *IgnoreMatcher) ShouldIgnoreBatch(paths []string) []bool {
+	ignored := make([]bool, len(paths))
+	if im == nil {
+		return ignored
+	}
+	im.mu.RLock()
+	custom := im.custom
+	im.mu.RUnlock()
+	handled := make([]bool, len(paths))
+	if custom != nil {
+		for i, path := range paths {
+			if ignore, ok := custom(path); ok {
+				ignored[i], handled[i] = ignore, true
+			}
+		}
+	}
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	if len(im.patterns) == 0 && len(im.includes) == 0 && !im.hier && im.maxSize == 0 {
+		return ignored
+	}
+	dirs := make(dirMemo)
+	for i, path := range paths {
+		if handled[i] {
+			continue
+		}
+		relPath, ok := im.rel(path)
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(path) && filepath.IsAbs(im.root) {
+			path = filepath.Join(im.root, path)
+		}
+		ignored[i], _ = im.matchRel(im.root, relPath, path, kindUnknown, 0, dirs)
+	}
+	return ignored
+}
+
+// pathKind tells what is known about the type of a matched path.
+type pathKind uint8
+
+const (
+	kindUnknown pathKind = iota
+	kindFile
+	kindDir
+)
+
+// ShouldIgnoreEvent works like ShouldIgnore for the path of an event of the
+// given type, matching also the patterns added for the event with
+// AddPatternForEvents.
+func (im *IgnoreMatcher) ShouldIgnoreEvent(path string, ev Event) bool {
+	ignored, _ := im.matchReason(path, kindUnknown, ev)
+	return ignored
+}
+
+// shouldIgnore is like ShouldIgnoreEvent with the type of the path given by
+// kind. An ev of 0 matches patterns which apply to all events only.
+func (im *IgnoreMatcher) shouldIgnore(path string, kind pathKind, ev Event) bool {
+	ignored, _ := im.matchReason(path, kind, ev)
+	return ignored
+}
+
+// MatchReason reports whether the given path should be ignored together with
+// the pattern responsible for the decision, as it was added to the matcher.
+// The pattern is a negation (e.g. "!build/important.log") if the path is not
+// ignored because it was re-included, and it is empty if no pattern matched,
+// which includes paths ignored because of their size or because they do not
+// match any include pattern.
+func (im *IgnoreMatcher) MatchReason(path string) (ignored bool, pattern string) {
+	ignored, src := im.matchReason(path, kindUnknown, 0)
+	return ignored, src.Pattern
+}
+
+// MatchSource works like MatchReason, but it tells also the ignore file and
+// the line the responsible pattern comes from, which helps to trace
+// a surprising decision in a tree with many ignore files. The file is known
+// for patterns loaded with LoadIgnoreFileTracked and for the ones of
+// per-directory ignore files, see SetHierarchical.
+func (im *IgnoreMatcher) MatchSource(path string) (ignored bool, src PatternSource) {
+	return im.matchReason(path, kindUnknown, 0)
+}
+
+func (im *IgnoreMatcher) matchReason(path string, kind pathKind, ev Event) (ignored bool, src PatternSource) {
+	if im == nil {
+		return false, PatternSource{}
+	}
+	if ignored, handled := im.matchCustom(path); handled {
+		return ignored, PatternSource{}
+	}
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	if len(im.patterns) == 0 && len(im.includes) == 0 && !im.hier && im.maxSize == 0 {
+		return false, PatternSource{}
+	}
+
+	relPath, ok := im.rel(path)
+	if !ok {
+		return false, PatternSource{}
+	}
+	if !filepath.IsAbs(path) && filepath.IsAbs(im.root) {
+		// The path is relative to the root, not to the working directory.
+		path = filepath.Join(im.root, path)
+	}
+	return im.matchRel(im.root, relPath, path, kind, ev, nil)
+}
+
+// matchCustom consults the function set with SetCustomMatcher, if any. It is
+// called without the mutex held, so the function may use the matcher.
+func (im *IgnoreMatcher) matchCustom(path string) (ignored, handled bool) {
+	im.mu.RLock()
+	custom := im.custom
+	im.mu.RUnlock()
+	if custom == nil {
+		return false, false
+	}
+	return custom(path)
+}
+
+// ShouldIgnoreFrom works like ShouldIgnore, but path is matched as relative to
+// base rather than to the root of the matcher, so a single set of patterns can
+// be applied to several trees without cloning the matcher. A relative path is
+// taken as relative to base, paths outside of base are never ignored.
+//
+// Anchored patterns, like "/build", match paths right below base, and patterns
+// added with AddPatternsWithBase apply below their directory within base. With
+// SetHierarchical, per-directory ignore files are looked up within base as
+// well.
+func (im *IgnoreMatcher) ShouldIgnoreFrom(base, path string) bool {
+	if im == nil {
 		return false
 	}
+	if abs, err := filepath.Abs(base); err == nil {
+		base = abs
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	if ignored, handled := im.matchCustom(path); handled {
+		return ignored
+	}
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	if len(im.patterns) == 0 && len(im.includes) == 0 && !im.hier && im.maxSize == 0 {
+		return false
+	}
+	relPath, ok := relSlash(base, path)
+	if !ok {
+		return false
+	}
+	ignored, _ := im.matchRel(base, im.fold(relPath), path, kindUnknown, 0, nil)
+	return ignored
+}
 
-	// Convert to relative path if absolute
-	relPath, err := filepath.Rel(im.root, path)
-	if err != nil {
-		relPath = path
+// ShouldIgnoreRel works like ShouldIgnore for a path which is already relative
+// to the root of the matcher, like "src/main.go". The path must be cleaned and
+// slash-separated, also on Windows, it is matched as-is, which saves the cost
+// of making it relative for callers which have it at hand. A trailing slash
+// marks a directory. Paths starting with "../" are never ignored.
+func (im *IgnoreMatcher) ShouldIgnoreRel(relPath string) bool {
+	if im == nil {
+		return false
+	}
+	if ignored, handled := im.matchCustom(filepath.Join(im.root, filepath.FromSlash(relPath))); handled {
+		return ignored
+	}
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	if len(im.patterns) == 0 && len(im.includes) == 0 && !im.hier && im.maxSize == 0 {
+		return false
+	}
+	if relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return false
 	}
+	// The path is needed for stat'ing only.
+	path := filepath.Join(im.root, filepath.FromSlash(relPath))
+	relPath = im.fold(relPath)
+	ignored, _ := im.matchRel(im.root, relPath, path, kindUnknown, 0, nil)
+	return ignored
+}
 
-	// Normalize path separators and trim leading ./
-	relPath = filepath.ToSlash(relPath)
+// MatchPatterns reports whether the gitignore-style patterns ignore path, for
+// one-off checks which do not need an IgnoreMatcher. The path is taken as
+// relative to the directory the patterns apply to, paths starting with "../"
+// are never ignored. Nothing is read from the filesystem: the path is
+// a directory only if it has a trailing slash, like "build/". Patterns which
+// need the filesystem, like the ones of per-directory ignore files, are not
+// supported.
+//
+// If any of the patterns is malformed, a *PatternError is returned.
+func MatchPatterns(patterns []string, path string) (bool, error) {
+	im := &IgnoreMatcher{}
+	if err := im.AddPatterns(patterns...); err != nil {
+		return false, err
+	}
+	relPath, kind := filepath.ToSlash(path), kindFile
+	if strings.HasSuffix(relPath, "/") {
+		relPath, kind = strings.TrimRight(relPath, "/"), kindDir
+	}
 	relPath = strings.TrimPrefix(relPath, "./")
+	if relPath == "" || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return false, nil
+	}
+	// A path below an excluded directory is excluded, like in git.
+	for i := range relPath {
+		if relPath[i] != '/' {
+			continue
+		}
+		if ignored, _ := im.match(im.patterns, relPath[:i], kindDir, 0, false, PatternSource{}); ignored {
+			return true, nil
+		}
+	}
+	ignored, _ := im.match(im.patterns, relPath, kind, 0, false, PatternSource{})
+	return ignored, nil
+}
 
-	// Determine if path is a directory syntactically to avoid FS stat flakiness
-	isDir := strings.HasSuffix(relPath, "/")
-	if !isDir {
-		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
-			isDir = true
+// matchRel matches relPath, which is path made relative to the root of the
+// matcher, with the mutex held. If the type of the path is not known, it is
+// told by a trailing slash or by stat'ing the path, before the cache is
+// consulted, so a cached result never applies to a path which type changed.
+// The patterns of per-directory ignore files are memoized in dirs, if it is
+// not nil.
+func (im *IgnoreMatcher) matchRel(root, relPath, path string, kind pathKind, ev Event, dirs dirMemo) (ignored bool, src PatternSource) {
+	if kind == kindUnknown {
+		if strings.HasSuffix(relPath, "/") {
+			kind = kindDir
+		} else if fi, err := os.Stat(path); err == nil {
+			kind = kindFile
+			if fi.IsDir() {
+				kind = kindDir
+			}
 		}
 	}
+	if im.cache == nil || im.hier || im.maxSize > 0 {
+		return im.matchRelUncached(root, relPath, path, kind, ev, dirs)
+	}
+	key := matchKey{relPath: relPath, kind: kind, ev: ev}
+	if ignored, src, ok := im.cache.get(key); ok {
+		return ignored, src
+	}
+	ignored, src = im.matchRelUncached(root, relPath, path, kind, ev, dirs)
+	im.cache.put(key, ignored, src)
+	return ignored, src
+}
 
-	ignored := false
-	for _, p := range im.patterns {
+func (im *IgnoreMatcher) matchRelUncached(root, relPath, path string, kind pathKind, ev Event, dirs dirMemo) (ignored bool, src PatternSource) {
+	isDir := kind == kindDir
+
+	// Like in git, a path cannot be re-included if its parent directory is
+	// excluded, whichever patterns match the path itself.
+	if i := strings.LastIndex(strings.TrimSuffix(relPath, "/"), "/"); i != -1 {
+		if ignored, src = im.matchRel(root, relPath[:i], filepath.Dir(path), kindDir, ev, dirs); ignored {
+			return ignored, src
+		}
+	}
+	ignored, src = im.match(im.patterns, relPath, kind, ev, false, PatternSource{})
+	if im.hier && relPath != "." {
+		ignored, src = im.match(dirs.patterns(im, root, relPath), relPath, kind, ev, ignored, src)
+	}
+	if !ignored && len(im.includes) != 0 && !isDir {
+		if included, _ := im.match(im.includes, relPath, kind, ev, false, PatternSource{}); !included {
+			return true, PatternSource{}
+		}
+	}
+	if !ignored && im.maxSize > 0 {
+		// Paths which cannot be stat'ed, e.g. removed files, are not ignored.
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() && fi.Size() > im.maxSize {
+			return true, PatternSource{}
+		}
+	}
+	return ignored, src
+}
+
+// rel gives path relative to the root of the matcher, in the form patterns
+// are matched against. A relative path is taken as relative to the root
+// already. It returns false if path lies outside of the root, like on another
+// volume or above the root.
+func (im *IgnoreMatcher) rel(path string) (string, bool) {
+	relPath, ok := im.relSlash(path)
+	if ok {
+		relPath = im.fold(relPath)
+	}
+	return relPath, ok
+}
+
+// relSlash is like rel, but it keeps the case of path regardless of the case
+// sensitivity of the matcher.
+func (im *IgnoreMatcher) relSlash(path string) (string, bool) {
+	root := im.root
+	if filepath.IsAbs(path) && im.abs != "" {
+		root = im.abs
+	}
+	return relSlash(root, path)
+}
+
+// relSlash gives path relative to root, slash-separated. A relative path is
+// taken as relative to root already. It returns false if path lies outside
+// of root.
+func relSlash(root, path string) (string, bool) {
+	relPath := path
+	if filepath.IsAbs(path) || !filepath.IsAbs(root) {
+		var err error
+		if relPath, err = filepath.Rel(root, path); err != nil {
+			return "", false
+		}
+	}
+
+	// Normalize path separators and trim leading ./
+	relPath = filepath.ToSlash(relPath)
+	relPath = strings.TrimPrefix(relPath, "./")
+	if relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return "", false
+	}
+	return relPath, true
+}
+
+// prune reports whether the directory at path is ignored together with
+// everything below it, so it does not need to be watched nor walked at all.
+// Since paths below an ignored directory cannot be re-included, it is the
+// case for every ignored directory.
+func (im *IgnoreMatcher) prune(path string) bool {
+	return im.shouldIgnore(path, kindDir, 0)
+}
+
+// match applies patterns in order to relPath, the last matching one decides
+// whether the path is ignored and is returned as the reason. If none of them
+// matches, ignored and reason are returned unchanged. Directory-only patterns
+// are matched according to kind, see matchDir. Patterns which apply to some
+// events only are skipped unless ev is one of them.
+func (im *IgnoreMatcher) match(patterns []ignorePattern, relPath string, kind pathKind, ev Event, ignored bool, reason PatternSource) (bool, PatternSource) {
+	for i := range patterns {
+		p := &patterns[i]
+		if p.events != 0 && p.events&ev == 0 {
+			continue
+		}
+		relPath := relPath
+		if base := p.base; base != "" {
+			base = im.fold(base)
+			if !strings.HasPrefix(relPath, base+"/") {
+				continue
+			}
+			relPath = relPath[len(base)+1:]
+		}
 		pat := strings.TrimPrefix(p.pattern, "./")
+		pat = im.fold(pat)
 
+		if p.basename {
+			name := relPath[strings.LastIndex(relPath, "/")+1:]
+			if (!p.isDir || kind != kindFile) && matchPath(pat, name) {
+				ignored, reason = !p.isNegate, p.source()
+			}
+			continue
+		}
 		// Directory patterns should match the dir itself or anything under it
+		if p.isDir && kind != kindUnknown {
+			if im.matchDir(pat, relPath, kind) {
+				ignored, reason = !p.isNegate, p.source()
+			}
+			continue
+		}
 		if p.isDir {
 			// Exact dir match
 			if im.matchPattern(pat, relPath) || strings.HasPrefix(relPath+"/", pat+"/") {
-				if p.isNegate {
-					ignored = false
-				} else {
-					ignored = true
-				}
+				ignored, reason = !p.isNegate, p.source()
 				continue
 			}
 		}
 
 		// Regular pattern matching (files or generic globs)
 		if im.matchPattern(pat, relPath) {
-			if p.isNegate {
-				ignored = false
-			} else {
-				ignored = true
-			}
+			ignored, reason = !p.isNegate, p.source()
 		}
 	}
 
-	return ignored
+	return ignored, reason
+}
+
+// matchDir reports whether the directory-only pattern matches relPath, which
+// is known to be a file or a directory. A file is matched only if the pattern
+// matches any of its parent directories.
+func (im *IgnoreMatcher) matchDir(pat, relPath string, kind pathKind) bool {
+	if kind == kindDir {
+		return im.matchPattern(pat, relPath) || strings.HasPrefix(relPath+"/", pat+"/")
+	}
+	i := strings.LastIndex(relPath, "/")
+	if i == -1 {
+		return false
+	}
+	return im.matchPattern(pat, relPath[:i]) || strings.HasPrefix(relPath, pat+"/")
+}
+
+// defaultIgnoreFileNames are the default names of the per-directory ignore
+// files, in the order they are applied.
+var defaultIgnoreFileNames = []string{".gitignore", ".notifyignore"}
+
+var (
+	ignoreFileNamesMu sync.RWMutex // protects ignoreFileNames
+	ignoreFileNames   = defaultIgnoreFileNames
+)
+
+// SetIgnoreFileNames sets the names of the per-directory ignore files which
+// hierarchical matchers look for, see SetHierarchical and
+// EnableGitignoreAutoload, like ".watchignore" for an application-specific
+// file. Files found in a directory are applied in the given order, so
+// patterns of a later one take precedence over ones of an earlier one. The
+// default is ".gitignore" followed by ".notifyignore", which is restored when
+// no names are given.
+//
+// The names are ignored themselves by the built-in default patterns, see
+// DefaultIgnorePatterns. Matchers in use pick up the names with the next
+// matched path.
+func SetIgnoreFileNames(names ...string) {
+	ignoreFileNamesMu.Lock()
+	defer ignoreFileNamesMu.Unlock()
+	if len(names) == 0 {
+		ignoreFileNames = defaultIgnoreFileNames
+		return
+	}
+	ignoreFileNames = append([]string(nil), names...)
+}
+
+// IgnoreFileNames returns the names of the per-directory ignore files, see
+// SetIgnoreFileNames.
+func IgnoreFileNames() []string {
+	ignoreFileNamesMu.RLock()
+	defer ignoreFileNamesMu.RUnlock()
+	return append([]string(nil), ignoreFileNames...)
+}
+
+// ignoreFile is a cached, compiled per-directory ignore file.
+type ignoreFile struct {
+	modTime  time.Time
+	size     int64
+	patterns []ignorePattern
+}
+
+// dirPatterns returns patterns from ignore files of every directory between
+// the root and relPath, ordered from the shallowest directory to the deepest.
+func (im *IgnoreMatcher) dirPatterns(root, relPath string) []ignorePattern {
+	var patterns []ignorePattern
+	ignoreFileNamesMu.RLock()
+	names := ignoreFileNames
+	ignoreFileNamesMu.RUnlock()
+	dirs := strings.Split(relPath, "/")
+	for i := range dirs {
+		base := strings.Join(dirs[:i], "/")
+		for _, name := range names {
+			patterns = append(patterns, im.ignoreFile(root, base, name)...)
+		}
+	}
+	return patterns
+}
+
+// dirMemo memoizes the patterns of per-directory ignore files which apply to
+// the paths within a directory, by the directory relative to the root.
+type dirMemo map[string][]ignorePattern
+
+// patterns works like dirPatterns, looking the patterns up in m first. A nil
+// m memoizes nothing.
+func (m dirMemo) patterns(im *IgnoreMatcher, root, relPath string) []ignorePattern {
+	if m == nil {
+		return im.dirPatterns(root, relPath)
+	}
+	// The patterns depend only on the parent directory of relPath.
+	dir := ""
+	if i := strings.LastIndex(relPath, "/"); i != -1 {
+		dir = relPath[:i]
+	}
+	patterns, ok := m[dir]
+	if !ok {
+		patterns = im.dirPatterns(root, relPath)
+		m[dir] = patterns
+	}
+	return patterns
+}
+
+// ignoreFile returns patterns from the ignore file with the given name that is
+// placed in the base directory below root, reloading it if it changed since
+// last call. Malformed patterns are skipped.
+func (im *IgnoreMatcher) ignoreFile(root, base, name string) []ignorePattern {
+	file := filepath.Join(root, filepath.FromSlash(base), name)
+	im.filesMu.Lock()
+	defer im.filesMu.Unlock()
+	fi, err := os.Stat(file)
+	if err != nil || fi.IsDir() {
+		delete(im.files, file)
+		return nil
+	}
+	if f, ok := im.files[file]; ok && f.modTime.Equal(fi.ModTime()) && f.size == fi.Size() {
+		return f.patterns
+	}
+	f := &ignoreFile{modTime: fi.ModTime(), size: fi.Size()}
+	if r, err := os.Open(file); err == nil {
+		scanner := bufio.NewScanner(r)
+		for n := 1; scanner.Scan(); n++ {
+			if p, ok, _ := compileLine(scanner.Text()); ok {
+				p.base, p.file, p.lineno = base, file, n
+				f.patterns = append(f.patterns, p)
+			}
+		}
+		r.Close()
+	}
+	if im.files == nil {
+		im.files = make(map[string]*ignoreFile)
+	}
+	im.files[file] = f
+	return f.patterns
 }
 
 // matchPattern implements gitignore-style pattern matching
 func (im *IgnoreMatcher) matchPattern(pattern, path string) bool {
 	// Handle patterns starting with /
 	if strings.HasPrefix(pattern, "/") {
-		pattern = pattern[1:]
-		return im.matchGlob(pattern, path)
+		return im.matchAnchored(pattern[1:], path)
 	}
 
 	// Check exact match first
@@ -157,6 +1313,22 @@ func (im *IgnoreMatcher) matchPattern(pattern, path string) bool {
 	return false
 }
 
+// matchAnchored matches a pattern anchored at the root against the path and
+// each of its parent directories, so it matches only at the root and not in
+// subdirectories.
+func (im *IgnoreMatcher) matchAnchored(pattern, path string) bool {
+	if strings.Contains(pattern, "**") {
+		return im.matchDoublestar(pattern, path)
+	}
+	parts := strings.Split(path, "/")
+	for i := len(parts); i > 0; i-- {
+		if matchPath(pattern, strings.Join(parts[:i], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
 // matchGlob implements basic glob matching
 func (im *IgnoreMatcher) matchGlob(pattern, path string) bool {
 	// Handle ** for recursive matching
@@ -165,15 +1337,14 @@ func (im *IgnoreMatcher) matchGlob(pattern, path string) bool {
 	}
 
 	// Simple glob matching
-	matched, _ := filepath.Match(pattern, path)
-	if matched {
+	if matchPath(pattern, path) {
 		return true
 	}
 
 	// Check if pattern matches any parent directory
 	parts := strings.Split(path, "/")
 	for i := range parts {
-		if matched, _ := filepath.Match(pattern, parts[i]); matched {
+		if matchPath(pattern, parts[i]) {
 			return true
 		}
 	}
@@ -181,80 +1352,107 @@ func (im *IgnoreMatcher) matchGlob(pattern, path string) bool {
 	return false
 }
 
-// matchDoublestar handles ** patterns
+// matchDoublestar matches a pattern with "**" segments against the path and
+// each of its parent directories. Like in gitignore, a leading "**/" matches
+// in all directories, "/**/" matches zero or more directories, and a trailing
+// "/**" matches everything inside, but not the directory itself: "logs/**"
+// matches "logs/a" and "logs/a/b", but not "logs". A bare "**" matches every
+// path. Other consecutive asterisks, like in "a**b", are regular wildcards.
 func (im *IgnoreMatcher) matchDoublestar(pattern, path string) bool {
-	// Normalize
-	pattern = filepath.ToSlash(pattern)
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "/")
 	path = filepath.ToSlash(path)
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
 
-	// Edge case: pattern is just **
-	if strings.Trim(pattern, "/") == "**" {
-		return true
-	}
+// matchPath reports whether the slash-separated name matches the shell
+// pattern. Paths are matched with slashes on all platforms, so it uses
+// path.Match: unlike filepath.Match on Windows, it never treats a backslash as
+// a separator, which would let wildcards match across directories.
+func matchPath(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
 
-	// Split pattern by ** and ensure order
-	parts := strings.Split(pattern, "**")
-	// Allow multiple ** by scanning sequentially
-	cur := path
-	leading := strings.TrimSuffix(parts[0], "/")
-	if leading != "" {
-		if !strings.HasPrefix(cur, strings.TrimPrefix(leading, "/")) {
+// matchSegments reports whether the pattern segments match the leading path
+// segments, so that a pattern matching a directory matches everything within
+// it as well.
+func matchSegments(pats, parts []string) bool {
+	for len(pats) != 0 {
+		if pats[0] == "**" {
+			if len(pats) == 1 {
+				return len(parts) != 0
+			}
+			for i := range parts {
+				if matchSegments(pats[1:], parts[i:]) {
+					return true
+				}
+			}
 			return false
 		}
-		cur = strings.TrimPrefix(cur, strings.TrimPrefix(leading, "/"))
-		cur = strings.TrimPrefix(cur, "/")
-	}
-	// For each remaining segment after **, ensure it appears in order
-	for i := 1; i < len(parts); i++ {
-		seg := strings.Trim(parts[i], "/")
-		if seg == "" {
-			continue
+		if len(parts) == 0 {
+			return false
 		}
-		// Find seg anywhere in cur boundaries
-		idx := indexGlob(cur, seg)
-		if idx == -1 {
+		if !matchPath(pats[0], parts[0]) {
 			return false
 		}
-		cur = cur[idx+len(seg):]
+		pats, parts = pats[1:], parts[1:]
 	}
 	return true
 }
 
-// indexGlob finds the first index where glob seg matches in s (prefix match)
-func indexGlob(s, seg string) int {
-	// Fast path: literal substring
-	if !strings.ContainsAny(seg, "*?[") {
-		return strings.Index(s, seg)
-	}
-	// Try all cut points
-	for i := 0; i <= len(s); i++ {
-		if ok, _ := filepath.Match(seg, s[i:]); ok {
-			return i
-		}
-	}
-	return -1
+// builtinIgnorePatterns are the default ignore patterns unless they are
+// overridden with SetDefaultIgnorePatterns.
+var builtinIgnorePatterns = []string{
+	".git/",
+	".svn/",
+	".hg/",
+	".bzr/",
+	"node_modules/",
+	"vendor/",
+	"*.swp",
+	"*.swo",
+	"*~",
+	".DS_Store",
+	"Thumbs.db",
+	"__pycache__/",
+	"*.pyc",
+	".idea/",
+	".vscode/",
+	"*.log",
 }
 
-// DefaultIgnorePatterns returns common patterns that should be ignored by default
+var (
+	defaultPatternsMu sync.RWMutex // protects defaultPatterns
+	defaultPatterns   []string     // nil for the built-in defaults
+)
+
+// DefaultIgnorePatterns returns common patterns that should be ignored by default.
+// The returned slice is a copy, modifying it does not change the defaults,
+// use SetDefaultIgnorePatterns for that.
+//
+// The built-in defaults include the names of the ignore files, see
+// SetIgnoreFileNames.
 func DefaultIgnorePatterns() []string {
-	return []string{
-		".git/",
-		".svn/",
-		".hg/",
-		".bzr/",
-		"node_modules/",
-		"vendor/",
-		"*.swp",
-		"*.swo",
-		"*~",
-		".DS_Store",
-		"Thumbs.db",
-		"__pycache__/",
-		"*.pyc",
-		".idea/",
-		".vscode/",
-		"*.log",
-		".notifyignore",
-		".gitignore",
-	}
-}
\ No newline at end of file
+	defaultPatternsMu.RLock()
+	defer defaultPatternsMu.RUnlock()
+	if defaultPatterns == nil {
+		return append(append([]string(nil), builtinIgnorePatterns...), IgnoreFileNames()...)
+	}
+	return append([]string(nil), defaultPatterns...)
+}
+
+// SetDefaultIgnorePatterns overrides the process-wide default ignore patterns
+// returned by DefaultIgnorePatterns and installed by
+// EnableDefaultIgnorePatterns. The patterns are copied. A nil slice restores
+// the built-in defaults.
+//
+// It does not affect patterns which were already installed.
+func SetDefaultIgnorePatterns(patterns []string) {
+	defaultPatternsMu.Lock()
+	defer defaultPatternsMu.Unlock()
+	if patterns == nil {
+		defaultPatterns = nil
+		return
+	}
+	defaultPatterns = append([]string{}, patterns...)
+}
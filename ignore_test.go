@@ -5,9 +5,14 @@
 package notify
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -57,7 +62,7 @@ func TestIgnoreMatcher(t *testing.T) {
 		{filepath.Join(tmpDir, "node_modules", "package1"), true},
 		{filepath.Join(tmpDir, "build"), true},
 		{filepath.Join(tmpDir, "build", "output"), true},
-		{filepath.Join(tmpDir, "build", "important.log"), false}, // negated
+		{filepath.Join(tmpDir, "build", "important.log"), true}, // parent directory excluded
 		{filepath.Join(tmpDir, "src"), false},
 		{filepath.Join(tmpDir, "src", "main"), false},
 		{filepath.Join(tmpDir, "docs"), false},
@@ -150,7 +155,7 @@ create_files:
 
 	// Collect events
 	time.Sleep(300 * time.Millisecond)
-	
+
 	events := make([]EventInfo, 0)
 	done := false
 	for !done {
@@ -238,7 +243,7 @@ node_modules/
 		{filepath.Join(tmpDir, "test.tmp"), true},
 		{filepath.Join(tmpDir, "build"), true},
 		{filepath.Join(tmpDir, "build", "output.bin"), true},
-		{filepath.Join(tmpDir, "build", "keep.txt"), false}, // negated
+		{filepath.Join(tmpDir, "build", "keep.txt"), true}, // parent directory excluded
 		{filepath.Join(tmpDir, "node_modules"), true},
 		{filepath.Join(tmpDir, "src"), false},
 	}
@@ -285,4 +290,1588 @@ func TestDoublestarPatterns(t *testing.T) {
 			t.Errorf("ShouldIgnore(%s) = %v, expected %v", test.path, result, test.expected)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestDoublestarEdgeCases(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		path    string
+		dir     bool
+		want    bool
+	}{
+		// A trailing "/**" matches the contents only, like in git.
+		{"logs/**", "logs", true, false},
+		{"logs/**", "logs/a.log", false, true},
+		{"logs/**", "logs/a/b.log", false, true},
+		{"logs/**", "logs/a", true, true},
+		{"logs/**", "logsx/a.log", false, false},
+		{"logs/**", "src/logs/a.log", false, true},
+		{"/logs/**", "src/logs/a.log", false, false},
+		{"logs/", "logs", true, true},
+		// Directories inside only.
+		{"logs/**/", "logs/a.log", false, false},
+		{"logs/**/", "logs/a", true, true},
+		{"logs/**/", "logs/a/b.log", false, true},
+		// A bare "**" matches everything.
+		{"**", "a", false, true},
+		{"**", "a/b/c", true, true},
+		{"/**", "a/b", false, true},
+		// A middle "/**/" matches zero or more directories.
+		{"a/**/b", "a/b", true, true},
+		{"a/**/b", "a/x/y/b", true, true},
+		{"a/**/b", "a/x/y/b/c", false, true},
+		{"a/**/b", "a/x/yb", false, false},
+		// Other consecutive asterisks are regular wildcards.
+		{"a**b", "axxb", false, true},
+		{"a**b", "a/b", false, false},
+	} {
+		im := NewIgnoreMatcher("/root")
+		mustT(t, im.AddPattern(test.pattern))
+		path := filepath.Join("/root", filepath.FromSlash(test.path))
+		got := im.ShouldIgnoreFile(path)
+		if test.dir {
+			got = im.ShouldIgnoreDir(path)
+		}
+		if got != test.want {
+			t.Errorf("pattern %q, path %q (dir=%v): got %v, want %v", test.pattern, test.path, test.dir, got, test.want)
+		}
+	}
+}
+
+func TestIgnoreMatcherConcurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+	im := NewIgnoreMatcher(tmpDir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				im.AddPattern(fmt.Sprintf("*.%d%d", i, j))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				im.ShouldIgnore(filepath.Join(tmpDir, "file.00"))
+				SetIgnoreMatcher(im)
+				shouldIgnore(filepath.Join(tmpDir, "file.00"))
+				SetIgnoreMatcher(nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !im.ShouldIgnore(filepath.Join(tmpDir, "file.349")) {
+		t.Error("expected all concurrently added patterns to be in effect")
+	}
+}
+
+func TestRemovePattern(t *testing.T) {
+	tmpDir := t.TempDir()
+	im := NewIgnoreMatcher(tmpDir)
+	im.AddPattern("*.log")
+	im.AddPattern("build/")
+	im.AddPattern("*.log")
+
+	log := filepath.Join(tmpDir, "debug.log")
+	build := filepath.Join(tmpDir, "build", "output")
+
+	if im.RemovePattern("*.tmp") {
+		t.Error("RemovePattern(*.tmp)=true, want false")
+	}
+	if !im.RemovePattern("*.log") {
+		t.Error("RemovePattern(*.log)=false, want true")
+	}
+	if !im.ShouldIgnore(log) {
+		t.Errorf("ShouldIgnore(%s)=false, second *.log pattern should still apply", log)
+	}
+	if !im.RemovePattern("  *.log ") {
+		t.Error("RemovePattern(*.log)=false, want true")
+	}
+	if im.ShouldIgnore(log) {
+		t.Errorf("ShouldIgnore(%s)=true, want false", log)
+	}
+	if !im.ShouldIgnore(build) {
+		t.Errorf("ShouldIgnore(%s)=false, want true", build)
+	}
+
+	im.ClearPatterns()
+	if im.ShouldIgnore(build) {
+		t.Errorf("ShouldIgnore(%s)=true after ClearPatterns, want false", build)
+	}
+	if im.RemovePattern("build/") {
+		t.Error("RemovePattern(build/)=true after ClearPatterns, want false")
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	tmpDir := t.TempDir()
+	ignoreFile := filepath.Join(tmpDir, ".notifyignore")
+	content := "# comment\n\n  *.tmp  \r\n!keep.tmp\nbuild/\n"
+	if err := ioutil.WriteFile(ignoreFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	im := NewIgnoreMatcher(tmpDir)
+	im.AddPattern(".git/")
+	if err := im.LoadIgnoreFile(ignoreFile); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{".git/", "*.tmp", "!keep.tmp", "build/"}
+	got := im.Patterns()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Patterns()=%q, want %q", got, want)
+	}
+
+	got[0] = "*"
+	if p := im.Patterns()[0]; p != ".git/" {
+		t.Errorf("Patterns() returned internal slice, got %q after modification", p)
+	}
+}
+
+func TestIgnoreCaseInsensitive(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tmpDir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	im := NewIgnoreMatcher(tmpDir)
+	im.AddPattern("*.LOG")
+	im.AddPattern(".Git/")
+
+	paths := []string{
+		filepath.Join(tmpDir, "debug.log"),
+		filepath.Join(tmpDir, ".git"),
+		filepath.Join(tmpDir, ".git", "config"),
+	}
+	for _, path := range paths {
+		if im.ShouldIgnore(path) {
+			t.Errorf("ShouldIgnore(%s)=true for case-sensitive matcher, want false", path)
+		}
+	}
+
+	im.SetCaseInsensitive(true)
+	for _, path := range append(paths, filepath.Join(tmpDir, "SRC", "Error.Log")) {
+		if !im.ShouldIgnore(path) {
+			t.Errorf("ShouldIgnore(%s)=false for case-insensitive matcher, want true", path)
+		}
+	}
+	if im.ShouldIgnore(filepath.Join(tmpDir, "main.go")) {
+		t.Error("ShouldIgnore(main.go)=true, want false")
+	}
+}
+
+func TestAddPatternError(t *testing.T) {
+	tmpDir := t.TempDir()
+	im := NewIgnoreMatcher(tmpDir)
+
+	for _, pattern := range []string{"*.log", "", "# comment", "!build/[abc]/", "**/x"} {
+		if err := im.AddPattern(pattern); err != nil {
+			t.Errorf("AddPattern(%q)=%v, want nil", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"[", "*.[ch", "build/[a-/"} {
+		err := im.AddPattern(pattern)
+		if err == nil {
+			t.Errorf("AddPattern(%q)=nil, want error", pattern)
+			continue
+		}
+		if perr, ok := err.(*PatternError); !ok || perr.Pattern != pattern {
+			t.Errorf("AddPattern(%q)=%#v, want *PatternError for the pattern", pattern, err)
+		}
+	}
+	if n := len(im.Patterns()); n != 3 {
+		t.Errorf("want malformed patterns to be skipped, got %d patterns: %q", n, im.Patterns())
+	}
+
+	ignoreFile := filepath.Join(tmpDir, ".notifyignore")
+	if err := ioutil.WriteFile(ignoreFile, []byte("*.tmp\n[\nbuild/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	im = NewIgnoreMatcher(tmpDir)
+	err := im.LoadIgnoreFile(ignoreFile)
+	perr, ok := err.(*PatternError)
+	if !ok {
+		t.Fatalf("LoadIgnoreFile()=%v, want *PatternError", err)
+	}
+	if perr.File != ignoreFile || perr.Line != 2 || perr.Pattern != "[" {
+		t.Errorf("LoadIgnoreFile() reported %s:%d %q, want %s:2 %q", perr.File, perr.Line,
+			perr.Pattern, ignoreFile, "[")
+	}
+	if want := []string{"*.tmp", "build/"}; !reflect.DeepEqual(im.Patterns(), want) {
+		t.Errorf("Patterns()=%q, want %q", im.Patterns(), want)
+	}
+}
+
+func TestAddPatterns(t *testing.T) {
+	tmpDir := t.TempDir()
+	im := NewIgnoreMatcher(tmpDir)
+
+	if err := im.AddPatterns(" *.log", "", "# comment", "build/", "!build/keep.log"); err != nil {
+		t.Fatalf("AddPatterns()=%v", err)
+	}
+	if want := []string{"*.log", "build/", "!build/keep.log"}; !reflect.DeepEqual(im.Patterns(), want) {
+		t.Errorf("Patterns()=%q, want %q", im.Patterns(), want)
+	}
+	if err := im.AddPatterns("*.tmp", "[", "*.bak"); err == nil {
+		t.Error("AddPatterns() with malformed pattern returned nil error")
+	}
+	if n := len(im.Patterns()); n != 5 {
+		t.Errorf("want valid patterns to be added, got %q", im.Patterns())
+	}
+}
+
+func BenchmarkAddPatterns(b *testing.B) {
+	patterns := make([]string, 500)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("dir%d/**/*.ext%d", i, i)
+	}
+	for i := 0; i < b.N; i++ {
+		im := NewIgnoreMatcher("/")
+		im.AddPatterns(patterns...)
+	}
+}
+
+func TestLoadIgnoreReader(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	r := strings.NewReader("# comment\r\n*.log\r\n\r\nbuild/\r\n!keep.log\n[\n*.tmp")
+	err := im.LoadIgnoreReader(r)
+	perr, ok := err.(*PatternError)
+	if !ok {
+		t.Fatalf("want *PatternError, got %v", err)
+	}
+	if perr.Line != 6 || perr.File != "" {
+		t.Errorf("want error at line 6 without file name, got %v", perr)
+	}
+	if want := []string{"*.log", "build/", "!keep.log", "*.tmp"}; !reflect.DeepEqual(im.Patterns(), want) {
+		t.Errorf("Patterns()=%q, want %q", im.Patterns(), want)
+	}
+	if !im.ShouldIgnore("/root/a.log") || im.ShouldIgnore("/root/keep.log") {
+		t.Error("patterns loaded from reader are not applied")
+	}
+}
+
+func TestLoadIgnoreString(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	err := im.LoadIgnoreString("*.log: build/ ::#comment:!keep.log:[:*.tmp", ":")
+	perr, ok := err.(*PatternError)
+	if !ok {
+		t.Fatalf("want *PatternError, got %v", err)
+	}
+	if perr.Line != 6 || perr.Pattern != "[" {
+		t.Errorf("want error for 6th pattern, got %v", perr)
+	}
+	if want := []string{"*.log", "build/", "!keep.log", "*.tmp"}; !reflect.DeepEqual(im.Patterns(), want) {
+		t.Errorf("Patterns()=%q, want %q", im.Patterns(), want)
+	}
+	if !im.ShouldIgnore("/root/a.log") || im.ShouldIgnore("/root/keep.log") {
+		t.Error("patterns loaded from string are not applied")
+	}
+
+	im = NewIgnoreMatcher("/root")
+	mustT(t, im.LoadIgnoreString("*.log\n\n*.tmp\n", ""))
+	if want := []string{"*.log", "*.tmp"}; !reflect.DeepEqual(im.Patterns(), want) {
+		t.Errorf("Patterns()=%q, want %q", im.Patterns(), want)
+	}
+}
+
+func TestIgnoreFileAnnotations(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, ".notifyignore")
+	content := "*.lock @write\ntmp/ @Create\t@remove\n*.log\nname\\ @write\n# note @write\n*.bak @nope\n"
+	mustT(t, ioutil.WriteFile(file, []byte(content), 0644))
+
+	im := NewIgnoreMatcher(tmpDir)
+	err := im.LoadIgnoreFile(file)
+	if perr, ok := err.(*PatternError); !ok || perr.Line != 6 || perr.Err != errUnknownEventName {
+		t.Fatalf("want error for unknown event name at line 6, got %v", err)
+	}
+	want := []string{"*.lock @write", "tmp/ @Create\t@remove", "*.log", "name\\ @write"}
+	if !reflect.DeepEqual(im.Patterns(), want) {
+		t.Fatalf("Patterns()=%q, want %q", im.Patterns(), want)
+	}
+	cases := []struct {
+		path    string
+		ev      Event
+		ignored bool
+	}{
+		{"a.lock", Write, true},
+		{"a.lock", Create, false},
+		{"tmp/x", Create, true},
+		{"tmp/x", Remove, true},
+		{"tmp/x", Write, false},
+		{"a.log", Rename, true},
+		{"name @write", Write, true},
+		{"name", Write, false},
+	}
+	check := func(im *IgnoreMatcher) {
+		t.Helper()
+		for _, cas := range cases {
+			if got := im.ShouldIgnoreEvent(filepath.Join(tmpDir, cas.path), cas.ev); got != cas.ignored {
+				t.Errorf("ShouldIgnoreEvent(%q, %v)=%t, want %t", cas.path, cas.ev, got, cas.ignored)
+			}
+		}
+	}
+	check(im)
+
+	// Patterns written back to a file load to the same matcher.
+	mustT(t, ioutil.WriteFile(file, []byte(strings.Join(im.Patterns(), "\n")), 0644))
+	clone := NewIgnoreMatcher(tmpDir)
+	mustT(t, clone.LoadIgnoreFile(file))
+	if !reflect.DeepEqual(clone.Patterns(), want) {
+		t.Fatalf("Patterns()=%q after round-trip, want %q", clone.Patterns(), want)
+	}
+	check(clone)
+}
+
+func TestShouldIgnoreBatch(t *testing.T) {
+	root := t.TempDir()
+	mustT(t, os.MkdirAll(filepath.Join(root, "src"), 0755))
+	mustT(t, ioutil.WriteFile(filepath.Join(root, "src", ".gitignore"), []byte("*.gen\n"), 0644))
+	im := NewIgnoreMatcher(root)
+	im.SetHierarchical(true)
+	mustT(t, im.AddPatterns("*.log", "!keep.log"))
+	im.SetCustomMatcher(func(path string) (bool, bool) {
+		return true, filepath.Base(path) == "custom"
+	})
+	paths := []string{
+		filepath.Join(root, "a.log"),
+		filepath.Join(root, "keep.log"),
+		filepath.Join(root, "src", "x.gen"),
+		filepath.Join(root, "x.gen"),
+		filepath.Join(root, "custom"),
+		filepath.Dir(root),
+		"src/y.gen",
+	}
+	got := im.ShouldIgnoreBatch(paths)
+	for i, path := range paths {
+		if want := im.ShouldIgnore(path); got[i] != want {
+			t.Errorf("ShouldIgnoreBatch()[%d]=%t for %q, want %t", i, got[i], path, want)
+		}
+	}
+	if want := []bool{true, false, true, false, true, false, true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ShouldIgnoreBatch()=%v, want %v", got, want)
+	}
+	if got := (*IgnoreMatcher)(nil).ShouldIgnoreBatch(paths); len(got) != len(paths) {
+		t.Errorf("nil matcher ShouldIgnoreBatch()=%v", got)
+	}
+}
+
+func TestIgnoreHierarchical(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":             "*.log\n",
+		"src/.gitignore":         "build/\n!important.log\n",
+		"src/lib/.notifyignore":  "*.gen\n",
+		"src/lib/important.log":  "",
+		"other/build/output.txt": "",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	im := NewIgnoreMatcher(root)
+	if im.ShouldIgnore(filepath.Join(root, "app.log")) {
+		t.Fatal("ignore files are applied with hierarchical mode disabled")
+	}
+	im.SetHierarchical(true)
+
+	cases := map[string]bool{
+		"app.log":                true,
+		"src/debug.log":          true,
+		"src/important.log":      false,
+		"src/lib/important.log":  false,
+		"src/build/out.o":        true,
+		"other/build/output.txt": false,
+		"src/lib/x.gen":          true,
+		"x.gen":                  false,
+		"src/x.gen":              false,
+	}
+	for path, want := range cases {
+		if got := im.ShouldIgnore(filepath.Join(root, filepath.FromSlash(path))); got != want {
+			t.Errorf("ShouldIgnore(%q)=%t, want %t", path, got, want)
+		}
+	}
+
+	// Changes to ignore files are picked up.
+	name := filepath.Join(root, "src", ".gitignore")
+	if err := ioutil.WriteFile(name, []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(name, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if !im.ShouldIgnore(filepath.Join(root, "src", "a.tmp")) {
+		t.Error("want modified ignore file to be reloaded")
+	}
+	if im.ShouldIgnore(filepath.Join(root, "src", "build", "out.o")) {
+		t.Error("want patterns removed from ignore file to no longer apply")
+	}
+}
+
+func TestGitignoreAutoload(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, ".gitignore"), []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	EnableGitignoreAutoload()
+	defer DisableGitignoreAutoload()
+
+	c := make(chan EventInfo, 100)
+	if err := Watch(tmpDir+"/...", c, Create); err != nil {
+		t.Fatal(err)
+	}
+	defer Stop(c)
+
+	// A newly created ignore file is honored as well.
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "src", ".gitignore"), []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for _, name := range []string{"a.log", "src/b.tmp", "b.tmp", "src/c.log", "src/d.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, filepath.FromSlash(name)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := map[string]bool{}
+	for {
+		select {
+		case ei := <-c:
+			rel, _ := filepath.Rel(tmpDir, ei.Path())
+			got[filepath.ToSlash(rel)] = true
+			continue
+		case <-time.After(500 * time.Millisecond):
+		}
+		break
+	}
+	for _, name := range []string{"a.log", "src/b.tmp", "src/c.log"} {
+		if got[name] {
+			t.Errorf("received event for ignored %s", name)
+		}
+	}
+	for _, name := range []string{"b.tmp", "src/d.txt"} {
+		if !got[name] {
+			t.Errorf("missing event for %s", name)
+		}
+	}
+}
+
+func TestWatchIgnoreFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	name := filepath.Join(tmpDir, ".notifyignore")
+	if err := ioutil.WriteFile(name, []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := canonical(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop, err := WatchIgnoreFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetIgnoreMatcher(nil)
+	defer stop()
+	im := GetIgnoreMatcher()
+	im.SetCaseInsensitive(true)
+
+	waitIgnored := func(base string, want bool) {
+		t.Helper()
+		path := filepath.Join(dir, base)
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+			if shouldIgnore(path) == want {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("shouldIgnore(%q) != %t", base, want)
+	}
+	waitIgnored("a.log", true)
+
+	// Modified in place.
+	if err := ioutil.WriteFile(name, []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitIgnored("a.tmp", true)
+	waitIgnored("a.log", false)
+	// The matcher is reloaded in place, keeping its settings.
+	if GetIgnoreMatcher() != im {
+		t.Fatal("want the matcher reloaded in place")
+	}
+	waitIgnored("A.TMP", true)
+
+	// Atomically replaced.
+	tmp := filepath.Join(tmpDir, "ignore.new")
+	if err := ioutil.WriteFile(tmp, []byte("*.bak\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		t.Fatal(err)
+	}
+	waitIgnored("a.bak", true)
+	waitIgnored("a.tmp", false)
+
+	// Removed and recreated.
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	waitIgnored("a.bak", false)
+	if err := ioutil.WriteFile(name, []byte("*.out\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitIgnored("a.out", true)
+
+	// A matcher installed later is not overwritten.
+	other := NewIgnoreMatcher(dir)
+	SetIgnoreMatcher(other)
+	if err := ioutil.WriteFile(name, []byte("*.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * ignoreReloadDelay)
+	if GetIgnoreMatcher() != other || shouldIgnore(filepath.Join(dir, "a.log")) {
+		t.Error("want the matcher installed later kept")
+	}
+	if !im.ShouldIgnore(filepath.Join(dir, "a.log")) {
+		t.Error("want the file reloaded into its matcher")
+	}
+	SetIgnoreMatcher(im)
+
+	// No reloads after stop.
+	stop()
+	if err := ioutil.WriteFile(name, []byte("*.out\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * ignoreReloadDelay)
+	if shouldIgnore(filepath.Join(dir, "a.out")) {
+		t.Error("ignore file reloaded after stop")
+	}
+}
+
+func TestMatchReason(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	im.AddPatterns("*.log", "build/", "!build/important.log")
+
+	cases := []struct {
+		path    string
+		ignored bool
+		pattern string
+	}{
+		{"/root/debug.log", true, "*.log"},
+		{"/root/build/out.o", true, "build/"},
+		{"/root/build/important.log", true, "build/"},
+		{"/root/main.go", false, ""},
+	}
+	for _, cas := range cases {
+		ignored, pattern := im.MatchReason(cas.path)
+		if ignored != cas.ignored || pattern != cas.pattern {
+			t.Errorf("MatchReason(%q)=(%t, %q), want (%t, %q)", cas.path, ignored, pattern,
+				cas.ignored, cas.pattern)
+		}
+	}
+	if ignored, pattern := (*IgnoreMatcher)(nil).MatchReason("/root/debug.log"); ignored || pattern != "" {
+		t.Errorf("nil matcher MatchReason()=(%t, %q)", ignored, pattern)
+	}
+}
+
+func TestMatchSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	base, overlay := filepath.Join(tmpDir, "base.ignore"), filepath.Join(tmpDir, "overlay.ignore")
+	mustT(t, ioutil.WriteFile(base, []byte("# base\n*.log\nbuild/\n"), 0644))
+	mustT(t, ioutil.WriteFile(overlay, []byte("!keep.log\n"), 0644))
+	mustT(t, os.MkdirAll(filepath.Join(tmpDir, "sub"), 0755))
+	nested := filepath.Join(tmpDir, "sub", ".gitignore")
+	mustT(t, ioutil.WriteFile(nested, []byte("\n*.tmp\n"), 0644))
+
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.LoadIgnoreFileTracked(base))
+	mustT(t, im.LoadIgnoreFileTracked(overlay))
+	mustT(t, im.AddPattern("*.bak"))
+	im.SetHierarchical(true)
+
+	cases := []struct {
+		path    string
+		ignored bool
+		src     PatternSource
+	}{
+		{"debug.log", true, PatternSource{"*.log", base, 2}},
+		{"build/", true, PatternSource{"build/", base, 3}},
+		{"keep.log", false, PatternSource{"!keep.log", overlay, 1}},
+		{"old.bak", true, PatternSource{Pattern: "*.bak"}},
+		{"sub/x.tmp", true, PatternSource{"*.tmp", nested, 2}},
+		{"main.go", false, PatternSource{}},
+	}
+	for _, cas := range cases {
+		ignored, src := im.MatchSource(filepath.Join(tmpDir, cas.path))
+		if ignored != cas.ignored || src != cas.src {
+			t.Errorf("MatchSource(%q)=(%t, %+v), want (%t, %+v)", cas.path, ignored, src,
+				cas.ignored, cas.src)
+		}
+	}
+}
+
+func TestSetCustomMatcher(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.go", "a.go.nowatch", "b.go", "c.log"} {
+		mustT(t, ioutil.WriteFile(filepath.Join(tmpDir, name), nil, 0644))
+	}
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPatterns("*.log", "*.nowatch"))
+	im.SetCustomMatcher(func(path string) (bool, bool) {
+		if filepath.Base(path) == "c.log" {
+			return false, true // overrides *.log
+		}
+		if _, err := os.Stat(path + ".nowatch"); err == nil {
+			return true, true
+		}
+		return false, false
+	})
+	cases := map[string]bool{
+		"a.go":         true,
+		"a.go.nowatch": true,
+		"b.go":         false,
+		"c.log":        false,
+		"d.log":        true,
+	}
+	for name, want := range cases {
+		if got := im.ShouldIgnore(filepath.Join(tmpDir, name)); got != want {
+			t.Errorf("ShouldIgnore(%q)=%t, want %t", name, got, want)
+		}
+		if got := im.ShouldIgnoreRel(name); got != want {
+			t.Errorf("ShouldIgnoreRel(%q)=%t, want %t", name, got, want)
+		}
+	}
+	im.SetCustomMatcher(nil)
+	if im.ShouldIgnore(filepath.Join(tmpDir, "a.go")) || !im.ShouldIgnore(filepath.Join(tmpDir, "c.log")) {
+		t.Error("want patterns only after removing the custom matcher")
+	}
+}
+
+func TestWatchWithIgnore(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+
+	imA := NewIgnoreMatcher(dirA)
+	imA.AddPattern("*.log")
+	imB := NewIgnoreMatcher(dirB)
+	imB.AddPattern("*.tmp")
+
+	c := make(chan EventInfo, 100)
+	if err := WatchWithIgnore(dirA, c, imA, Create); err != nil {
+		t.Fatal(err)
+	}
+	if err := WatchWithIgnore(dirB, c, imB, Create); err != nil {
+		t.Fatal(err)
+	}
+	defer Stop(c)
+
+	for _, name := range []string{
+		filepath.Join(dirA, "a.log"), filepath.Join(dirA, "a.tmp"),
+		filepath.Join(dirB, "b.log"), filepath.Join(dirB, "b.tmp"),
+	} {
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := map[string]bool{}
+	for {
+		select {
+		case ei := <-c:
+			got[filepath.Base(ei.Path())] = true
+			continue
+		case <-time.After(500 * time.Millisecond):
+		}
+		break
+	}
+	if want := map[string]bool{"a.tmp": true, "b.log": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events for %v, want %v", got, want)
+	}
+
+	Stop(c)
+	if err := ioutil.WriteFile(filepath.Join(dirA, "c.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ei := <-c:
+		t.Errorf("received event after Stop: %v", ei)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestGetIgnoreMatcher(t *testing.T) {
+	defer SetIgnoreMatcher(GetIgnoreMatcher())
+
+	SetIgnoreMatcher(nil)
+	if im := GetIgnoreMatcher(); im != nil {
+		t.Fatalf("GetIgnoreMatcher()=%v, want nil", im)
+	}
+	im := NewIgnoreMatcher("/root")
+	SetIgnoreMatcher(im)
+	if got := GetIgnoreMatcher(); got != im {
+		t.Fatalf("GetIgnoreMatcher()=%p, want %p", got, im)
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]int{"small.txt": 10, "big.txt": 100, "big.log": 100}
+	for name, size := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	im := NewIgnoreMatcher(tmpDir)
+	im.AddPatterns("*.log", "!big.log")
+	im.SetMaxFileSize(50)
+
+	cases := map[string]bool{
+		"small.txt":   false,
+		"big.txt":     true,
+		"big.log":     true,
+		"removed.bin": false,
+		"":            false,
+	}
+	for name, want := range cases {
+		if got := im.ShouldIgnore(filepath.Join(tmpDir, name)); got != want {
+			t.Errorf("ShouldIgnore(%q)=%t, want %t", name, got, want)
+		}
+	}
+
+	im.SetMaxFileSize(0)
+	if im.ShouldIgnore(filepath.Join(tmpDir, "big.txt")) {
+		t.Error("want size limit to be disabled")
+	}
+}
+
+func TestIncludeOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tmpDir, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	im := NewIgnoreMatcher(tmpDir)
+	im.AddPattern("*.tmp")
+	if err := im.SetIncludeOnly([]string{"*.go", "*.mod", "*.tmp"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"main.go":       false,
+		"go.mod":        false,
+		"pkg":           false,
+		"pkg/pkg.go":    false,
+		"README.md":     true,
+		"pkg/notes.txt": true,
+		"main.go.tmp":   true, // ignore rules win over include ones
+	}
+	for name, want := range cases {
+		if got := im.ShouldIgnore(filepath.Join(tmpDir, filepath.FromSlash(name))); got != want {
+			t.Errorf("ShouldIgnore(%q)=%t, want %t", name, got, want)
+		}
+	}
+
+	if err := im.SetIncludeOnly([]string{"["}); err == nil {
+		t.Error("want error for malformed include pattern")
+	}
+	if im.ShouldIgnore(filepath.Join(tmpDir, "main.go")) {
+		t.Error("want include patterns unchanged after error")
+	}
+	im.SetIncludeOnly(nil)
+	if im.ShouldIgnore(filepath.Join(tmpDir, "README.md")) {
+		t.Error("want allowlist to be disabled")
+	}
+}
+
+func TestIncludeOnlyNegation(t *testing.T) {
+	tmpDir := t.TempDir()
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.SetIncludeOnly([]string{"*.go", "!*_test.go"}))
+
+	cases := map[string]bool{
+		"main.go":      false,
+		"main_test.go": true,
+		"main.txt":     true,
+	}
+	for name, want := range cases {
+		if got := im.ShouldIgnore(filepath.Join(tmpDir, name)); got != want {
+			t.Errorf("ShouldIgnore(%q)=%t, want %t", name, got, want)
+		}
+	}
+
+	// The last matching pattern wins.
+	mustT(t, im.SetIncludeOnly([]string{"!*_test.go", "*.go"}))
+	if im.ShouldIgnore(filepath.Join(tmpDir, "main_test.go")) {
+		t.Error("want main_test.go included by the last pattern")
+	}
+}
+
+func TestSetDefaultIgnorePatterns(t *testing.T) {
+	defer SetIgnoreMatcher(GetIgnoreMatcher())
+	defer SetDefaultIgnorePatterns(nil)
+
+	patterns := DefaultIgnorePatterns()
+	patterns[0] = "*.mutated"
+	if got := DefaultIgnorePatterns(); got[0] == "*.mutated" {
+		t.Fatalf("DefaultIgnorePatterns()[0]=%q, want unchanged", got[0])
+	}
+
+	var custom []string
+	for _, p := range DefaultIgnorePatterns() {
+		if p != ".vscode/" {
+			custom = append(custom, p)
+		}
+	}
+	SetDefaultIgnorePatterns(append(custom, "*.orig"))
+	mustT(t, EnableDefaultIgnorePatterns())
+	im := GetIgnoreMatcher()
+	for path, want := range map[string]bool{
+		"file.orig":        true,
+		".git/config":      true,
+		".vscode/settings": false,
+	} {
+		if got := im.ShouldIgnore(path); got != want {
+			t.Errorf("ShouldIgnore(%q)=%v, want %v", path, got, want)
+		}
+	}
+
+	SetDefaultIgnorePatterns(nil)
+	want := append(append([]string(nil), builtinIgnorePatterns...), ".gitignore", ".notifyignore")
+	if got := DefaultIgnorePatterns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DefaultIgnorePatterns()=%v, want %v", got, want)
+	}
+}
+
+func TestSetIgnoreFileNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "sub"), 0755))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, ".gitignore"), []byte("*.git\n"), 0644))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "sub", ".watchignore"), []byte("*.tmp\n"), 0644))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "sub", ".appignore"), []byte("!keep.tmp\n"), 0644))
+	SetIgnoreFileNames(".watchignore", ".appignore")
+	defer SetIgnoreFileNames()
+
+	im := NewIgnoreMatcher(tmpDir)
+	im.SetHierarchical(true)
+	for path, want := range map[string]bool{
+		"a.git":          false,
+		"sub/a.tmp":      true,
+		"sub/keep.tmp":   false,
+		"sub/a.txt":      false,
+		"sub/.appignore": false,
+	} {
+		if got := im.ShouldIgnoreFile(filepath.Join(tmpDir, filepath.FromSlash(path))); got != want {
+			t.Errorf("ShouldIgnoreFile(%q)=%v, want %v", path, got, want)
+		}
+	}
+	patterns := DefaultIgnorePatterns()
+	if got := patterns[len(patterns)-2:]; !reflect.DeepEqual(got, []string{".watchignore", ".appignore"}) {
+		t.Errorf("want ignore file names among default patterns, got %v", got)
+	}
+
+	SetIgnoreFileNames()
+	if got := IgnoreFileNames(); !reflect.DeepEqual(got, []string{".gitignore", ".notifyignore"}) {
+		t.Errorf("IgnoreFileNames()=%v, want defaults", got)
+	}
+}
+
+func TestShouldIgnoreDirFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPatterns("build/", "*.log"))
+
+	cases := []struct {
+		path      string
+		dir, file bool
+	}{
+		{"build", true, false},
+		{"src/build", true, false},
+		{"build/out", true, true},
+		{"src/build/out", true, true},
+		{"builder", false, false},
+		{"debug.log", true, true},
+		{"src/main.go", false, false},
+	}
+	for _, cas := range cases {
+		path := filepath.Join(tmpDir, filepath.FromSlash(cas.path))
+		if got := im.ShouldIgnoreDir(path); got != cas.dir {
+			t.Errorf("ShouldIgnoreDir(%q)=%v, want %v", cas.path, got, cas.dir)
+		}
+		if got := im.ShouldIgnoreFile(path); got != cas.file {
+			t.Errorf("ShouldIgnoreFile(%q)=%v, want %v", cas.path, got, cas.file)
+		}
+	}
+
+	// ShouldIgnore tells the type of an existing path by stat'ing it.
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "build"), nil, 0666))
+	if im.ShouldIgnore(filepath.Join(tmpDir, "build")) {
+		t.Error("want file named build not ignored")
+	}
+}
+
+func TestIgnorePrune(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		dirs     map[string]bool
+	}{{
+		[]string{"node_modules/", "build/", "src/"},
+		map[string]bool{"node_modules": true, "build": true, "a/build": true, "lib": false},
+	}, {
+		[]string{"build/*", "!build/keep/"},
+		map[string]bool{"build": false, "build/keep": false, "build/other": true, "a/build": false},
+	}, {
+		[]string{"build/", "!*.keep"},
+		map[string]bool{"build": true, "a/build": true},
+	}}
+	for i, cas := range cases {
+		im := NewIgnoreMatcher("/root")
+		mustT(t, im.AddPatterns(cas.patterns...))
+		for dir, want := range cas.dirs {
+			if got := im.prune(filepath.Join("/root", filepath.FromSlash(dir))); got != want {
+				t.Errorf("prune(%q)=%v, want %v (i=%d)", dir, got, want, i)
+			}
+		}
+	}
+}
+
+func TestWatchPrunesIgnoredDirs(t *testing.T) {
+	defer SetIgnoreMatcher(GetIgnoreMatcher())
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	for _, dir := range []string{"node_modules/pkg", "build/keep", "src"} {
+		mustT(t, os.MkdirAll(filepath.Join(tmpDir, filepath.FromSlash(dir)), 0755))
+	}
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPatterns("node_modules/", "build/*", "!build/keep/"))
+	SetIgnoreMatcher(im)
+
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(filepath.Join(tmpDir, "..."), c, Create))
+	defer Stop(c)
+
+	for _, e := range WatchList() {
+		if strings.Contains(e.Path, "node_modules") {
+			t.Errorf("want pruned directory not watched, got %v", e)
+		}
+	}
+	for _, path := range []string{"node_modules/pkg/x", "build/y", "build/keep/x", "src/x"} {
+		mustT(t, os.WriteFile(filepath.Join(tmpDir, filepath.FromSlash(path)), nil, 0666))
+	}
+	got := make(map[string]bool)
+	for _, ei := range collect(c, 200*time.Millisecond) {
+		got[ei.Path()] = true
+	}
+	want := map[string]bool{
+		filepath.Join(tmpDir, "build", "keep", "x"): true,
+		filepath.Join(tmpDir, "src", "x"):           true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want events for %v, got %v", want, got)
+	}
+}
+
+func TestIgnoreAnchored(t *testing.T) {
+	cases := []struct {
+		pattern string
+		paths   map[string]bool
+	}{
+		{"/build/", map[string]bool{"build": true, "build/x": true, "build/a/x": true, "src/build/x": false, "src/build": false}},
+		{"build/", map[string]bool{"build": true, "build/x": true, "src/build/x": true, "src/build": true}},
+		{"/foo.txt", map[string]bool{"foo.txt": true, "src/foo.txt": false}},
+		{"/src/*.go", map[string]bool{"src/main.go": true, "a/src/main.go": false, "main.go": false}},
+		{"/src/**/*.go", map[string]bool{"src/a/main.go": true, "a/src/a/main.go": false}},
+	}
+	for _, cas := range cases {
+		im := NewIgnoreMatcher("/root")
+		mustT(t, im.AddPattern(cas.pattern))
+		for path, want := range cas.paths {
+			p := filepath.Join("/root", filepath.FromSlash(path))
+			if got := im.ShouldIgnore(p); got != want {
+				t.Errorf("%s: ShouldIgnore(%q)=%v, want %v", cas.pattern, path, got, want)
+			}
+			if got := im.ShouldIgnoreDir(p); got != want {
+				t.Errorf("%s: ShouldIgnoreDir(%q)=%v, want %v", cas.pattern, path, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadIgnoreEscapes(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	r := strings.NewReader("# comment\n\\#notacomment\n\\!important\ntrailing\\ \nspaces   \n  \n")
+	mustT(t, im.LoadIgnoreReader(r))
+	if want := []string{`\#notacomment`, `\!important`, `trailing\ `, "spaces"}; !reflect.DeepEqual(im.Patterns(), want) {
+		t.Errorf("Patterns()=%q, want %q", im.Patterns(), want)
+	}
+	cases := map[string]bool{
+		"/root/#notacomment": true,
+		"/root/notacomment":  false,
+		"/root/!important":   true,
+		"/root/important":    false,
+		"/root/trailing ":    true,
+		"/root/trailing":     false,
+		"/root/spaces":       true,
+		"/root/# comment":    false,
+	}
+	for path, want := range cases {
+		if got := im.ShouldIgnoreFile(path); got != want {
+			t.Errorf("ShouldIgnoreFile(%q)=%v, want %v", path, got, want)
+		}
+	}
+	if !im.RemovePattern("trailing\\ ") {
+		t.Error("want escaped trailing space kept by RemovePattern")
+	}
+}
+
+func TestIgnoreOutsideRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	im := NewIgnoreMatcher(root)
+	mustT(t, im.AddPatterns("*.log", "root", "build/"))
+	mustT(t, im.SetIncludeOnly([]string{"*.go"}))
+
+	cases := map[string]bool{
+		filepath.Join(root, "a.log"):                   true,
+		filepath.Join(root, "main.go"):                 false,
+		"a.log":                                        true,
+		filepath.Dir(root):                             false,
+		filepath.Join(filepath.Dir(root), "a.log"):     false,
+		filepath.Join(filepath.Dir(root), "build", ""): false,
+		filepath.Join(filepath.Dir(root), "x.txt"):     false,
+		"../a.log": false,
+	}
+	if runtime.GOOS == "windows" {
+		vol := `D:\`
+		if strings.EqualFold(filepath.VolumeName(root), "D:") {
+			vol = `E:\`
+		}
+		cases[vol+`a.log`] = false
+		cases[vol+`root\build`] = false
+	}
+	for path, want := range cases {
+		if got := im.ShouldIgnore(path); got != want {
+			t.Errorf("ShouldIgnore(%q)=%v, want %v", path, got, want)
+		}
+		if ok, pattern := im.MatchReason(path); ok != want || !want && pattern != "" {
+			t.Errorf("MatchReason(%q)=%v, %q", path, ok, pattern)
+		}
+	}
+}
+
+func TestIgnoreNegationOrder(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		ignored  bool
+		pattern  string
+	}{
+		// A negation added before the pattern it negates has no effect.
+		{[]string{"!build/keep.txt", "build/"}, true, "build/"},
+		{[]string{"!keep.txt", "*.txt"}, true, "*.txt"},
+		// Like in git, a negation cannot re-include a file below an ignored
+		// directory, only the content of the directory can be negated.
+		{[]string{"build/", "!build/keep.txt"}, true, "build/"},
+		{[]string{"build/*", "!build/keep.txt"}, false, "!build/keep.txt"},
+		{[]string{"*.txt", "!keep.txt", "build/keep.*"}, true, "build/keep.*"},
+	}
+	for _, cas := range cases {
+		// Patterns added one by one keep their order the same way as ones
+		// added at once.
+		one, all := NewIgnoreMatcher("/root"), NewIgnoreMatcher("/root")
+		for _, p := range cas.patterns {
+			mustT(t, one.AddPattern(p))
+		}
+		mustT(t, all.AddPatterns(cas.patterns...))
+		for _, im := range []*IgnoreMatcher{one, all} {
+			ignored, pattern := im.MatchReason("/root/build/keep.txt")
+			if ignored != cas.ignored || pattern != cas.pattern {
+				t.Errorf("%q: MatchReason=(%t, %q), want (%t, %q)", cas.patterns,
+					ignored, pattern, cas.ignored, cas.pattern)
+			}
+		}
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	patterns := []string{"!keep.log", "*.log", "", "# comment", "[", "/", "!", "!keep.log", "build/"}
+	errs := ValidatePatterns(patterns)
+	if len(errs) != len(patterns) {
+		t.Fatalf("want %d errors, got %d", len(patterns), len(errs))
+	}
+	want := []error{ErrIneffectivePattern, nil, nil, nil, filepath.ErrBadPattern,
+		ErrIneffectivePattern, ErrIneffectivePattern, nil, nil}
+	for i, err := range errs {
+		if want[i] == nil {
+			if err != nil {
+				t.Errorf("%q: want no error, got %v", patterns[i], err)
+			}
+			continue
+		}
+		perr, ok := err.(*PatternError)
+		if !ok || perr.Err != want[i] {
+			t.Errorf("%q: want *PatternError with %v, got %v", patterns[i], want[i], err)
+		}
+	}
+}
+
+func TestShouldIgnoreRel(t *testing.T) {
+	root := t.TempDir()
+	mustT(t, os.Mkdir(filepath.Join(root, "out"), 0755))
+	im := NewIgnoreMatcher(root)
+	mustT(t, im.AddPatterns("*.log", "build/", "out/", "!keep.log"))
+
+	cases := map[string]bool{
+		"a.log":        true,
+		"src/b.log":    true,
+		"keep.log":     false,
+		"build/":       true,
+		"build/main.o": true,
+		"out":          true, // stat'ed relative to the root
+		"src/main.go":  false,
+		"../a.log":     false,
+	}
+	for rel, want := range cases {
+		if got := im.ShouldIgnoreRel(rel); got != want {
+			t.Errorf("ShouldIgnoreRel(%q)=%v, want %v", rel, got, want)
+		}
+		if rel == "../a.log" {
+			continue
+		}
+		if got := im.ShouldIgnore(filepath.Join(root, filepath.FromSlash(rel))); got != want {
+			t.Errorf("ShouldIgnore(%q)=%v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestIgnoreCache(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	im.SetCacheSize(2)
+	mustT(t, im.AddPatterns("*.log", "!keep.log"))
+	for i := 0; i < 2; i++ {
+		if !im.ShouldIgnoreFile("/root/a.log") || im.ShouldIgnoreFile("/root/keep.log") {
+			t.Fatal("want a.log ignored and keep.log not")
+		}
+	}
+	if ignored, pattern := im.MatchReason("/root/keep.log/"); ignored || pattern != "!keep.log" {
+		t.Fatalf("MatchReason=(%v, %q)", ignored, pattern)
+	}
+	if n := im.cache.len(); n != 2 {
+		t.Fatalf("want 2 cached results, got %d", n)
+	}
+
+	// Changes of the rules invalidate the cache.
+	mustT(t, im.AddPattern("keep.log"))
+	if !im.ShouldIgnoreFile("/root/keep.log") {
+		t.Fatal("want keep.log ignored after AddPattern")
+	}
+	im.RemovePattern("*.log")
+	if im.ShouldIgnoreFile("/root/a.log") {
+		t.Fatal("want a.log not ignored after RemovePattern")
+	}
+	mustT(t, im.LoadIgnoreReader(strings.NewReader("a.log\n")))
+	if !im.ShouldIgnoreFile("/root/a.log") {
+		t.Fatal("want a.log ignored after LoadIgnoreReader")
+	}
+
+	im.SetCacheSize(0)
+	if im.cache != nil || !im.ShouldIgnoreFile("/root/a.log") {
+		t.Fatal("want cache disabled")
+	}
+}
+
+func TestAddPatternForEvents(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	im.SetCacheSize(10)
+	mustT(t, im.AddPatternForEvents("*.lock", Write))
+	mustT(t, im.AddPatterns("*.tmp"))
+	mustT(t, im.AddPatternForEvents("!keep.tmp", Remove))
+	for _, test := range []struct {
+		path string
+		ev   Event
+		want bool
+	}{
+		{"/root/a.lock", Write, true},
+		{"/root/a.lock", Write | Create, true},
+		{"/root/a.lock", Remove, false},
+		{"/root/a.tmp", Write, true},
+		{"/root/keep.tmp", Write, true},
+		{"/root/keep.tmp", Remove, false},
+	} {
+		for i := 0; i < 2; i++ { // the second one is cached
+			if got := im.ShouldIgnoreEvent(test.path, test.ev); got != test.want {
+				t.Errorf("ShouldIgnoreEvent(%q, %v)=%v, want %v", test.path, test.ev, got, test.want)
+			}
+		}
+	}
+	// Without the event only the patterns for all events apply.
+	if im.ShouldIgnore("/root/a.lock") || !im.ShouldIgnore("/root/keep.tmp") {
+		t.Error("want a.lock not ignored and keep.tmp ignored")
+	}
+
+	tmpDir := t.TempDir()
+	im = NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPatternForEvents("*.lock", Write))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchWithIgnore(tmpDir, c, im, Write, Remove))
+	defer Stop(c)
+	lock := filepath.Join(tmpDir, "a.lock")
+	mustT(t, os.WriteFile(lock, nil, 0666))
+	mustT(t, os.WriteFile(lock, []byte("pid"), 0666))
+	mustT(t, os.Remove(lock))
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) == 0 {
+		t.Fatal("want Remove event")
+	}
+	for _, ei := range ev {
+		if ei.Event() != Remove {
+			t.Errorf("want only Remove events, got %v", ei)
+		}
+	}
+}
+
+func TestLoadIgnoreFileReplace(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, ".gitignore")
+	mustT(t, os.WriteFile(path, []byte("*.log\n*.tmp\n"), 0644))
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPattern("base"))
+	mustT(t, im.LoadIgnoreFile(path))
+	mustT(t, im.LoadIgnoreFile(path))
+	if want := []string{"base", "*.log", "*.tmp", "*.log", "*.tmp"}; !reflect.DeepEqual(im.Patterns(), want) {
+		t.Fatalf("want %v, got %v", want, im.Patterns())
+	}
+
+	mustT(t, os.WriteFile(path, []byte("*.log\n"), 0644))
+	mustT(t, im.LoadIgnoreFileReplace(path))
+	if want := []string{"*.log"}; !reflect.DeepEqual(im.Patterns(), want) {
+		t.Fatalf("want %v, got %v", want, im.Patterns())
+	}
+	mustT(t, os.Remove(path))
+	mustT(t, im.LoadIgnoreFileReplace(path))
+	if p := im.Patterns(); len(p) != 0 {
+		t.Fatalf("want no patterns after the file was removed, got %v", p)
+	}
+}
+
+func TestMatchPatterns(t *testing.T) {
+	patterns := []string{"*.log", "!keep.log", "build/", "/vendor"}
+	for path, want := range map[string]bool{
+		"a.log":          true,
+		"src/a.log":      true,
+		"keep.log":       false,
+		"build/":         true,
+		"build":          false,
+		"build/out.o":    true,
+		"./vendor":       true,
+		"src/vendor":     false,
+		"../a.log":       false,
+		"src/main.go":    false,
+		"src/build/a.go": true,
+	} {
+		got, err := MatchPatterns(patterns, path)
+		mustT(t, err)
+		if got != want {
+			t.Errorf("MatchPatterns(%q)=%v, want %v", path, got, want)
+		}
+	}
+	if _, err := MatchPatterns([]string{"[", "*.log"}, "a.log"); err == nil {
+		t.Error("want error for malformed pattern")
+	} else if _, ok := err.(*PatternError); !ok {
+		t.Errorf("want *PatternError, got %T", err)
+	}
+}
+
+func TestIgnoreMatcherClone(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	im.SetCaseInsensitive(true)
+	im.SetCacheSize(10)
+	mustT(t, im.AddPatterns("*.log"))
+	clone := im.Clone()
+	mustT(t, clone.AddPattern("*.tmp"))
+	im.RemovePattern("*.log")
+
+	if !reflect.DeepEqual(im.Patterns(), []string{}) {
+		t.Errorf("want no patterns in the original, got %v", im.Patterns())
+	}
+	if want := []string{"*.log", "*.tmp"}; !reflect.DeepEqual(clone.Patterns(), want) {
+		t.Errorf("want %v in the clone, got %v", want, clone.Patterns())
+	}
+	if !clone.ShouldIgnoreFile("/root/A.LOG") || clone.cache == im.cache {
+		t.Error("want settings copied, but not the cache")
+	}
+	if (*IgnoreMatcher)(nil).Clone() != nil {
+		t.Error("want nil clone of nil matcher")
+	}
+}
+
+func TestAddBasenamePattern(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	mustT(t, im.AddBasenamePattern("TODO"))
+	mustT(t, im.AddBasenamePattern("*.swp"))
+	mustT(t, im.AddBasenamePattern("cache/"))
+	mustT(t, im.AddBasenamePattern("!keep.swp"))
+	for _, test := range []struct {
+		path string
+		dir  bool
+		want bool
+	}{
+		{"/root/TODO", false, true},
+		{"/root/a/b/TODO", false, true},
+		{"/root/TODO/file", false, true},
+		{"/root/a/.x.swp", false, true},
+		{"/root/a/keep.swp", false, false},
+		{"/root/a/cache", true, true},
+		{"/root/a/cache", false, false},
+		{"/root/a/cache/file", false, true},
+	} {
+		got := im.ShouldIgnoreFile(test.path)
+		if test.dir {
+			got = im.ShouldIgnoreDir(test.path)
+		}
+		if got != test.want {
+			t.Errorf("%q (dir=%v): got %v, want %v", test.path, test.dir, got, test.want)
+		}
+	}
+	if _, ok := im.AddBasenamePattern("a/TODO").(*PatternError); !ok {
+		t.Fatal("want *PatternError for a pattern with a slash")
+	}
+}
+
+func TestDirOnlyPatternFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	build := filepath.Join(tmpDir, "build")
+	mustT(t, ioutil.WriteFile(build, nil, 0666))
+
+	im := NewIgnoreMatcher(tmpDir)
+	im.SetCacheSize(10)
+	mustT(t, im.AddPattern("build/"))
+	for i := 0; i < 2; i++ { // the second one is cached
+		if im.ShouldIgnore(build) || im.ShouldIgnore("build") || im.ShouldIgnoreRel("build") {
+			t.Fatal("want file named build not ignored by build/")
+		}
+	}
+	mustT(t, os.Remove(build))
+	mustT(t, os.Mkdir(build, 0755))
+	if !im.ShouldIgnore(build) || !im.ShouldIgnore("build") {
+		t.Fatal("want directory named build ignored by build/")
+	}
+}
+
+func TestRelativeRoot(t *testing.T) {
+	wd, err := os.Getwd()
+	mustT(t, err)
+	im := NewIgnoreMatcher(".")
+	mustT(t, im.AddPatterns("*.log", "/build"))
+	cases := map[string]bool{
+		"app.log":                           true,
+		"build":                             true,
+		filepath.Join(wd, "app.log"):        true,
+		filepath.Join(wd, "sub", "app.log"): true,
+		filepath.Join(wd, "build"):          true,
+		filepath.Join(wd, "sub", "build"):   false,
+		filepath.Join(wd, "main.go"):        false,
+	}
+	for path, want := range cases {
+		if got := im.ShouldIgnore(path); got != want {
+			t.Errorf("ShouldIgnore(%q)=%v, want %v", path, got, want)
+		}
+	}
+}
+
+func BenchmarkShouldIgnore(b *testing.B) {
+	patterns := make([]string, 50)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("dir%d/**/*.ext%d", i, i)
+	}
+	paths := make([]string, 1000)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/root/src/pkg%d/dir%d/file%d.ext%d", i%10, i%50, i, i%50)
+	}
+	for _, size := range []int{0, len(paths)} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			im := NewIgnoreMatcher("/root")
+			im.SetCacheSize(size)
+			im.AddPatterns(patterns...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				im.ShouldIgnoreFile(paths[i%len(paths)])
+			}
+		})
+	}
+}
+
+func BenchmarkShouldIgnoreBatch(b *testing.B) {
+	root := b.TempDir()
+	for _, dir := range []string{"", "src", "src/pkg"} {
+		mustT(b, os.MkdirAll(filepath.Join(root, dir), 0755))
+		mustT(b, ioutil.WriteFile(filepath.Join(root, dir, ".gitignore"), []byte("*.log\n!keep.log\n"), 0644))
+	}
+	paths := make([]string, 1000)
+	for i := range paths {
+		paths[i] = filepath.Join(root, "src", "pkg", fmt.Sprintf("file%d.ext%d", i, i%5))
+	}
+	im := NewIgnoreMatcher(root)
+	im.SetHierarchical(true)
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, path := range paths {
+				im.ShouldIgnore(path)
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			im.ShouldIgnoreBatch(paths)
+		}
+	})
+}
+
+func TestAddPatternsWithBase(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	mustT(t, im.AddPatterns("*.tmp"))
+	mustT(t, im.AddPatternsWithBase("sub", []string{"build/", "/gen", "!keep.tmp"}))
+	mustT(t, im.AddPatternsWithBase(filepath.FromSlash("/root/abs"), []string{"*.log"}))
+	cases := []struct {
+		path    string
+		ignored bool
+	}{
+		{"/root/build/x", false},
+		{"/root/sub/build/x", true},
+		{"/root/sub/dir/build/x", true},
+		{"/root/sub/gen", true},
+		{"/root/sub/dir/gen", false},
+		{"/root/keep.tmp", true},
+		{"/root/sub/keep.tmp", false},
+		{"/root/a.log", false},
+		{"/root/abs/dir/a.log", true},
+	}
+	for _, cas := range cases {
+		if got := im.ShouldIgnore(filepath.FromSlash(cas.path)); got != cas.ignored {
+			t.Errorf("ShouldIgnore(%q)=%v, want %v", cas.path, got, cas.ignored)
+		}
+	}
+	if err := im.AddPatternsWithBase("..", []string{"*.log"}); err != errBaseNotWithinRoot {
+		t.Errorf("want errBaseNotWithinRoot, got %v", err)
+	}
+}
+
+func TestShouldIgnoreFrom(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	mustT(t, im.AddPatterns("*.tmp", "/build"))
+	cases := []struct {
+		base, path string
+		ignored    bool
+	}{
+		{"/other", "/other/build", true},
+		{"/other", "/other/sub/build", false},
+		{"/other", "/other/sub/a.tmp", true},
+		{"/other", "a.tmp", true},
+		{"/other", "build", true},
+		{"/other", "/root/build", false},
+		{"/root", "/root/build", true},
+	}
+	for _, cas := range cases {
+		base, path := filepath.FromSlash(cas.base), filepath.FromSlash(cas.path)
+		if got := im.ShouldIgnoreFrom(base, path); got != cas.ignored {
+			t.Errorf("ShouldIgnoreFrom(%q, %q)=%v, want %v", cas.base, cas.path, got, cas.ignored)
+		}
+	}
+
+	// Per-directory ignore files are looked up within the base.
+	a, b := t.TempDir(), t.TempDir()
+	mustT(t, os.WriteFile(filepath.Join(b, ".gitignore"), []byte("*.log\n"), 0666))
+	im = NewIgnoreMatcher(a)
+	im.SetHierarchical(true)
+	if im.ShouldIgnoreFrom(a, filepath.Join(a, "x.log")) {
+		t.Error("want x.log not ignored within a")
+	}
+	if !im.ShouldIgnoreFrom(b, filepath.Join(b, "x.log")) {
+		t.Error("want x.log ignored within b")
+	}
+}
+
+func TestSetIgnoreEnabled(t *testing.T) {
+	root := filepath.FromSlash("/root")
+	im := NewIgnoreMatcher(root)
+	mustT(t, im.AddPatterns("*.log", "build/"))
+	defer SetIgnoreMatcher(GetIgnoreMatcher())
+	SetIgnoreMatcher(im)
+	defer SetIgnoreEnabled(true)
+
+	c := make(chan EventInfo, 10)
+	s := newSubscription(c, []stage{ignoreStage(im)})
+	defer s.close()
+	log, build := filepath.Join(root, "a.log"), filepath.Join(root, "build")
+	for _, enabled := range []bool{true, false, true} {
+		SetIgnoreEnabled(enabled)
+		if got := shouldIgnore(log); got != enabled {
+			t.Errorf("shouldIgnore(%q)=%v, want %v", log, got, enabled)
+		}
+		if got := shouldPrune(build); got != enabled {
+			t.Errorf("shouldPrune(%q)=%v, want %v", build, got, enabled)
+		}
+		s.head(&Call{P: log, E: Write})
+		if ev := collect(c, 10*time.Millisecond); len(ev) == 0 != enabled {
+			t.Errorf("want event delivered=%v, got %v", !enabled, ev)
+		}
+		if !im.ShouldIgnore(log) {
+			t.Error("IgnoreMatcher.ShouldIgnore is affected by SetIgnoreEnabled")
+		}
+	}
+}
+
+func TestComposeNFC(t *testing.T) {
+	cases := map[string]string{
+		"plain":                "plain",
+		"cafe\u0301":           "caf\u00e9",
+		"e\u0323\u0302":        "\u1ec7",
+		"a\u0308\u0308":        "\u00e4\u0308",
+		"\u0301a":              "\u0301a",
+		"o\u0361o\u0301":       "o\u0361\u00f3",
+		"n\u0303o\u0303":       "\u00f1\u00f5",
+		"\u0418\u0306":         "\u0419",
+		"\u0391\u0301\u03b9":   "\u0386\u03b9",
+		"\u00e9\u0301":         "\u00e9\u0301",
+		"x\u0315\u0301\u0302e": "x\u0315\u0301\u0302e",
+	}
+	for s, want := range cases {
+		if got := composeNFC(s); got != want {
+			t.Errorf("composeNFC(%+q)=%+q, want %+q", s, got, want)
+		}
+	}
+}
+
+func TestUnicodeNormalization(t *testing.T) {
+	im := NewIgnoreMatcher("/root")
+	mustT(t, im.AddPatterns("caf\u00e9/", "/r\u00e9sum\u00e9*.pdf"))
+	nfd := []string{"/root/cafe\u0301/menu.txt", "/root/re\u0301sume\u0301.pdf"}
+	for _, path := range nfd {
+		if im.ShouldIgnore(path) {
+			t.Errorf("ShouldIgnore(%+q)=true before normalization is enabled", path)
+		}
+	}
+	im.SetUnicodeNormalization(true)
+	for _, path := range append(nfd, "/root/caf\u00e9/menu.txt") {
+		if !im.ShouldIgnore(path) {
+			t.Errorf("ShouldIgnore(%+q)=false, want true", path)
+		}
+	}
+	im.SetCaseInsensitive(true)
+	if path := "/root/CAFE\u0301/menu.txt"; !im.ShouldIgnore(path) {
+		t.Errorf("ShouldIgnore(%+q)=false, want true", path)
+	}
+}
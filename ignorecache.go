@@ -0,0 +1,99 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"container/list"
+	"sync"
+)
+
+// matchKey identifies a cached match result.
+type matchKey struct {
+	relPath string
+	kind    pathKind
+	ev      Event
+}
+
+// matchResult is a cached match result.
+type matchResult struct {
+	key     matchKey
+	ignored bool
+	src     PatternSource
+}
+
+// matchCache is a least recently used cache of match results. It is safe for
+// concurrent use and a nil *matchCache is a valid, always empty cache.
+type matchCache struct {
+	mu  sync.Mutex // protects ll and m
+	max int
+	ll  *list.List // of matchResult, most recently used first
+	m   map[matchKey]*list.Element
+}
+
+func newMatchCache(max int) *matchCache {
+	return &matchCache{
+		max: max,
+		ll:  list.New(),
+		m:   make(map[matchKey]*list.Element),
+	}
+}
+
+// get gives the cached result for the key, if any.
+func (c *matchCache) get(key matchKey) (ignored bool, src PatternSource, ok bool) {
+	if c == nil {
+		return false, PatternSource{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.m[key]
+	if !ok {
+		return false, PatternSource{}, false
+	}
+	c.ll.MoveToFront(el)
+	r := el.Value.(matchResult)
+	return r.ignored, r.src, true
+}
+
+// put caches the result for the key, evicting the least recently used one if
+// the cache is full.
+func (c *matchCache) put(key matchKey, ignored bool, src PatternSource) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.m[key]; ok {
+		el.Value = matchResult{key: key, ignored: ignored, src: src}
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.m[key] = c.ll.PushFront(matchResult{key: key, ignored: ignored, src: src})
+	if c.ll.Len() > c.max {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.m, el.Value.(matchResult).key)
+	}
+}
+
+// reset removes all the cached results.
+func (c *matchCache) reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.ll.Init()
+	c.m = make(map[matchKey]*list.Element)
+	c.mu.Unlock()
+}
+
+// len gives the number of cached results.
+func (c *matchCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
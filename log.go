@@ -0,0 +1,34 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import "sync/atomic"
+
+// logFunc is the type of the logger set with SetLogger.
+type logFunc func(format string, args ...interface{})
+
+var logger atomic.Value // logFunc
+
+// SetLogger sets a function the watchers call to report what happens inside
+// of them: a watch being registered or its descriptor being closed, an
+// overflow of the event queue, an event dropped due to the ignore patterns,
+// or a watch removed by the system behind the watcher's back. It is meant for
+// diagnosing watchpoints which stop delivering events, the messages are not
+// a stable API. A nil fn disables logging, which is the default.
+//
+// The function, which may be called from multiple goroutines at once, should
+// not block. log.Printf is a valid logger:
+//
+//	notify.SetLogger(log.Printf)
+func SetLogger(fn func(format string, args ...interface{})) {
+	logger.Store(logFunc(fn))
+}
+
+// logf calls the logger set with SetLogger, if any.
+func logf(format string, args ...interface{}) {
+	if fn, _ := logger.Load().(logFunc); fn != nil {
+		fn("notify: "+format, args...)
+	}
+}
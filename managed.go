@@ -0,0 +1,285 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// managedTree is a recursive watchpoint which is maintained by its subscription
+// as a separate non-recursive watch per directory, instead of by the tree.
+// This allows for limiting the depth of the watchpoint, for polling the
+// subtrees which cannot be watched natively, for following symlinks and for
+// excluding directories.
+type managedTree struct {
+	s       *subscription
+	root    string
+	max     int                      // depth limit of watched directories, -1 for no limit
+	eset    Event                    // events requested by the user
+	poll    time.Duration            // polling interval of the fallback, 0 if disabled
+	follow  bool                     // whether symlinks to directories are followed
+	exclude excludeSet               // directories which are not watched
+	mu      sync.Mutex               // protects polled and links
+	polled  map[string]chan struct{} // roots of the polled subtrees to their pollers' stop
+	links   map[string]string        // real paths of followed symlinks to their paths
+}
+
+var (
+	managedMu    sync.RWMutex // protects managedChans
+	managedChans = make(map[chan<- EventInfo]struct{})
+)
+
+// manage records the channel of s as the one of a managedTree, so its watches,
+// which are not recursive in the tree, are listed as recursive by WatchList.
+func manage(s *subscription) {
+	managedMu.Lock()
+	managedChans[s.in] = struct{}{}
+	managedMu.Unlock()
+	s.onStop(func() {
+		managedMu.Lock()
+		delete(managedChans, s.in)
+		managedMu.Unlock()
+	})
+}
+
+// isManaged tells whether any of the channels of wp is the one of
+// a managedTree.
+func isManaged(wp watchpoint) bool {
+	managedMu.RLock()
+	defer managedMu.RUnlock()
+	for c := range wp {
+		if _, ok := managedChans[c]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// managedEvents are the events a managedTree watches for in addition to the
+// requested ones, in order to keep track of the directories.
+const managedEvents = Create | Remove | Rename
+
+// stage keeps the watchpoint up to date. It watches directories created within
+// the depth limit, forgets the ones removed or moved away, and drops events not
+// in eset, which were requested only to notice such directories. Events of
+// followed symlinks are reported under the paths of the symlinks.
+func (m *managedTree) stage(s *subscription, next sink) sink {
+	m.s = s
+	return func(ei EventInfo) {
+		real := eventPath(ei)
+		ei = m.relink(ei)
+		switch {
+		case ei.Event() == Create && m.within(ei.Path()) && m.isDir(ei):
+			if err := m.watch(ei.Path(), depth(m.root, ei.Path())); err != nil {
+				dbgprintf("watch %q failed: %v", ei.Path(), err)
+				reportError(ei.Path(), err)
+			}
+		case ei.Event()&(Remove|Rename) != 0 && depth(m.root, eventPath(ei)) > 0:
+			m.forget(real, eventPath(ei))
+		}
+		if ei.Event()&(m.eset|Overflow) != 0 {
+			next(ei)
+		}
+	}
+}
+
+// within reports whether a directory created at path should be watched, that
+// is whether it lies within the depth limit and outside the polled subtrees
+// and the excluded directories.
+func (m *managedTree) within(path string) bool {
+	if d := depth(m.root, path); d == -1 || m.max >= 0 && d > m.max || m.exclude.has(path) {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for root := range m.polled {
+		if path == root || indexrel(root, path) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// isDir tells whether ei concerns a directory, or a symlink to a directory if
+// symlinks are followed.
+func (m *managedTree) isDir(ei EventInfo) bool {
+	if ok, err := ei.(isDirer).isDir(); ok && err == nil {
+		return true
+	}
+	if !m.follow {
+		return false
+	}
+	fi, err := os.Stat(ei.Path())
+	return err == nil && fi.IsDir()
+}
+
+// relink gives ei reported under the path of the followed symlink, if it
+// concerns a path within its target.
+func (m *managedTree) relink(ei EventInfo) EventInfo {
+	if !m.follow {
+		return ei
+	}
+	path := eventPath(ei)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var target string
+	for real := range m.links {
+		if len(real) > len(target) && (path == real || indexrel(real, path) != -1) {
+			target = real
+		}
+	}
+	if target == "" {
+		return ei
+	}
+	return rebase(ei, target, m.links[target])
+}
+
+// link records dir as the path under which events of its real path are
+// reported, if it is a symlink or lies within one.
+func (m *managedTree) link(dir string) {
+	real, err := canonical(dir)
+	if err != nil || real == dir {
+		return
+	}
+	m.mu.Lock()
+	if m.links == nil {
+		m.links = make(map[string]string)
+	}
+	m.links[real] = dir
+	m.mu.Unlock()
+}
+
+// forget drops the watches of the directory at path, whose real path is real,
+// and of the directories below it, after it was removed or moved away. The
+// watches would not be set up again otherwise if it was recreated, since
+// the watchpoint would not change. Paths which are not watched are a nop.
+func (m *managedTree) forget(real, path string) {
+	reals := []string{real}
+	m.mu.Lock()
+	for r, p := range m.links {
+		if p == path || indexrel(path, p) != -1 {
+			reals = append(reals, r)
+			delete(m.links, r)
+		}
+	}
+	for root, stop := range m.polled {
+		if root == path || indexrel(path, root) != -1 {
+			close(stop)
+			delete(m.polled, root)
+		}
+	}
+	m.mu.Unlock()
+	for _, r := range reals {
+		defaultTree.Unwatch(r, m.s.in)
+	}
+}
+
+// watch watches dir, which lies d levels below the root, and its subdirectories
+// within the depth limit. The root itself is watched by the subscription.
+// Directories which vanish in the meantime are skipped. If the polling fallback
+// is enabled, a directory which cannot be watched due to exhausted watch
+// limits is polled together with its subdirectories.
+//
+// A directory which is the same as one of its parents, or as a directory
+// already visited by the walk, is skipped, so symlinks or bind mounts making
+// the tree cycle do not make the walk run forever.
+func (m *managedTree) watch(dir string, d int) error {
+	seen := make(dirset)
+	for p, i := dir, d; i > 0; i-- {
+		p = filepath.Dir(p)
+		if fi, err := os.Stat(p); err == nil {
+			seen.visit(fi)
+		}
+	}
+	return m.walk(dir, d, seen)
+}
+
+func (m *managedTree) walk(dir string, d int, seen dirset) error {
+	if fi, err := os.Stat(dir); err == nil && !seen.visit(fi) {
+		logf("skipping %q: directory visited already, possibly a cycle", dir)
+		return nil
+	}
+	if d != 0 {
+		if err := defaultTree.Watch(dir, m.s.in, m.eset|managedEvents); err != nil {
+			switch {
+			case os.IsNotExist(err):
+				return nil
+			case m.poll > 0 && isWatchLimit(err):
+				dbgprintf("watch %q failed: %v, polling instead", dir, err)
+				m.startPoll(dir, d)
+				return nil
+			}
+			return err
+		}
+		if m.follow {
+			m.link(dir)
+		}
+	}
+	if d == m.max {
+		return nil
+	}
+	de, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, de := range de {
+		name := filepath.Join(dir, de.Name())
+		switch typ := de.Type(); {
+		case typ&fs.ModeSymlink != 0 && m.follow:
+			if fi, err := os.Stat(name); err != nil || !fi.IsDir() {
+				continue
+			}
+		case typ&(fs.ModeSymlink|fs.ModeDir) != fs.ModeDir:
+			continue
+		}
+		if shouldPrune(name) || m.exclude.has(name) {
+			continue
+		}
+		if err := m.walk(name, d+1, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startPoll polls dir, which lies d levels below the root, and its
+// subdirectories within the depth limit.
+func (m *managedTree) startPoll(dir string, d int) {
+	max := -1
+	if m.max >= 0 {
+		max = m.max - d
+	}
+	p := newPoller(dir, max, m.eset)
+	p.stop = make(chan struct{})
+	m.mu.Lock()
+	if m.polled == nil {
+		m.polled = make(map[string]chan struct{})
+	}
+	m.polled[dir] = p.stop
+	m.mu.Unlock()
+	p.start(m.s, m.poll)
+}
+
+// depth gives the number of directory levels between root and path, which is
+// 0 for the root itself and 1 for its immediate children. It returns -1 if
+// path is not within root.
+func depth(root, path string) int {
+	if path == root {
+		return 0
+	}
+	i := indexrel(root, path)
+	if i == -1 {
+		return -1
+	}
+	return strings.Count(path[i:], string(os.PathSeparator)) + 1
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+// nfcTable lists the canonical compositions of the characters of the Latin,
+// Greek and Cyrillic scripts with a combining mark, which is what macOS
+// decomposes in file names in practice. For each mark it gives its canonical
+// combining class and a string of pairs: a character followed by the character
+// composed of it and the mark.
+var nfcTable = map[rune]struct {
+	ccc   uint8
+	pairs string
+}{
+	0x0300: {230, // grave accent
+		"AÀEÈIÌNǸOÒUÙWẀYỲaàeèiìnǹoòuùwẁyỳÂẦÊỀÔỒÜǛâầêềôồüǜ" +
+			"ĂẰăằĒḔēḕŌṐōṑƠỜơờƯỪưừЕЀИЍеѐиѝ"},
+	0x0301: {230, // acute accent
+		"AÁCĆEÉGǴIÍKḰLĹMḾNŃOÓPṔRŔSŚUÚWẂYÝZŹaácćeégǵiíkḱlĺ" +
+			"mḿnńoópṕrŕsśuúwẃyýzź¨΅ÂẤÅǺÆǼÇḈÊẾÏḮÔỐÕṌØǾÜǗâấåǻæǽ" +
+			"çḉêếïḯôốõṍøǿüǘĂẮăắĒḖēḗŌṒōṓŨṸũṹƠỚơớƯỨưứΑΆΕΈΗΉΙΊΟΌ" +
+			"ΥΎΩΏαάεέηήιίοόυύωώϊΐϋΰϒϓГЃКЌгѓкќ"},
+	0x0302: {230, // circumflex accent
+		"AÂCĈEÊGĜHĤIÎJĴOÔSŜUÛWŴYŶZẐaâcĉeêgĝhĥiîjĵoôsŝuûwŵ" +
+			"yŷzẑẠẬạậẸỆẹệỌỘọộ"},
+	0x0303: {230, // tilde
+		"AÃEẼIĨNÑOÕUŨVṼYỸaãeẽiĩnñoõuũvṽyỹÂẪÊỄÔỖâẫêễôỗĂẴăẵ" +
+			"ƠỠơỡƯỮưữ"},
+	0x0304: {230, // macron
+		"AĀEĒGḠIĪOŌUŪYȲaāeēgḡiīoōuūyȳÄǞÆǢÕȬÖȪÜǕäǟæǣõȭöȫüǖ" +
+			"ǪǬǫǭȦǠȧǡȮȰȯȱИӢУӮиӣуӯḶḸḷḹṚṜṛṝ"},
+	0x0306: {230, // breve
+		"AĂEĔGĞIĬOŎUŬaăeĕgğiĭoŏuŭȨḜȩḝАӐЕӖЖӁИЙУЎаӑеӗжӂийуў" +
+			"ẠẶạặ"},
+	0x0307: {230, // dot above
+		"AȦBḂCĊDḊEĖFḞGĠHḢIİMṀNṄOȮPṖRṘSṠTṪWẆXẊYẎZŻaȧbḃcċdḋ" +
+			"eėfḟgġhḣmṁnṅoȯpṗrṙsṡtṫwẇxẋyẏzżŚṤśṥŠṦšṧſẛṢṨṣṩ"},
+	0x0308: {230, // diaeresis
+		"AÄEËHḦIÏOÖUÜWẄXẌYŸaäeëhḧiïoötẗuüwẅxẍyÿÕṎõṏŪṺūṻΙΪ" +
+			"ΥΫιϊυϋϒϔІЇАӒЕЁЖӜЗӞИӤОӦУӰЧӴЫӸЭӬаӓеёжӝзӟиӥоӧуӱчӵыӹ" +
+			"эӭіїӘӚәӛӨӪөӫ"},
+	0x0309: {230, // hook above
+		"AẢEẺIỈOỎUỦYỶaảeẻiỉoỏuủyỷÂẨÊỂÔỔâẩêểôổĂẲăẳƠỞơởƯỬưử"},
+	0x030A: {230, // ring above
+		"AÅUŮaåuůwẘyẙ"},
+	0x030B: {230, // double acute accent
+		"OŐUŰoőuűУӲуӳ"},
+	0x030C: {230, // caron
+		"AǍCČDĎEĚGǦHȞIǏKǨLĽNŇOǑRŘSŠTŤUǓZŽaǎcčdďeěgǧhȟiǐjǰ" +
+			"kǩlľnňoǒrřsštťuǔzžÜǙüǚƷǮʒǯ"},
+	0x030F: {230, // double grave accent
+		"AȀEȄIȈOȌRȐUȔaȁeȅiȉoȍrȑuȕѴѶѵѷ"},
+	0x0311: {230, // inverted breve
+		"AȂEȆIȊOȎRȒUȖaȃeȇiȋoȏrȓuȗ"},
+	0x031B: {216, // horn
+		"OƠUƯoơuư"},
+	0x0323: {220, // dot below
+		"AẠBḄDḌEẸHḤIỊKḲLḶMṂNṆOỌRṚSṢTṬUỤVṾWẈYỴZẒaạbḅdḍeẹhḥ" +
+			"iịkḳlḷmṃnṇoọrṛsṣtṭuụvṿwẉyỵzẓƠỢơợƯỰưự"},
+	0x0324: {220, // diaeresis below
+		"UṲuṳ"},
+	0x0325: {220, // ring below
+		"AḀaḁ"},
+	0x0326: {220, // comma below
+		"SȘTȚsștț"},
+	0x0327: {202, // cedilla
+		"CÇDḐEȨGĢHḨKĶLĻNŅRŖSŞTŢcçdḑeȩgģhḩkķlļnņrŗsştţ"},
+	0x0328: {202, // ogonek
+		"AĄEĘIĮOǪUŲaąeęiįoǫuų"},
+	0x032D: {220, // circumflex accent below
+		"DḒEḘLḼNṊTṰUṶdḓeḙlḽnṋtṱuṷ"},
+	0x032E: {220, // breve below
+		"HḪhḫ"},
+	0x0330: {220, // tilde below
+		"EḚIḬUṴeḛiḭuṵ"},
+	0x0331: {220, // macron below
+		"BḆDḎKḴLḺNṈRṞTṮZẔbḇdḏhẖkḵlḻnṉrṟtṯzẕ"},
+}
+
+var (
+	nfcCCC   = make(map[rune]uint8)   // combining classes of the marks of nfcTable
+	nfcPairs = make(map[[2]rune]rune) // compositions of a character and a mark
+)
+
+func init() {
+	for mark, t := range nfcTable {
+		nfcCCC[mark] = t.ccc
+		pairs := []rune(t.pairs)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			nfcPairs[[2]rune{pairs[i], mark}] = pairs[i+1]
+		}
+	}
+}
+
+// composeNFC gives s with the characters which are decomposed into a character
+// and combining marks composed, like in Unicode Normalization Form C, as far as
+// nfcTable covers them. Unknown combining marks are left as they are, together
+// with the ones which follow them.
+func composeNFC(s string) string {
+	if !hasCombiningMark(s) {
+		return s
+	}
+	rs := []rune(s)
+	out := rs[:0]
+	starter := -1     // index of the last starter within out
+	var blocked uint8 // highest combining class of the marks left since starter
+	for _, r := range rs {
+		ccc, ok := nfcCCC[r]
+		switch {
+		case !ok && r >= 0x300 && r <= 0x36F:
+			blocked = 0xFF
+		case !ok:
+			starter, blocked = len(out), 0
+		case starter != -1 && blocked < ccc:
+			if c, ok := nfcPairs[[2]rune{out[starter], r}]; ok {
+				out[starter] = c
+				continue
+			}
+			blocked = ccc
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// hasCombiningMark reports whether s contains any of the combining diacritical
+// marks, U+0300 to U+036F, which are encoded with a 0xCC or 0xCD leading byte.
+func hasCombiningMark(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0xCC || s[i] == 0xCD {
+			return true
+		}
+	}
+	return false
+}
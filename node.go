@@ -62,6 +62,10 @@ func (nd node) Add(name string) node {
 
 func (nd node) AddDir(fn walkFunc) error {
 	stack := []node{nd}
+	seen := make(dirset)
+	if fi, err := os.Stat(nd.Name); err == nil {
+		seen.visit(fi)
+	}
 Traverse:
 	for n := len(stack); n != 0; n = len(stack) {
 		nd, stack = stack[n-1], stack[:n-1]
@@ -86,7 +90,11 @@ Traverse:
 			if fi.Type()&(fs.ModeSymlink|fs.ModeDir) == fs.ModeDir {
 				name := filepath.Join(nd.Name, fi.Name())
 				// Check if this directory should be ignored
-				if defaultIgnore != nil && defaultIgnore.ShouldIgnore(name) {
+				if shouldPrune(name) {
+					continue
+				}
+				if fi, err := fi.Info(); err == nil && !seen.visit(fi) {
+					logf("skipping %q: directory visited already, possibly a cycle", name)
 					continue
 				}
 				stack = append(stack, nd.addchild(name, name[len(nd.Name)+1:]))
@@ -20,24 +20,49 @@
 package notify
 
 import (
+	"context"
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
 	defaultTree   = newTree()
+	ignoreMu      sync.RWMutex // protects defaultIgnore
 	defaultIgnore *IgnoreMatcher
+	ignoreOff     int32 // non-zero if ignore filtering is disabled, accessed atomically
+
+	autoloadMu    sync.RWMutex // protects autoload and autoloadRoots
+	autoload      bool
+	autoloadRoots map[string]*autoloadRoot
 )
 
+// autoloadRoot is a hierarchical matcher for the subtree of a recursive
+// watchpoint, together with channels which use it.
+type autoloadRoot struct {
+	im    *IgnoreMatcher
+	chans map[chan<- EventInfo]struct{}
+}
+
 // Watch sets up a watchpoint on path listening for events given by the events
 // argument.
 //
+// A path with the "/..." suffix, like "./src/...", sets up a recursive
+// watchpoint, which watches the directory together with all its
+// subdirectories, see also WatchRecursive.
+//
 // File or directory given by the path must exist, otherwise Watch will fail
 // with non-nil error. Notify resolves, for its internal purpose, any symlinks
 // the provided path may contain, so it may fail if the symlinks form a cycle.
 // It does so, since not all watcher implementations treat passed paths as-is.
-// E.g. FSEvents reports a real path for every event, setting a watchpoint
-// on /tmp will report events with paths rooted at /private/tmp etc.
+// E.g. FSEvents reports a real path for every event, which notify reports
+// under the given path, see Symlinks below.
 //
 // The c almost always is a buffered channel. Watch will not block sending to c
 // - the caller must ensure that c has sufficient buffer space to keep up with
@@ -61,6 +86,26 @@ var (
 // dispatches two events - notify.Create and notify.Write. However, it may depend
 // on the underlying watcher implementation whether OS reports both of them.
 //
+// # Symlinks
+//
+// If path is, or lies within, a symlink to a directory, the symlink is resolved
+// once, when the watchpoint is created, and the directory it points to is
+// watched. Events are nevertheless reported under path as given, so their
+// paths always lie within it, which keeps ignore matchers rooted at path
+// working. Retargeting the symlink later does not move the watchpoint.
+// Symlinks within a recursive watchpoint are not followed, unless requested
+// with WithFollowSymlinks.
+//
+// # Directories created within recursive watchpoints
+//
+// When a directory is created within a recursive watchpoint, its content
+// created before the directory became watched, like the subdirectories and
+// files of "mkdir -p a/b/c", is reported with synthetic Create events, so it
+// is not missed. Such a path may be reported twice, if it was created right
+// after the directory became watched. Unless the underlying watcher is
+// natively recursive, like FSEvents or ReadDirectoryChangesW, Create of the
+// directory is delivered before the events of the paths within it.
+//
 // # Windows and recursive watches
 //
 // If a directory which path was used to create recursive watch under Windows
@@ -68,8 +113,244 @@ var (
 // mind this limitation while setting recursive watchpoints for your application,
 // e.g. use persistent paths like %userprofile% or watch additionally parent
 // directory of a recursive watchpoint in order to receive delete events for it.
+//
+// # Linux and moved or replaced paths
+//
+// A watch on Linux follows the watched file rather than its path. When the
+// file or directory is moved away or removed, and another one takes its place,
+// like when an editor saves a file by renaming a temporary one over it, the
+// watch is re-armed for the new file at the path. The move itself is reported
+// with a Rename event, if requested. If no file exists at the path when the
+// change is noticed, the watch is gone, which WatchErr reports as
+// ErrWatchRemoved. This is the case also for a directory moved away, the
+// watch is not moved to its new path, since inotify does not tell it, so
+// the events of the directory are no longer reported.
 func Watch(path string, c chan<- EventInfo, events ...Event) error {
-	return defaultTree.Watch(path, c, events...)
+	return WatchOpts(path, c, WithEvents(events...))
+}
+
+// WatchRecursive works like Watch, but it watches path together with all its
+// subdirectories, like Watch does for path with the "/..." suffix. The path
+// itself must not have the suffix already, which is an error.
+func WatchRecursive(path string, c chan<- EventInfo, events ...Event) error {
+	if strings.HasSuffix(path, "...") {
+		return errors.New(`notify: WatchRecursive called with "..." suffixed path ` + path)
+	}
+	return Watch(filepath.Join(path, "..."), c, events...)
+}
+
+// WatchContext works like Watch, but the watchpoint is removed when ctx is done.
+// Only the watchpoint created by this call is removed, other watchpoints of c
+// are not affected. Like with Stop, c is not closed, but it is guaranteed to
+// receive no more events from the watchpoint once it is removed.
+//
+// WatchContext starts a goroutine which waits for ctx to be done. It exits
+// once the watchpoint is removed, either due to ctx or Stop called on c.
+func WatchContext(ctx context.Context, path string, c chan<- EventInfo, events ...Event) error {
+	return WatchOpts(path, c, WithEvents(events...), WithContext(ctx))
+}
+
+// WatchWithIgnore works like Watch, but events delivered to c are additionally
+// filtered with the given matcher, which is used by this watchpoint only. The
+// global ignore matcher applies as well. If im is nil, WatchWithIgnore is
+// equivalent to Watch.
+//
+// Calling Stop on c removes all the watchpoints registered with Watch and
+// WatchWithIgnore for it.
+func WatchWithIgnore(path string, c chan<- EventInfo, im *IgnoreMatcher, events ...Event) error {
+	return WatchOpts(path, c, WithEvents(events...), WithIgnoreMatcher(im))
+}
+
+// WatchFilter works like Watch, but only events for which pred returns true
+// are delivered to c. The predicate is called after the ignore matchers, so it
+// never sees ignored paths. If pred panics, the event is dropped.
+//
+// The predicate is called from a single goroutine of the watchpoint and it
+// should not block, since it holds up delivery of subsequent events.
+func WatchFilter(path string, c chan<- EventInfo, pred func(EventInfo) bool, events ...Event) error {
+	return WatchOpts(path, c, WithEvents(events...), WithFilter(pred))
+}
+
+// WatchDebounced works like Watch, but it delays delivery of events by the given
+// window. Events for a path that are followed by another event for the same
+// path within the window are dropped, so a burst of events is delivered as
+// the last event of the burst.
+//
+// Stop cancels delivery of all the pending events.
+func WatchDebounced(path string, c chan<- EventInfo, window time.Duration, events ...Event) error {
+	return WatchOpts(path, c, WithEvents(events...), WithDebounce(window))
+}
+
+// WatchCoalesced works like Watch, but it collapses short-lived sequences of
+// events for a single path. Events for a path are collected for the given
+// window since the first of them and then delivered as follows:
+//
+//   - Create together with Remove or Rename, like a temporary file of
+//     an atomic save, is not delivered at all
+//   - Create together with Write is delivered as a single Write
+//   - any other events are delivered unchanged
+//
+// All events are thus delivered with a delay of up to window.
+//
+// Stop cancels delivery of all the pending events.
+func WatchCoalesced(path string, c chan<- EventInfo, window time.Duration, events ...Event) error {
+	return WatchOpts(path, c, WithEvents(events...), WithCoalesce(window))
+}
+
+// WatchOnce sets up a watchpoint on path, waits for the first event matching
+// the events and removes the watchpoint, returning the event. Events ignored by
+// the ignore matcher do not count. It is meant for scripts and tests which
+// need to wait for the next change of a path:
+//
+//	ei, err := notify.WatchOnce("config.json", notify.Write)
+//
+// WatchOnce blocks until the event arrives, use WatchOnceContext to wait with
+// a timeout.
+func WatchOnce(path string, events ...Event) (EventInfo, error) {
+	return WatchOnceContext(context.Background(), path, events...)
+}
+
+// WatchOnceContext works like WatchOnce, but it stops waiting when ctx is done,
+// in which case it returns the error of ctx.
+func WatchOnceContext(ctx context.Context, path string, events ...Event) (EventInfo, error) {
+	if len(events) == 0 {
+		return nil, errors.New("notify: WatchOnce called with no events")
+	}
+	c := make(chan EventInfo, 1)
+	if err := WatchContext(ctx, path, c, events...); err != nil {
+		return nil, err
+	}
+	defer Stop(c)
+	select {
+	case ei := <-c:
+		return ei, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WaitForEvent waits for the next event matching the events at path and
+// returns it, or the error of ctx once it is done, like WatchOnceContext.
+// Unlike the latter, path does not have to exist: a missing path is awaited
+// in its directory, like with WatchFollow, so it can wait for a file to
+// appear:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//	defer cancel()
+//	ei, err := notify.WaitForEvent(ctx, "out/done", notify.Create)
+//
+// If the file appears while the watchpoint is being set up, a synthetic
+// Create event is returned for it. The watchpoint is removed before
+// WaitForEvent returns, whether an event arrived or not.
+func WaitForEvent(ctx context.Context, path string, events ...Event) (EventInfo, error) {
+	if len(events) == 0 {
+		return nil, errors.New("notify: WaitForEvent called with no events")
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) || strings.HasSuffix(path, "...") {
+		return WatchOnceContext(ctx, path, events...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c := make(chan EventInfo, 1)
+	if err := WatchFollow(path, c, events...); err != nil {
+		return nil, err
+	}
+	defer Stop(c)
+	if joinevents(events)&Create != 0 {
+		if fi, err := os.Lstat(path); err == nil {
+			if path, err = filepath.Abs(path); err == nil {
+				return &pollEvent{path: path, event: Create, fi: fi, time: time.Now()}, nil
+			}
+		}
+	}
+	select {
+	case ei := <-c:
+		return ei, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WatchFunc sets up a watchpoint on path which calls fn for each event instead
+// of sending it to a channel. It returns a function which removes the
+// watchpoint.
+//
+// The fn is called serially from a single goroutine owned by the watchpoint,
+// so it does not need any locking of its own. Events are handed off to it
+// through a buffered channel, so a slow fn never blocks the watcher, though
+// like with Watch, events are dropped if it cannot keep up.
+//
+// Stop does not wait for fn, so it may be called from fn itself. A call in
+// progress, or one for an event already taken off the channel, may still
+// happen after stop returns, so fn is called at most once more after that.
+// Calling stop more than once is a nop.
+func WatchFunc(path string, fn func(EventInfo), events ...Event) (stop func(), err error) {
+	if fn == nil {
+		panic("notify: WatchFunc using nil function")
+	}
+	c := make(chan EventInfo, buffer)
+	if err := Watch(path, c, events...); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case ei := <-c:
+				select {
+				case <-done:
+					return
+				default:
+				}
+				fn(ei)
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			Stop(c)
+			close(done)
+		})
+	}, nil
+}
+
+// WatchMany sets up a watchpoint on each of the paths, delivering their events
+// to c. Either all of the watchpoints are set up or none of them: if any path
+// fails to be watched, the watchpoints already set up by the call are removed
+// and the error is returned. Other watchpoints of c are not affected.
+//
+// Stop called on c removes all the watchpoints at once.
+func WatchMany(paths []string, c chan<- EventInfo, events ...Event) error {
+	if c == nil {
+		panic("notify: Watch using nil channel")
+	}
+	s := newSubscription(c, nil)
+	s.start()
+	for _, path := range paths {
+		if err := watch(path, s.in, c, events); err != nil {
+			s.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+// watch sets up a watchpoint for c in the default tree. The owner is the user
+// channel on behalf of which the watchpoint is created, resources bound to
+// it are released by Stop.
+func watch(path string, c, owner chan<- EventInfo, events []Event) error {
+	root, ok := autoloadAdd(path, owner)
+	if err := defaultTree.Watch(path, c, events...); err != nil {
+		if ok {
+			autoloadDel(root, owner)
+		}
+		return err
+	}
+	return nil
 }
 
 // Stop removes all watchpoints registered for c. All underlying watches are
@@ -79,28 +360,213 @@ func Watch(path string, c chan<- EventInfo, events ...Event) error {
 // receive no more signals.
 func Stop(c chan<- EventInfo) {
 	defaultTree.Stop(c)
+	unsubscribe(c)
+	autoloadStop(c)
+	resume(c)
+}
+
+// StopAndDrain works like Stop, but before removing the watchpoints of c it
+// waits until the events which notify already received from the underlying
+// watcher are delivered to c, so events reported before the call are not
+// lost. Like with Stop, it is guaranteed that c receives no more events
+// once StopAndDrain returns, so c can be closed or reused safely.
+//
+// The events are delivered the usual way: they are dropped if c is not ready
+// to receive them, unless the watchpoint was set up with WithBuffer, in which
+// case StopAndDrain waits until c receives all the buffered events. Events
+// held back by WithDebounce, WithCoalesce, WithThrottle or WithRateLimit are
+// dropped, like with Stop.
+func StopAndDrain(c chan<- EventInfo) {
+	defaultTree.Flush()
+	subsMu.Lock()
+	ss := append([]*subscription(nil), subs[c]...)
+	subsMu.Unlock()
+	for _, s := range ss {
+		s.flush()
+	}
+	Stop(c)
+}
+
+// WatchEntry describes a path watched by the underlying watcher.
+type WatchEntry struct {
+	Path   string // real path of the file or directory
+	Events Event  // events the path is watched for
+
+	// Recursive reports whether the path is watched by a recursive
+	// watchpoint, set up with the "/..." form. Subdirectories reported
+	// separately for such a watchpoint are recursive as well.
+	Recursive bool
+}
+
+// WatchList returns a snapshot of the paths which are currently watched, sorted
+// by path. Each directory of a recursive watchpoint is reported separately if
+// the underlying watcher is not natively recursive, e.g. under Linux.
+//
+// The events of an entry are the events requested from the underlying watcher,
+// which may include events needed by notify internally, like Create for
+// recursive watchpoints.
+func WatchList() []WatchEntry {
+	return defaultTree.List()
+}
+
+// Stats describes the resources held by the watchpoints, see WatchStats.
+type Stats struct {
+	// Watches is the number of watches held by the underlying watcher. Under
+	// Linux it is the number of inotify watch descriptors, the resource limited
+	// by fs.inotify.max_user_watches, elsewhere the number of watched paths,
+	// as reported by WatchList.
+	Watches int
+}
+
+// WatchStats returns the current usage of resources by the watchpoints
+// created by the package, meant for monitoring, e.g. for alerting before
+// the limit of inotify watches is reached. Watches of the polling
+// watchpoints, see WatchPoll, are not included, since they hold none.
+func WatchStats() Stats {
+	return defaultTree.Stats()
+}
+
+// StaleWatchError is returned by VerifyWatch, it lists the watched paths which
+// are no longer watched by the OS.
+type StaleWatchError struct {
+	Paths []string // real paths of the stale watches, sorted
+}
+
+// Error implements error interface.
+func (e *StaleWatchError) Error() string {
+	return "notify: stale watches: " + strings.Join(e.Paths, ", ")
+}
+
+// VerifyWatch checks whether the paths watched for c are still watched by the
+// OS, since a watch may die silently, e.g. when the watched file was moved and
+// another one was created in its place. Under Linux an inotify watch is valid
+// if it still refers to the file at its path, under kqueue if the watched file
+// is still the one at its path, elsewhere if the path exists.
+//
+// It fails with *StaleWatchError listing the stale paths. A service may call
+// VerifyWatch periodically and, when it fails, call Stop on c and set up the
+// watchpoints again. It returns nil if nothing is watched for c.
+func VerifyWatch(c chan<- EventInfo) error {
+	chans := []chan<- EventInfo{c}
+	for _, s := range subscriptions(c) {
+		chans = append(chans, s.in)
+	}
+	return defaultTree.Verify(chans)
+}
+
+// PlanWatch returns the paths of the directories a watchpoint created with
+// Watch for the same arguments would watch, sorted by path, without watching
+// anything. The directories are walked and pruned with the global ignore
+// matcher the way Watch does, so the result tells how many watches the
+// watchpoint takes, e.g. to keep under the inotify limit, and whether the
+// ignore patterns prune what is expected.
+//
+// Like in WatchList, the paths are real ones, with symlinks resolved. A
+// non-recursive watchpoint, as well as a recursive one under platforms which
+// watch directories recursively natively, is made of a single watch of the
+// given path. The paths already watched by other watchpoints are included.
+// An empty event list yields no paths, since Watch is a nop for it.
+func PlanWatch(path string, events ...Event) ([]string, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	root, isrec, err := cleanpath(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := defaultTree.(*recursiveTree); ok || !isrec {
+		if _, err := os.Stat(root); err != nil {
+			return nil, err
+		}
+		return []string{root}, nil
+	}
+	var dirs []string
+	err = newnode(root).AddDir(func(nd node) error {
+		dirs = append(dirs, nd.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// StopAll removes all the watchpoints, for all the channels, releasing all the
+// underlying watches. It is safe to call StopAll when nothing is watched.
+//
+// Like Stop, StopAll does not close any channel.
+func StopAll() {
+	subsMu.Lock()
+	all := subs
+	subs = make(map[chan<- EventInfo][]*subscription)
+	subsMu.Unlock()
+	for _, ss := range all {
+		for _, s := range ss {
+			s.shutdown()
+		}
+	}
+	defaultTree.StopAll()
+	autoloadMu.Lock()
+	autoloadRoots = nil
+	autoloadMu.Unlock()
+	resetRescans()
+	resetPaused()
 }
 
 // SetIgnoreMatcher sets the global ignore matcher for filtering paths.
 // If nil is passed, no paths will be ignored.
 func SetIgnoreMatcher(im *IgnoreMatcher) {
+	ignoreMu.Lock()
 	defaultIgnore = im
+	ignoreMu.Unlock()
+}
+
+// SetIgnoreEnabled enables or disables all ignore filtering at once, without
+// removing any of the installed matchers. While disabled, events are delivered
+// regardless of the global ignore matcher, the matchers passed with
+// WithIgnoreMatcher and the autoloaded ignore files, and directories created
+// or watched meanwhile are not pruned. It is meant for debugging, e.g. to tell
+// whether the patterns drop events which are wanted.
+//
+// Ignore filtering is enabled by default. The IgnoreMatcher methods, like
+// ShouldIgnore, are not affected.
+func SetIgnoreEnabled(enabled bool) {
+	var off int32
+	if !enabled {
+		off = 1
+	}
+	atomic.StoreInt32(&ignoreOff, off)
+}
+
+// ignoreEnabled reports whether ignore filtering is enabled, see
+// SetIgnoreEnabled.
+func ignoreEnabled() bool {
+	return atomic.LoadInt32(&ignoreOff) == 0
+}
+
+// GetIgnoreMatcher returns the global ignore matcher, nil if none is set.
+func GetIgnoreMatcher() *IgnoreMatcher {
+	ignoreMu.RLock()
+	defer ignoreMu.RUnlock()
+	return defaultIgnore
 }
 
 // SetIgnorePatterns sets ignore patterns from a list of gitignore-style patterns.
 // This creates a new IgnoreMatcher with the current working directory as root.
+// If any of the patterns is malformed, the global matcher is left unchanged.
 func SetIgnorePatterns(patterns []string) error {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	
+
 	im := NewIgnoreMatcher(cwd)
-	for _, pattern := range patterns {
-		im.AddPattern(pattern)
+	if err := im.AddPatterns(patterns...); err != nil {
+		return err
 	}
-	
-	defaultIgnore = im
+
+	SetIgnoreMatcher(im)
 	return nil
 }
 
@@ -109,16 +575,254 @@ func SetIgnorePatterns(patterns []string) error {
 func LoadIgnoreFile(path string) error {
 	dir := filepath.Dir(path)
 	im := NewIgnoreMatcher(dir)
-	
+
 	if err := im.LoadIgnoreFile(path); err != nil {
 		return err
 	}
-	
-	defaultIgnore = im
+
+	SetIgnoreMatcher(im)
 	return nil
 }
 
-// EnableDefaultIgnorePatterns enables a set of common ignore patterns.
+// ignoreReloadDelay is the time WatchIgnoreFile waits for the ignore file to
+// settle before reloading it.
+var ignoreReloadDelay = 100 * time.Millisecond
+
+// WatchIgnoreFile loads ignore patterns from a file like LoadIgnoreFile does
+// and reloads them whenever the file changes on disk, which includes the file
+// being atomically replaced or removed and created again. A removed file
+// results in no patterns being ignored. Changes made in a quick succession,
+// like a single save emitting several write events, are coalesced into one
+// reload.
+//
+// The file is reloaded into the matcher installed by WatchIgnoreFile with
+// (*IgnoreMatcher).LoadIgnoreFileReplace, so the patterns of the file replace
+// the ones of the matcher, while its settings, like SetCaseInsensitive or
+// SetIncludeOnly, are kept. A matcher installed later with SetIgnoreMatcher
+// is not affected by the reloads. Malformed patterns are skipped, reload
+// failures are reported to the logger set with SetLogger.
+//
+// The returned stop function stops watching the file, patterns loaded last
+// stay in effect.
+func WatchIgnoreFile(path string) (stop func(), err error) {
+	if path, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
+	dir, err := canonical(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	if err = LoadIgnoreFile(path); err != nil {
+		return nil, err
+	}
+	im := GetIgnoreMatcher()
+	// The ignore file is usually ignored itself, so it's watched with its own
+	// watcher which is not subject to the global ignore matcher.
+	c := make(chan EventInfo, buffer)
+	w := newWatcher(c)
+	if err = w.Watch(dir, Create|Remove|Write|Rename); err != nil {
+		w.Close()
+		return nil, err
+	}
+	reload := func() {
+		if err := im.LoadIgnoreFileReplace(path); err != nil {
+			logf("reloading ignore file %q failed: %v", path, err)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		var t *time.Timer
+		for {
+			select {
+			case ei := <-c:
+				// Changes to the file may have been lost on overflow.
+				if d, base := split(eventPath(ei)); ei.Event() != Overflow && (d != dir || base != name) {
+					continue
+				}
+				if t == nil {
+					t = time.AfterFunc(ignoreReloadDelay, reload)
+				} else {
+					t.Reset(ignoreReloadDelay)
+				}
+			case <-done:
+				if t != nil {
+					t.Stop()
+				}
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.Close()
+			close(done)
+		})
+	}, nil
+}
+
+// EnableGitignoreAutoload makes recursive watchpoints apply .gitignore and
+// .notifyignore files, or the ones named with SetIgnoreFileNames, found within
+// the watched subtree, in addition to the global ignore matcher. The files are
+// applied the way git does, see (*IgnoreMatcher).SetHierarchical for details.
+// Ignore files created or modified after the watchpoint was set are honored as
+// well.
+//
+// Only watchpoints created after the call are affected. Changes to ignore files
+// apply to subsequently delivered events, not to those already queued.
+func EnableGitignoreAutoload() {
+	autoloadMu.Lock()
+	autoload = true
+	autoloadMu.Unlock()
+}
+
+// DisableGitignoreAutoload stops applying ignore files found within subtrees
+// of recursive watchpoints.
+func DisableGitignoreAutoload() {
+	autoloadMu.Lock()
+	autoload, autoloadRoots = false, nil
+	autoloadMu.Unlock()
+}
+
+// autoloadAdd registers a hierarchical matcher for the given path if it denotes
+// a recursive watchpoint and autoload is enabled. It returns the cleaned root
+// path and true if c was registered for it.
+func autoloadAdd(path string, c chan<- EventInfo) (string, bool) {
+	autoloadMu.Lock()
+	defer autoloadMu.Unlock()
+	if !autoload {
+		return "", false
+	}
+	root, isrec, err := cleanpath(path)
+	if err != nil || !isrec {
+		return "", false
+	}
+	if autoloadRoots == nil {
+		autoloadRoots = make(map[string]*autoloadRoot)
+	}
+	r, ok := autoloadRoots[root]
+	if !ok {
+		r = &autoloadRoot{
+			im:    NewIgnoreMatcher(root),
+			chans: make(map[chan<- EventInfo]struct{}),
+		}
+		r.im.SetHierarchical(true)
+		autoloadRoots[root] = r
+	}
+	r.chans[c] = struct{}{}
+	return root, true
+}
+
+// autoloadDel unregisters c from the matcher of the given root.
+func autoloadDel(root string, c chan<- EventInfo) {
+	autoloadMu.Lock()
+	defer autoloadMu.Unlock()
+	if r, ok := autoloadRoots[root]; ok {
+		delete(r.chans, c)
+		if len(r.chans) == 0 {
+			delete(autoloadRoots, root)
+		}
+	}
+}
+
+// autoloadStop unregisters c from all the matchers.
+func autoloadStop(c chan<- EventInfo) {
+	autoloadMu.Lock()
+	defer autoloadMu.Unlock()
+	for root, r := range autoloadRoots {
+		delete(r.chans, c)
+		if len(r.chans) == 0 {
+			delete(autoloadRoots, root)
+		}
+	}
+}
+
+// shouldIgnore reports whether path, which type is not known, is ignored.
+func shouldIgnore(path string) bool {
+	return shouldIgnoreKind(path, kindUnknown)
+}
+
+// shouldIgnoreEvent reports whether ei should be ignored, telling whether it
+// concerns a directory from the event itself.
+func shouldIgnoreEvent(ei EventInfo) bool {
+	return shouldIgnoreKindEvent(eventPath(ei), eventKind(ei), ei.Event())
+}
+
+// shouldIgnoreKind reports whether path of the given kind is ignored by the
+// global ignore matcher or by ignore files autoloaded for any recursive
+// watchpoint it belongs to.
+func shouldIgnoreKind(path string, kind pathKind) bool {
+	return shouldIgnoreKindEvent(path, kind, 0)
+}
+
+// shouldIgnoreKindEvent is like shouldIgnoreKind for an event of type ev,
+// see AddPatternForEvents.
+func shouldIgnoreKindEvent(path string, kind pathKind, ev Event) bool {
+	if !ignoreEnabled() {
+		return false
+	}
+	if GetIgnoreMatcher().shouldIgnore(path, kind, ev) {
+		return true
+	}
+	autoloadMu.RLock()
+	defer autoloadMu.RUnlock()
+	for root, r := range autoloadRoots {
+		if indexrel(root, path) != -1 && r.im.shouldIgnore(path, kind, ev) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldPrune reports whether the directory at path is ignored together with
+// everything below it by the global ignore matcher or by ignore files
+// autoloaded for any recursive watchpoint it belongs to. Such directories are
+// neither watched nor walked.
+func shouldPrune(path string) bool {
+	if !ignoreEnabled() {
+		return false
+	}
+	if GetIgnoreMatcher().prune(path) {
+		return true
+	}
+	autoloadMu.RLock()
+	defer autoloadMu.RUnlock()
+	for root, r := range autoloadRoots {
+		if indexrel(root, path) != -1 && r.im.prune(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// direntKind tells whether de is a directory. Symlinks are of unknown kind,
+// since they may point to directories.
+func direntKind(de fs.DirEntry) pathKind {
+	switch {
+	case de.IsDir():
+		return kindDir
+	case de.Type()&fs.ModeSymlink != 0:
+		return kindUnknown
+	}
+	return kindFile
+}
+
+// eventKind tells whether ei concerns a directory, if it is known.
+func eventKind(ei EventInfo) pathKind {
+	if d, ok := ei.(isDirer); ok {
+		if isdir, err := d.isDir(); err == nil {
+			if isdir {
+				return kindDir
+			}
+			return kindFile
+		}
+	}
+	return kindUnknown
+}
+
+// EnableDefaultIgnorePatterns enables a set of common ignore patterns, see
+// DefaultIgnorePatterns and SetDefaultIgnorePatterns.
 func EnableDefaultIgnorePatterns() error {
 	return SetIgnorePatterns(DefaultIgnorePatterns())
 }
@@ -7,7 +7,15 @@
 
 package notify
 
-import "testing"
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
 
 func TestNotifySystemAndGlobalMix(t *testing.T) {
 	n := NewNotifyTest(t, "testdata/vfs.txt")
@@ -34,3 +42,425 @@ func TestUnknownEvent(t *testing.T) {
 
 	n.WatchErr("src/github.com/rjeczalik/fs", ch[0], nil, inExclUnlink)
 }
+
+func TestRenamedInfo(t *testing.T) {
+	tmpDir := t.TempDir()
+	outside := t.TempDir()
+	watched := filepath.Join(tmpDir, "watched")
+	mustT(t, os.Mkdir(watched, 0777))
+	mustT(t, os.Mkdir(filepath.Join(watched, "sub"), 0777))
+	mustT(t, os.WriteFile(filepath.Join(watched, "a"), nil, 0666))
+	mustT(t, os.WriteFile(filepath.Join(outside, "c"), nil, 0666))
+
+	c := make(chan EventInfo, 100)
+	mustT(t, Watch(watched+"/...", c, Create|Rename))
+	defer Stop(c)
+
+	// The paths are given as OldPath and Path.
+	expect := func(want map[Event][2]string) {
+		t.Helper()
+		for _, ei := range collect(c, 200*time.Millisecond) {
+			ri, ok := ei.(RenamedInfo)
+			if !ok {
+				t.Errorf("%v does not implement RenamedInfo", ei)
+				continue
+			}
+			w, ok := want[ei.Event()]
+			if !ok {
+				t.Errorf("unexpected event %v", ei)
+				continue
+			}
+			delete(want, ei.Event())
+			if ri.OldPath() != w[0] || ei.Path() != w[1] {
+				t.Errorf("%v: got (%q, %q), want (%q, %q)", ei, ri.OldPath(), ei.Path(), w[0], w[1])
+			}
+		}
+		for e := range want {
+			t.Errorf("missing %v event", e)
+		}
+	}
+
+	a, b := filepath.Join(watched, "a"), filepath.Join(watched, "sub", "b")
+	mustT(t, os.Rename(a, b))
+	expect(map[Event][2]string{Rename: {a, a}, Create: {a, b}})
+
+	out := filepath.Join(outside, "b")
+	mustT(t, os.Rename(b, out))
+	expect(map[Event][2]string{Rename: {b, ""}})
+
+	in := filepath.Join(watched, "c")
+	mustT(t, os.Rename(filepath.Join(outside, "c"), in))
+	expect(map[Event][2]string{Create: {"", in}})
+}
+
+func TestMovedIn(t *testing.T) {
+	tmpDir, outside := t.TempDir(), t.TempDir()
+	src, dst := filepath.Join(outside, "file"), filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(src, nil, 0666))
+
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, c, All))
+	defer Stop(c)
+
+	mustT(t, os.Rename(src, dst))
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || ev[0].Event() != Create || ev[0].Path() != dst {
+		t.Fatalf("want single Create event for %q, got %v", dst, ev)
+	}
+	ri, ok := ev[0].(RenamedInfo)
+	if !ok {
+		t.Fatalf("%v does not implement RenamedInfo", ev[0])
+	}
+	if ri.OldPath() != "" {
+		t.Errorf("want empty OldPath, got %q", ri.OldPath())
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	c1, c2 := make(chan EventInfo, 10), make(chan EventInfo, 10)
+	mustT(t, Watch(dirA+"/...", c1, Create))
+	defer Stop(c1)
+	mustT(t, Watch(dirB, c2, Remove))
+	defer Stop(c2)
+
+	i := newWatcher(nil).(*inotify)
+	es := i.transform([]*event{{sys: unix.InotifyEvent{Wd: -1, Mask: unix.IN_Q_OVERFLOW}}})
+	if len(es) != 1 || es[0] == nil || es[0].Event() != Overflow {
+		t.Fatalf("want single Overflow event, got %v", es)
+	}
+
+	defaultTree.(*nonrecursiveTree).c <- es[0]
+	for _, c := range []chan EventInfo{c1, c2} {
+		select {
+		case ei := <-c:
+			if ei.Event() != Overflow {
+				t.Errorf("want Overflow, got %v", ei)
+			}
+		case <-time.After(time.Second):
+			t.Error("timed out waiting for Overflow")
+		}
+	}
+}
+
+func TestAttrib(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(file, nil, 0600))
+
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, c, Attrib))
+	defer Stop(c)
+	cw := make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, cw, All))
+	defer Stop(cw)
+
+	mustT(t, os.Chmod(file, 0644))
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || ev[0].Event() != Attrib || ev[0].Path() != file {
+		t.Fatalf("want single Attrib event for %q, got %v", file, ev)
+	}
+	if ev := collect(cw, 200*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events for All, got %v", ev)
+	}
+}
+
+func TestWatchIgnoreRootEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	root := filepath.Join(tmpDir, "root")
+	file := filepath.Join(root, "file")
+	mustT(t, os.Mkdir(root, 0755))
+	mustT(t, os.WriteFile(file, nil, 0600))
+
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(root, c, WithEvents(Attrib, Rename), WithIgnoreRootEvents()))
+	defer Stop(c)
+
+	mustT(t, os.Chmod(root, 0700))
+	mustT(t, os.Chmod(file, 0644))
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || ev[0].Path() != file {
+		t.Fatalf("want single event for %q, got %v", file, ev)
+	}
+	mustT(t, os.Rename(root, filepath.Join(tmpDir, "moved")))
+	ev = collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || ev[0].Path() != root || ev[0].Event() != Rename {
+		t.Fatalf("want Rename for %q, got %v", root, ev)
+	}
+}
+
+func TestCloseWrite(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file")
+
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, c, CloseWrite))
+	defer Stop(c)
+
+	f, err := os.Create(file)
+	mustT(t, err)
+	_, err = f.WriteString("data")
+	mustT(t, err)
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events before close, got %v", ev)
+	}
+	mustT(t, f.Close())
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || ev[0].Event() != CloseWrite || ev[0].Path() != file {
+		t.Fatalf("want single CloseWrite event for %q, got %v", file, ev)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(file, []byte("data"), 0644))
+
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, c, Write|Truncate))
+	defer Stop(c)
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	mustT(t, err)
+	_, err = f.WriteString("more")
+	mustT(t, err)
+	mustT(t, f.Close())
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || ev[0].Event() != Write || ev[0].Path() != file {
+		t.Fatalf("want single Write event for %q, got %v", file, ev)
+	}
+	mustT(t, os.Truncate(file, 2))
+	ev = collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || ev[0].Event() != Truncate || ev[0].Path() != file {
+		t.Fatalf("want single Truncate event for %q, got %v", file, ev)
+	}
+}
+
+func TestEventInfoSys(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, c, Create))
+	defer Stop(c)
+
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "dir"), 0777))
+	select {
+	case ei := <-c:
+		sys, ok := ei.Sys().(*unix.InotifyEvent)
+		if !ok {
+			t.Fatalf("want *unix.InotifyEvent, got %T", ei.Sys())
+		}
+		if sys.Mask&unix.IN_ISDIR == 0 {
+			t.Errorf("want IN_ISDIR set in mask %#x", sys.Mask)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out before receiving event")
+	}
+}
+
+// limitWatcher fails to watch dir as if inotify ran out of watches.
+type limitWatcher struct {
+	watcher
+	dir string
+}
+
+func (w limitWatcher) Watch(path string, e Event) error {
+	if path == w.dir {
+		return os.NewSyscallError("inotify_add_watch", unix.ENOSPC)
+	}
+	return w.watcher.Watch(path, e)
+}
+
+func TestPollingFallback(t *testing.T) {
+	tmpDir := t.TempDir()
+	dir := filepath.Join(tmpDir, "a")
+	mustT(t, os.MkdirAll(filepath.Join(dir, "b"), 0755))
+	tr := defaultTree.(*nonrecursiveTree)
+	tr.rw.Lock()
+	w := tr.w
+	tr.w = limitWatcher{w, dir}
+	tr.rw.Unlock()
+	defer func() {
+		tr.rw.Lock()
+		tr.w = w
+		tr.rw.Unlock()
+	}()
+
+	c := make(chan EventInfo, 10)
+	if err := Watch(dir, c, Create); !isWatchLimit(err) {
+		t.Fatalf("want ENOSPC error, got %v", err)
+	}
+	mustT(t, WatchOpts(dir, c, WithEvents(Create), WithPollingFallback(20*time.Millisecond)))
+	defer Stop(c)
+	mustT(t, os.WriteFile(filepath.Join(dir, "x"), nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 || ev[0].Path() != filepath.Join(dir, "x") {
+		t.Fatalf("want single event for x, got %v", ev)
+	}
+	Stop(c)
+
+	mustT(t, WatchOpts(tmpDir+"/...", c, WithEvents(Create), WithPollingFallback(20*time.Millisecond)))
+	want := map[string]bool{
+		filepath.Join(tmpDir, "y"):        true,
+		filepath.Join(dir, "b", "y"):      true,
+		filepath.Join(dir, "b", "c"):      true,
+		filepath.Join(dir, "b", "c", "y"): true,
+	}
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "y"), nil, 0666))
+	mustT(t, os.WriteFile(filepath.Join(dir, "b", "y"), nil, 0666))
+	mustT(t, os.Mkdir(filepath.Join(dir, "b", "c"), 0755))
+	mustT(t, os.WriteFile(filepath.Join(dir, "b", "c", "y"), nil, 0666))
+	got := make(map[string]bool)
+	for _, ei := range collect(c, 200*time.Millisecond) {
+		if ei.Event() != Create {
+			t.Errorf("want Create, got %v", ei)
+		}
+		got[ei.Path()] = true
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want events for %v, got %v", want, got)
+	}
+	for path := range want {
+		if !got[path] {
+			t.Errorf("want event for %s, got %v", path, got)
+		}
+	}
+}
+
+func TestWatchErr(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	root := filepath.Join(tmpDir, "root")
+	mustT(t, os.MkdirAll(filepath.Join(root, "sub"), 0755))
+	c := make(chan EventInfo, 10)
+	errc := make(chan error, 10)
+	mustT(t, WatchErr(filepath.Join(root, "..."), c, errc, All))
+	defer Stop(c)
+
+	// Removing a subdirectory of a recursive watchpoint is not an error.
+	mustT(t, os.Remove(filepath.Join(root, "sub")))
+	select {
+	case err := <-errc:
+		t.Fatalf("want no error, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	removed := func() {
+		t.Helper()
+		select {
+		case err := <-errc:
+			if !errors.Is(err, ErrWatchRemoved) {
+				t.Fatalf("want %v, got %v", ErrWatchRemoved, err)
+			}
+			if pe, ok := err.(*os.PathError); !ok || pe.Path != root {
+				t.Fatalf("want error for %s, got %v", root, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for error")
+		}
+	}
+	mustT(t, os.Remove(root))
+	removed()
+	Stop(c)
+
+	// Moving the watched path away is reported the same way.
+	mustT(t, os.Mkdir(root, 0755))
+	mustT(t, WatchErr(filepath.Join(root, "..."), c, errc, All))
+	mustT(t, os.Rename(root, root+"2"))
+	removed()
+}
+
+func TestRearm(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	dir, other := filepath.Join(tmpDir, "dir"), filepath.Join(tmpDir, "other")
+	mustT(t, os.Mkdir(dir, 0755))
+	mustT(t, os.Mkdir(other, 0755))
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(dir, c, Create))
+	defer Stop(c)
+
+	// Swap the watched directory with another one, the watch follows the old
+	// one, so it needs to be re-armed for the path.
+	if err := unix.Renameat2(unix.AT_FDCWD, other, unix.AT_FDCWD, dir, unix.RENAME_EXCHANGE); err != nil {
+		t.Skipf("RENAME_EXCHANGE not supported: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	mustT(t, os.WriteFile(filepath.Join(other, "moved"), nil, 0666))
+	mustT(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 || ev[0].Path() != filepath.Join(dir, "file") {
+		t.Fatalf("want single event for %s, got %v", filepath.Join(dir, "file"), ev)
+	}
+
+	// Replace a watched file with a rename, like editors do when saving.
+	file := filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(file, nil, 0666))
+	mustT(t, Watch(file, c, Write))
+	mustT(t, os.WriteFile(file+".new", nil, 0666))
+	mustT(t, os.Rename(file+".new", file))
+	time.Sleep(100 * time.Millisecond)
+	mustT(t, os.WriteFile(file, []byte("x"), 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) == 0 || ev[0].Path() != file || ev[0].Event() != Write {
+		t.Fatalf("want Write for %s, got %v", file, ev)
+	}
+}
+
+func TestRearmMovedAway(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	dir, moved := filepath.Join(tmpDir, "dir"), filepath.Join(tmpDir, "moved")
+	mustT(t, os.Mkdir(dir, 0755))
+	c := make(chan EventInfo, 10)
+	errc := make(chan error, 10)
+	mustT(t, WatchErr(dir, c, errc, Create|Write|Rename))
+	defer Stop(c)
+
+	// Rename the watched directory and keep writing to it: the watch is not
+	// re-armed at the new path, which is reported instead of events going
+	// silently missing or being reported under the old path.
+	mustT(t, os.Rename(dir, moved))
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrWatchRemoved) {
+			t.Fatalf("want %v, got %v", ErrWatchRemoved, err)
+		}
+		if pe, ok := err.(*os.PathError); !ok || pe.Path != dir {
+			t.Fatalf("want error for %s, got %v", dir, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for error")
+	}
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 || ev[0].Event() != Rename || ev[0].Path() != dir {
+		t.Fatalf("want Rename for %s, got %v", dir, ev)
+	}
+	for i := 0; i < 3; i++ {
+		mustT(t, os.WriteFile(filepath.Join(moved, "file"), []byte{byte(i)}, 0666))
+	}
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events after the move, got %v", ev)
+	}
+}
+
+func TestWatchRecursiveCreateOrder(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	c := make(chan EventInfo, 100)
+	mustT(t, WatchRecursive(tmpDir, c, Create))
+	defer Stop(c)
+
+	for i := 0; i < 10; i++ {
+		dir := filepath.Join(tmpDir, "a"+string(rune('0'+i)))
+		mustT(t, os.MkdirAll(filepath.Join(dir, "b", "c", "d"), 0755))
+		for _, sub := range []string{"b", "b/c", "b/c/d"} {
+			mustT(t, os.WriteFile(filepath.Join(dir, filepath.FromSlash(sub), "file"), nil, 0666))
+		}
+		seen := map[string]bool{tmpDir: true}
+		for _, ei := range collect(c, 200*time.Millisecond) {
+			if !seen[filepath.Dir(ei.Path())] {
+				t.Fatalf("got %v before Create of its directory", ei)
+			}
+			seen[ei.Path()] = true
+		}
+		if !seen[filepath.Join(dir, "b", "c", "d", "file")] {
+			t.Fatalf("want Create for all the paths within %q, got %v", dir, seen)
+		}
+	}
+}
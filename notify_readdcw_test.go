@@ -7,7 +7,14 @@
 
 package notify
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestNotifySystemSpecificEvent(t *testing.T) {
 	n := NewNotifyTest(t, "testdata/vfs.txt")
@@ -56,3 +63,36 @@ func TestNotifySystemAndGlobalMix(t *testing.T) {
 
 	n.ExpectNotifyEvents(cases, ch)
 }
+
+func TestWatchIgnoreSlashPatterns(t *testing.T) {
+	tmpDir, err := filepath.Abs(t.TempDir())
+	mustT(t, err)
+	for _, dir := range []string{`build`, `src\sub`} {
+		mustT(t, os.MkdirAll(filepath.Join(tmpDir, dir), 0755))
+	}
+
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPatterns("build/", "src/*.tmp"))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchWithIgnore(filepath.Join(tmpDir, "..."), c, im, Create))
+	defer Stop(c)
+
+	for _, file := range []string{`build\out`, `src\a.tmp`, `src\sub\b.tmp`, `src\main.go`} {
+		mustT(t, os.WriteFile(filepath.Join(tmpDir, file), nil, 0666))
+	}
+	got := make(map[string]bool)
+	for _, ei := range collect(c, 500*time.Millisecond) {
+		if strings.Contains(ei.Path(), "/") {
+			t.Errorf("want path with backslashes only, got %q", ei.Path())
+		}
+		got[ei.Path()] = true
+	}
+	// The wildcard of "src/*.tmp" does not match across directories.
+	want := map[string]bool{
+		filepath.Join(tmpDir, `src\sub\b.tmp`): true,
+		filepath.Join(tmpDir, `src\main.go`):   true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want events for %v, got %v", want, got)
+	}
+}
@@ -8,9 +8,18 @@
 package notify
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -201,6 +210,526 @@ func TestRecreated(t *testing.T) {
 	checkCreated()
 }
 
+func TestWatchDebounced(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(file, nil, 0666))
+
+	c := make(chan EventInfo, 100)
+	mustT(t, WatchDebounced(tmpDir, c, 200*time.Millisecond, Write))
+	defer Stop(c)
+
+	for i := 0; i < 5; i++ {
+		mustT(t, os.WriteFile(file, []byte{byte(i)}, 0666))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ev := collect(c, 500*time.Millisecond); len(ev) != 1 || ev[0].Event() != Write {
+		t.Fatalf("want single Write event, got %v", ev)
+	}
+
+	// Pending events are dropped on Stop.
+	mustT(t, os.WriteFile(file, []byte("abc"), 0666))
+	time.Sleep(50 * time.Millisecond)
+	Stop(c)
+	if ev := collect(c, 400*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events after Stop, got %v", ev)
+	}
+}
+
+func TestWatchCoalesced(t *testing.T) {
+	tmpDir := t.TempDir()
+	orig := filepath.Join(tmpDir, "file")
+	temp := filepath.Join(tmpDir, ".file.tmp")
+	mustT(t, os.WriteFile(orig, []byte("old"), 0666))
+
+	c := make(chan EventInfo, 100)
+	mustT(t, WatchCoalesced(tmpDir, c, 200*time.Millisecond, Create|Write|Remove|Rename))
+	defer Stop(c)
+
+	// Atomic save: write temporary file and rename it over the original one.
+	mustT(t, os.WriteFile(temp, []byte("new"), 0666))
+	mustT(t, os.Rename(temp, orig))
+
+	ev := collect(c, 500*time.Millisecond)
+	for _, ei := range ev {
+		if filepath.Base(ei.Path()) == filepath.Base(temp) {
+			t.Errorf("received event for temporary file: %v", ei)
+		}
+	}
+	if len(ev) != 1 || filepath.Base(ev[0].Path()) != "file" {
+		t.Errorf("want single event for the saved file, got %v", ev)
+	}
+
+	// Create followed by Write is delivered as Write.
+	other := filepath.Join(tmpDir, "other")
+	mustT(t, os.WriteFile(other, []byte("abc"), 0666))
+	ev = collect(c, 500*time.Millisecond)
+	if len(ev) != 1 || ev[0].Event() != Write {
+		t.Errorf("want single Write event, got %v", ev)
+	}
+
+	// Create followed by Remove is not delivered.
+	mustT(t, os.WriteFile(temp, nil, 0666))
+	mustT(t, os.Remove(temp))
+	if ev = collect(c, 500*time.Millisecond); len(ev) != 0 {
+		t.Errorf("want no events, got %v", ev)
+	}
+}
+
+func TestWatchContext(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+
+	c := make(chan EventInfo, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mustT(t, WatchContext(ctx, dirA, c, Create))
+	mustT(t, Watch(dirB, c, Create))
+	defer Stop(c)
+
+	mustT(t, os.WriteFile(filepath.Join(dirA, "file"), nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 {
+		t.Fatalf("want single event, got %v", ev)
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	mustT(t, os.WriteFile(filepath.Join(dirA, "other"), nil, 0666))
+	mustT(t, os.WriteFile(filepath.Join(dirB, "file"), nil, 0666))
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || filepath.Dir(ev[0].Path()) != dirB {
+		t.Fatalf("want single event for the other watchpoint, got %v", ev)
+	}
+
+	if err := WatchContext(ctx, dirA, c, Create); err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestStopAll(t *testing.T) {
+	StopAll() // nothing is watched
+
+	dirA, dirB := t.TempDir(), t.TempDir()
+	c1, c2 := make(chan EventInfo, 100), make(chan EventInfo, 100)
+	mustT(t, Watch(dirA+"/...", c1, Create))
+	mustT(t, Watch(dirB, c2, Create))
+	mustT(t, WatchDebounced(dirB, c2, 10*time.Millisecond, Create))
+
+	StopAll()
+	mustT(t, os.WriteFile(filepath.Join(dirA, "file"), nil, 0666))
+	mustT(t, os.WriteFile(filepath.Join(dirB, "file"), nil, 0666))
+	for _, c := range []chan EventInfo{c1, c2} {
+		if ev := collect(c, 200*time.Millisecond); len(ev) != 0 {
+			t.Errorf("want no events after StopAll, got %v", ev)
+		}
+	}
+
+	// Watching works again afterwards.
+	mustT(t, Watch(dirA, c1, Create))
+	defer Stop(c1)
+	mustT(t, os.WriteFile(filepath.Join(dirA, "other"), nil, 0666))
+	if ev := collect(c1, 200*time.Millisecond); len(ev) != 1 {
+		t.Errorf("want single event, got %v", ev)
+	}
+}
+
+func TestWatchList(t *testing.T) {
+	tmpDir := t.TempDir()
+	mustT(t, os.MkdirAll(filepath.Join(tmpDir, "a", "b"), 0777))
+	dir, err := canonical(tmpDir)
+	mustT(t, err)
+
+	entries := func() map[string]Event {
+		m := make(map[string]Event)
+		for _, e := range WatchList() {
+			if e.Path == dir || indexrel(dir, e.Path) != -1 {
+				m[e.Path] = e.Events
+			}
+		}
+		return m
+	}
+
+	c := make(chan EventInfo, 100)
+	mustT(t, Watch(tmpDir+"/...", c, Write))
+	m := entries()
+	if e, ok := m[dir]; !ok || e&Write == 0 {
+		t.Errorf("want %q watched for Write, got %v", dir, m)
+	}
+	if runtime.GOOS == "linux" {
+		for _, sub := range []string{"a", filepath.Join("a", "b")} {
+			if e := m[filepath.Join(dir, sub)]; e&Write == 0 {
+				t.Errorf("want %q watched for Write, got %v", sub, m)
+			}
+		}
+	}
+
+	for _, e := range WatchList() {
+		if (e.Path == dir || indexrel(dir, e.Path) != -1) && !e.Recursive {
+			t.Errorf("want %q watched recursively", e.Path)
+		}
+	}
+
+	Stop(c)
+	if m := entries(); len(m) != 0 {
+		t.Errorf("want no entries after Stop, got %v", m)
+	}
+
+	mustT(t, Watch(tmpDir, c, Write))
+	for _, e := range WatchList() {
+		if e.Path == dir && e.Recursive {
+			t.Errorf("want %q not watched recursively", e.Path)
+		}
+	}
+	Stop(c)
+
+	// Watchpoints maintained as separate watches per directory, like the
+	// depth-limited ones, are recursive as well.
+	mustT(t, WatchOpts(tmpDir+"/...", c, WithEvents(Write), WithMaxDepth(1)))
+	if m := entries(); len(m) == 0 {
+		t.Errorf("want %q watched, got %v", dir, m)
+	}
+	for _, e := range WatchList() {
+		if (e.Path == dir || indexrel(dir, e.Path) != -1) && !e.Recursive {
+			t.Errorf("want %q watched recursively", e.Path)
+		}
+	}
+	Stop(c)
+}
+
+func TestDirInfo(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, c, Create))
+	defer Stop(c)
+
+	dir, file := filepath.Join(tmpDir, "dir"), filepath.Join(tmpDir, "file")
+	mustT(t, os.Mkdir(dir, 0777))
+	mustT(t, os.WriteFile(file, nil, 0666))
+	// Removing the paths must not affect the outcome.
+	time.Sleep(50 * time.Millisecond)
+	mustT(t, os.Remove(dir))
+
+	want := map[string]bool{"dir": true, "file": false}
+	for _, ei := range collect(c, 200*time.Millisecond) {
+		di, ok := ei.(DirInfo)
+		if !ok {
+			t.Fatalf("%v does not implement DirInfo", ei)
+		}
+		if w, ok := want[filepath.Base(ei.Path())]; ok && di.IsDir() != w {
+			t.Errorf("%v: IsDir()=%t, want %t", ei, di.IsDir(), w)
+		}
+		delete(want, filepath.Base(ei.Path()))
+	}
+	if len(want) != 0 {
+		t.Errorf("missing events for %v", want)
+	}
+}
+
+func TestStatInfo(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file")
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, c, Create, Remove))
+	defer Stop(c)
+
+	mustT(t, os.WriteFile(file, []byte("abc"), 0666))
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 1 {
+		t.Fatalf("want single event, got %v", ev)
+	}
+	si, ok := ev[0].(StatInfo)
+	if !ok {
+		t.Fatalf("%v does not implement StatInfo", ev[0])
+	}
+	fi, err := si.FileInfo()
+	mustT(t, err)
+	if fi.Name() != "file" || fi.IsDir() {
+		t.Errorf("unexpected file info: %s, dir=%t", fi.Name(), fi.IsDir())
+	}
+
+	mustT(t, os.Remove(file))
+	// The result is cached.
+	if fi2, err := si.FileInfo(); err != nil || fi2 != fi {
+		t.Errorf("want cached file info, got %v, %v", fi2, err)
+	}
+	ev = collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || ev[0].Event() != Remove {
+		t.Fatalf("want single Remove event, got %v", ev)
+	}
+	if _, err := ev[0].(StatInfo).FileInfo(); !os.IsNotExist(err) {
+		t.Errorf("want not exist error for Remove event, got %v", err)
+	}
+}
+
+func TestWatchFilter(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := make(chan EventInfo, 10)
+	pred := func(ei EventInfo) bool {
+		switch filepath.Base(ei.Path()) {
+		case "panic":
+			panic("predicate panic")
+		case "keep":
+			return true
+		}
+		return false
+	}
+	mustT(t, WatchFilter(tmpDir, c, pred, Create))
+	defer Stop(c)
+
+	for _, name := range []string{"panic", "drop", "keep"} {
+		mustT(t, os.WriteFile(filepath.Join(tmpDir, name), nil, 0666))
+	}
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 1 || filepath.Base(ev[0].Path()) != "keep" {
+		t.Fatalf("want single event for keep, got %v", ev)
+	}
+
+	// The watchpoint keeps working after the predicate panicked.
+	mustT(t, os.Remove(filepath.Join(tmpDir, "keep")))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "keep"), nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 {
+		t.Fatalf("want single event, got %v", ev)
+	}
+}
+
+// collect receives events from c until no event arrives within timeout.
+func TestWatchOpts(t *testing.T) {
+	tmpDir := t.TempDir()
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPattern("*.tmp"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(tmpDir, c,
+		WithEvents(Create),
+		WithEvents(Remove),
+		WithIgnoreMatcher(im),
+		WithDebounce(50*time.Millisecond),
+		WithContext(ctx),
+	))
+	defer Stop(c)
+
+	file := filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "file.tmp"), nil, 0666))
+	mustT(t, os.WriteFile(file, nil, 0666))
+	mustT(t, os.Remove(file))
+	ev := collect(c, 300*time.Millisecond)
+	if len(ev) != 1 || ev[0].Path() != file {
+		t.Fatalf("want single event for %s, got %v", file, ev)
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	mustT(t, os.WriteFile(file, nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events after cancel, got %v", ev)
+	}
+	if err := WatchOpts(tmpDir, c, WithEvents(Create), WithContext(ctx)); err != context.Canceled {
+		t.Fatalf("want %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestWatchMaxDepth(t *testing.T) {
+	tmpDir := t.TempDir()
+	mustT(t, os.MkdirAll(filepath.Join(tmpDir, "a", "b"), 0755))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(filepath.Join(tmpDir, "..."), c, WithEvents(Create), WithMaxDepth(1)))
+	defer Stop(c)
+
+	for _, path := range []string{"x", "a/x", "a/b/x"} {
+		mustT(t, os.WriteFile(filepath.Join(tmpDir, path), nil, 0666))
+	}
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 2 {
+		t.Fatalf("want 2 events, got %v", ev)
+	}
+	for _, ei := range ev {
+		if filepath.Base(filepath.Dir(ei.Path())) == "b" {
+			t.Errorf("unexpected event below max depth: %v", ei)
+		}
+	}
+
+	// Directories created later are watched up to the max depth as well.
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "n"), 0755))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 {
+		t.Fatalf("want single event, got %v", ev)
+	}
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "n", "m"), 0755))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 {
+		t.Fatalf("want single event, got %v", ev)
+	}
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "n", "m", "x"), nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events below max depth, got %v", ev)
+	}
+}
+
+func TestWatchMaxDepthRecreated(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	dir := filepath.Join(tmpDir, "sub")
+	mustT(t, os.Mkdir(dir, 0755))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(filepath.Join(tmpDir, "..."), c, WithEvents(All), WithMaxDepth(3)))
+	defer Stop(c)
+
+	// A removed directory is no longer watched.
+	mustT(t, os.RemoveAll(dir))
+	collect(c, 200*time.Millisecond)
+	for _, e := range WatchList() {
+		if e.Path == dir {
+			t.Fatalf("want %s not watched after removal, got %v", dir, e)
+		}
+	}
+
+	// It is watched again once recreated.
+	mustT(t, os.Mkdir(dir, 0755))
+	collect(c, 200*time.Millisecond)
+	file := filepath.Join(dir, "b")
+	mustT(t, os.WriteFile(file, nil, 0666))
+	for _, ei := range collect(c, 200*time.Millisecond) {
+		if ei.Path() == file && ei.Event() == Create {
+			return
+		}
+	}
+	t.Fatalf("want Create event for %s", file)
+}
+
+func TestWatchExcludePaths(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	for _, dir := range []string{"a", "cache", "data/tmp"} {
+		mustT(t, os.MkdirAll(filepath.Join(tmpDir, filepath.FromSlash(dir)), 0755))
+	}
+	before := WatchStats().Watches
+	c := make(chan EventInfo, 10)
+	exclude := []string{"cache", filepath.Join(tmpDir, "data", "tmp")}
+	mustT(t, WatchOpts(filepath.Join(tmpDir, "..."), c, WithEvents(Create), WithExcludePaths(exclude)))
+	defer Stop(c)
+	if n := WatchStats().Watches - before; n != 3 {
+		t.Errorf("want 3 watches, got %d", n)
+	}
+
+	for _, path := range []string{"x", "a/x", "cache/x", "data/x", "data/tmp/x"} {
+		mustT(t, os.WriteFile(filepath.Join(tmpDir, filepath.FromSlash(path)), nil, 0666))
+	}
+	var got []string
+	for _, ei := range collect(c, 200*time.Millisecond) {
+		rel, err := filepath.Rel(tmpDir, ei.Path())
+		mustT(t, err)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	sort.Strings(got)
+	if want := []string{"a/x", "data/x", "x"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	// Excluded directories created later are neither watched nor reported.
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "a", "cache"), 0755))
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "cache", "sub"), 0755))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 || ev[0].Path() != filepath.Join(tmpDir, "a", "cache") {
+		t.Fatalf("want single Create event for a/cache, got %v", ev)
+	}
+}
+
+func TestWatchInitialScan(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "a"), 0755))
+	for _, path := range []string{"a/x", "b", "c.tmp"} {
+		mustT(t, os.WriteFile(filepath.Join(tmpDir, filepath.FromSlash(path)), nil, 0666))
+	}
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPattern("*.tmp"))
+	// The channel is smaller than the number of existing files, synthetic
+	// events must not be dropped anyway.
+	c := make(chan EventInfo, 1)
+	mustT(t, WatchOpts(filepath.Join(tmpDir, "..."), c, WithEvents(Create), WithIgnoreMatcher(im), WithInitialScan()))
+	defer Stop(c)
+
+	var got []string
+	for _, ei := range collect(c, 200*time.Millisecond) {
+		if ei.Event() != Create {
+			t.Errorf("want Create, got %v", ei)
+		}
+		got = append(got, ei.Path())
+	}
+	want := []string{
+		filepath.Join(tmpDir, "a"),
+		filepath.Join(tmpDir, "a", "x"),
+		filepath.Join(tmpDir, "b"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "d"), nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 || ev[0].Path() != filepath.Join(tmpDir, "d") {
+		t.Fatalf("want single event for d, got %v", ev)
+	}
+}
+
+func TestRescan(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "a"), 0755))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "a", "x"), nil, 0666))
+	if err := Rescan(tmpDir); !errors.Is(err, errNotWatched) {
+		t.Fatalf("want errNotWatched, got %v", err)
+	}
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(filepath.Join(tmpDir, "..."), c, All))
+	defer Stop(c)
+
+	// synthetic collects the synthetic events, which carry os.FileInfo.
+	synthetic := func() map[string]Event {
+		m := make(map[string]Event)
+		for _, ei := range collect(c, 200*time.Millisecond) {
+			if _, ok := ei.Sys().(os.FileInfo); ok {
+				m[ei.Path()] |= ei.Event()
+			}
+		}
+		return m
+	}
+	mustT(t, Rescan(filepath.Join(tmpDir, "...")))
+	want := map[string]Event{
+		filepath.Join(tmpDir, "a"):      Create,
+		filepath.Join(tmpDir, "a", "x"): Create,
+	}
+	if got := synthetic(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	mustT(t, os.Remove(filepath.Join(tmpDir, "a", "x")))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "y"), nil, 0666))
+	if got := synthetic(); len(got) != 0 {
+		t.Fatalf("want only events reported by the OS, got %v", got)
+	}
+	mustT(t, Rescan(filepath.Join(tmpDir, "...")))
+	want = map[string]Event{
+		filepath.Join(tmpDir, "a", "x"): Remove,
+		filepath.Join(tmpDir, "y"):      Create,
+	}
+	if got := synthetic(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	mustT(t, Rescan(filepath.Join(tmpDir, "...")))
+	if got := synthetic(); len(got) != 0 {
+		t.Fatalf("want no events for unchanged files, got %v", got)
+	}
+}
+
+func collect(c <-chan EventInfo, timeout time.Duration) (ev []EventInfo) {
+	for {
+		select {
+		case ei := <-c:
+			ev = append(ev, ei)
+		case <-time.After(timeout):
+			return ev
+		}
+	}
+}
+
 func mustT(t testing.TB, err error) {
 	t.Helper()
 	if err != nil {
@@ -223,3 +752,679 @@ func samefile(t *testing.T, p1, p2 string) bool {
 	mustT(t, err)
 	return os.SameFile(fi1, fi2)
 }
+
+func TestStopAndDrain(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	file := filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(file, nil, 0666))
+	fi, err := os.Stat(file)
+	mustT(t, err)
+
+	const n = 50
+	c := make(chan EventInfo, n)
+	mustT(t, Watch(tmpDir, c, Create))
+	cb := make(chan EventInfo)
+	mustT(t, WatchOpts(tmpDir, cb, WithEvents(Create), WithBuffer(n)))
+	received := make(chan int)
+	go func() {
+		i := 0
+		for range cb {
+			i++
+		}
+		received <- i
+	}()
+
+	for i := 0; i < n; i++ {
+		defaultTree.Inject(&pollEvent{path: file, event: Create, fi: fi})
+	}
+	StopAndDrain(c)
+	StopAndDrain(cb)
+	close(cb)
+	if len(c) != n {
+		t.Errorf("want %d events, got %d", n, len(c))
+	}
+	if i := <-received; i != n {
+		t.Errorf("want %d buffered events, got %d", n, i)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "old"), nil, 0666))
+	plain, rescan := make(chan EventInfo, 10), make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, plain, Create, Remove))
+	defer Stop(plain)
+	mustT(t, WatchOpts(tmpDir, rescan, WithEvents(Create, Remove), WithResumeRescan()))
+	defer Stop(rescan)
+
+	Pause(plain)
+	Pause(rescan)
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "new"), nil, 0666))
+	mustT(t, os.Remove(filepath.Join(tmpDir, "old")))
+	if ev := append(collect(plain, 200*time.Millisecond), collect(rescan, 0)...); len(ev) != 0 {
+		t.Fatalf("want no events while paused, got %v", ev)
+	}
+	Resume(plain)
+	Resume(rescan)
+	if ev := collect(plain, 100*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want missed events discarded, got %v", ev)
+	}
+	got := make(map[string]Event)
+	for _, ei := range collect(rescan, 100*time.Millisecond) {
+		got[filepath.Base(ei.Path())] = ei.Event()
+	}
+	if want := map[string]Event{"new": Create, "old": Remove}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v reported on resume, got %v", want, got)
+	}
+
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "next"), nil, 0666))
+	for _, c := range []chan EventInfo{plain, rescan} {
+		if ev := collect(c, 200*time.Millisecond); len(ev) != 1 || filepath.Base(ev[0].Path()) != "next" {
+			t.Fatalf("want single event for next after resume, got %v", ev)
+		}
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	var mu sync.Mutex
+	var msgs []string
+	SetLogger(func(format string, args ...interface{}) {
+		mu.Lock()
+		msgs = append(msgs, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	})
+	defer SetLogger(nil)
+
+	tmpDir := t.TempDir()
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPattern("*.tmp"))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchWithIgnore(tmpDir, c, im, Create))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "file.tmp"), nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events, got %v", ev)
+	}
+	Stop(c)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var ignored bool
+	for _, msg := range msgs {
+		if !strings.HasPrefix(msg, "notify: ") {
+			t.Errorf("want message prefixed with package name, got %q", msg)
+		}
+		ignored = ignored || strings.Contains(msg, "ignored") && strings.Contains(msg, "file.tmp")
+	}
+	if len(msgs) < 2 || !ignored {
+		t.Fatalf("want messages about the watch and the ignored event, got %q", msgs)
+	}
+}
+
+func TestWatchOnce(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := os.WriteFile(file, nil, 0666); err != nil {
+			t.Error(err)
+		}
+	}()
+	ei, err := WatchOnce(tmpDir, Create)
+	mustT(t, err)
+	if ei.Event() != Create || filepath.Base(ei.Path()) != "file" {
+		t.Fatalf("want Create for file, got %v", ei)
+	}
+	if ws := WatchList(); len(ws) != 0 {
+		t.Fatalf("want no watches after WatchOnce, got %v", ws)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := WatchOnceContext(ctx, tmpDir, Create); err != context.DeadlineExceeded {
+		t.Fatalf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+	if _, err := WatchOnce(tmpDir); err == nil {
+		t.Fatal("want error for no events")
+	}
+}
+
+func TestWaitForEvent(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	file := filepath.Join(tmpDir, "done")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := os.WriteFile(file, nil, 0666); err != nil {
+			t.Error(err)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ei, err := WaitForEvent(ctx, file, Create)
+	mustT(t, err)
+	if ei.Event() != Create || ei.Path() != file {
+		t.Fatalf("want Create for %s, got %v", file, ei)
+	}
+
+	// An existing file is watched itself.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		if err := os.WriteFile(file, []byte("x"), 0666); err != nil {
+			t.Error(err)
+		}
+	}()
+	ei, err = WaitForEvent(ctx, file, Write)
+	mustT(t, err)
+	if ei.Event() != Write || ei.Path() != file {
+		t.Fatalf("want Write for %s, got %v", file, ei)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := WaitForEvent(ctx, filepath.Join(tmpDir, "missing"), Create); err != context.DeadlineExceeded {
+		t.Fatalf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+	if ws := WatchList(); len(ws) != 0 {
+		t.Fatalf("want no watches after WaitForEvent, got %v", ws)
+	}
+	if _, err := WaitForEvent(ctx, file); err == nil {
+		t.Fatal("want error for no events")
+	}
+}
+
+func TestEventTimestamp(t *testing.T) {
+	tmpDir := t.TempDir()
+	c := make(chan EventInfo, 1)
+	mustT(t, Watch(tmpDir, c, Create))
+	defer Stop(c)
+	before := time.Now()
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "file"), nil, 0666))
+	select {
+	case ei := <-c:
+		after := time.Now()
+		ts, ok := ei.(Timestamp)
+		if !ok {
+			t.Fatalf("want %T to implement Timestamp", ei)
+		}
+		if tm := ts.Time(); tm.Before(before) || tm.After(after) {
+			t.Fatalf("want time between %v and %v, got %v", before, after, tm)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Create")
+	}
+}
+
+func TestPlanWatch(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	for _, dir := range []string{"a/b", "node_modules/x", "c"} {
+		mustT(t, os.MkdirAll(filepath.Join(tmpDir, filepath.FromSlash(dir)), 0755))
+	}
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPattern("node_modules/"))
+	SetIgnoreMatcher(im)
+	defer SetIgnoreMatcher(nil)
+
+	dirs, err := PlanWatch(filepath.Join(tmpDir, "..."), Create)
+	mustT(t, err)
+	want := []string{tmpDir}
+	if _, ok := defaultTree.(*recursiveTree); !ok {
+		want = []string{
+			tmpDir,
+			filepath.Join(tmpDir, "a"),
+			filepath.Join(tmpDir, "a", "b"),
+			filepath.Join(tmpDir, "c"),
+		}
+	}
+	if !reflect.DeepEqual(dirs, want) {
+		t.Fatalf("want %v, got %v", want, dirs)
+	}
+	if ws := WatchList(); len(ws) != 0 {
+		t.Fatalf("want no watches after PlanWatch, got %v", ws)
+	}
+
+	dirs, err = PlanWatch(tmpDir, Create)
+	mustT(t, err)
+	if !reflect.DeepEqual(dirs, []string{tmpDir}) {
+		t.Fatalf("want %v, got %v", []string{tmpDir}, dirs)
+	}
+	if _, err := PlanWatch(filepath.Join(tmpDir, "missing", "..."), Create); err == nil {
+		t.Fatal("want error for missing path")
+	}
+}
+
+func TestWatchStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	mustT(t, os.MkdirAll(filepath.Join(tmpDir, "a", "b"), 0755))
+	before := WatchStats().Watches
+	c := make(chan EventInfo, 1)
+	mustT(t, Watch(filepath.Join(tmpDir, "..."), c, Create))
+	want := before + 3
+	if _, ok := defaultTree.(*recursiveTree); ok {
+		want = before + 1
+	}
+	if n := WatchStats().Watches; n != want {
+		t.Fatalf("want %d watches, got %d", want, n)
+	}
+	Stop(c)
+	if n := WatchStats().Watches; n != before {
+		t.Fatalf("want %d watches after Stop, got %d", before, n)
+	}
+}
+
+func TestWatchRecursive(t *testing.T) {
+	tmpDir := t.TempDir()
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "a"), 0755))
+	c := make(chan EventInfo, 1)
+	mustT(t, WatchRecursive(tmpDir, c, Create))
+	defer Stop(c)
+	file := filepath.Join(tmpDir, "a", "file")
+	mustT(t, os.WriteFile(file, nil, 0666))
+	select {
+	case ei := <-c:
+		if filepath.Base(ei.Path()) != "file" {
+			t.Fatalf("want Create for %q, got %v", file, ei)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Create")
+	}
+	if err := WatchRecursive(filepath.Join(tmpDir, "..."), c, Create); err == nil {
+		t.Fatal("want error for suffixed path")
+	}
+}
+
+func TestWatchRecursiveCreatedTree(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	c := make(chan EventInfo, 20)
+	mustT(t, WatchRecursive(tmpDir, c, Create))
+	defer Stop(c)
+
+	for i := 0; i < 10; i++ {
+		dir := filepath.Join(tmpDir, strconv.Itoa(i), "b", "c")
+		mustT(t, os.MkdirAll(dir, 0755))
+		mustT(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0666))
+		got := make(map[string]bool)
+		for _, ei := range collect(c, 200*time.Millisecond) {
+			got[ei.Path()] = true
+		}
+		for _, path := range []string{"", "b", "b/c", "b/c/file"} {
+			if path = filepath.Join(tmpDir, strconv.Itoa(i), filepath.FromSlash(path)); !got[path] {
+				t.Fatalf("want Create for %q, got %v", path, got)
+			}
+		}
+	}
+}
+
+func TestWatchFilesOnly(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(filepath.Join(tmpDir, "..."), c, WithEvents(Create, Remove), WithFilesOnly()))
+	defer Stop(c)
+
+	dir := filepath.Join(tmpDir, "dir")
+	file := filepath.Join(dir, "file")
+	expect := func(want ...string) {
+		t.Helper()
+		var got []string
+		for _, ei := range collect(c, 200*time.Millisecond) {
+			got = append(got, ei.Event().String()+" "+filepath.Base(ei.Path()))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+	mustT(t, os.Mkdir(dir, 0755))
+	expect()
+	mustT(t, os.WriteFile(file, nil, 0666))
+	expect("notify.Create file")
+	mustT(t, os.Remove(file))
+	expect("notify.Remove file")
+	mustT(t, os.Remove(dir))
+	expect()
+}
+
+func TestWatchDirectoryCoalescing(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	dir := filepath.Join(tmpDir, "dir")
+	mustT(t, os.Mkdir(dir, 0755))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(filepath.Join(tmpDir, "..."), c,
+		WithEvents(Create, Remove),
+		WithDirectoryCoalescing(100*time.Millisecond),
+	))
+	defer Stop(c)
+
+	for _, name := range []string{"a", "b", "c"} {
+		mustT(t, os.WriteFile(filepath.Join(dir, name), nil, 0666))
+	}
+	mustT(t, os.Remove(filepath.Join(dir, "a")))
+	ev := collect(c, 300*time.Millisecond)
+	if len(ev) != 1 {
+		t.Fatalf("want single event, got %v", ev)
+	}
+	if ev[0].Path() != dir {
+		t.Errorf("want path %s, got %s", dir, ev[0].Path())
+	}
+	if ev[0].Event() != Create|Remove {
+		t.Errorf("want %v, got %v", Create|Remove, ev[0].Event())
+	}
+	if di, ok := ev[0].(DirInfo); !ok || !di.IsDir() {
+		t.Errorf("want event of a directory, got %v", ev[0])
+	}
+}
+
+func TestWatchUnifiedEvent(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(tmpDir, c, WithEvents(Create, Remove), WithUnifiedEvent(), WithTrimRoot()))
+	defer Stop(c)
+
+	file := filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(file, nil, 0666))
+	mustT(t, os.Remove(file))
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 2 {
+		t.Fatalf("want 2 events, got %v", ev)
+	}
+	for _, ei := range ev {
+		if ei.Event() != Any || ei.Path() != file {
+			t.Errorf("want %v for %s, got %v", Any, file, ei)
+		}
+		ri, ok := ei.(RelInfo)
+		if !ok {
+			t.Fatalf("%v does not implement RelInfo", ei)
+		}
+		if rel, err := ri.Rel(); err != nil || rel != "file" {
+			t.Errorf("want Rel()=file, got %q (%v)", rel, err)
+		}
+	}
+}
+
+func TestWatchTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	mustT(t, os.MkdirAll(filepath.Join(tmpDir, "a", "b"), 0755))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "a", "file"), nil, 0666))
+
+	c := make(chan EventInfo, 100)
+	tr, err := WatchTree(filepath.Join(tmpDir, "..."), c, Write)
+	mustT(t, err)
+	defer Stop(c)
+
+	children := func(dir string, want ...string) {
+		t.Helper()
+		if got := tr.Children(filepath.Join(tmpDir, dir)); !reflect.DeepEqual(got, want) {
+			t.Errorf("Children(%q)=%v, want %v", dir, got, want)
+		}
+	}
+	children(".", "a")
+	children("a", "b", "file")
+	if !tr.Exists(filepath.Join(tmpDir, "a", "file")) {
+		t.Error("want a/file to exist")
+	}
+	if tr.Children(filepath.Join(tmpDir, "a", "file")) != nil {
+		t.Error("want no children of a file")
+	}
+
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "c"), 0755))
+	mustT(t, os.RemoveAll(filepath.Join(tmpDir, "a")))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 0 {
+		t.Errorf("want no events delivered, got %v", ev)
+	}
+	children(".", "c")
+	if tr.Exists(filepath.Join(tmpDir, "a", "b")) {
+		t.Error("want a/b removed")
+	}
+
+	file := filepath.Join(tmpDir, "c", "file")
+	mustT(t, os.WriteFile(file, []byte("x"), 0666))
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) == 0 || ev[0].Event() != Write {
+		t.Fatalf("want Write event, got %v", ev)
+	}
+	children("c", "file")
+}
+
+func TestWatchFSEventsLatency(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(tmpDir, c, WithEvents(Create), WithFSEventsLatency(50*time.Millisecond)))
+	defer Stop(c)
+
+	file := filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(file, nil, 0666))
+	ev := collect(c, 500*time.Millisecond)
+	if len(ev) != 1 || ev[0].Event() != Create || ev[0].Path() != file {
+		t.Fatalf("want single Create event for %q, got %v", file, ev)
+	}
+}
+
+func TestWatchTrimRoot(t *testing.T) {
+	tmpDir := t.TempDir()
+	mustT(t, os.MkdirAll(filepath.Join(tmpDir, "a", "b"), 0755))
+	c := make(chan EventInfo, 1)
+	mustT(t, WatchOpts(filepath.Join(tmpDir, "..."), c, WithEvents(Create), WithTrimRoot()))
+	defer Stop(c)
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "a", "b", "file"), nil, 0666))
+	select {
+	case ei := <-c:
+		ri, ok := ei.(RelInfo)
+		if !ok {
+			t.Fatalf("want %T to implement RelInfo", ei)
+		}
+		if rel, err := ri.Rel(); err != nil || rel != "a/b/file" {
+			t.Fatalf("want a/b/file, got %q (%v)", rel, err)
+		}
+		if _, ok := ei.(DirInfo); !ok {
+			t.Fatalf("want %T to implement DirInfo", ei)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Create")
+	}
+}
+
+func TestWatchDirOnlyPatternFile(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	im := NewIgnoreMatcher(tmpDir)
+	mustT(t, im.AddPatterns("build/", "out/"))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchWithIgnore(tmpDir, c, im, Create))
+	defer Stop(c)
+
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "build"), nil, 0666))
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "out"), 0755))
+	ev := collect(c, 200*time.Millisecond)
+	if want := filepath.Join(tmpDir, "build"); len(ev) != 1 || ev[0].Path() != want {
+		t.Fatalf("want single event for file %q, got %v", want, ev)
+	}
+}
+
+func TestWatchIgnoreRelativeRoot(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	wd, err := os.Getwd()
+	mustT(t, err)
+	mustT(t, os.Chdir(tmpDir))
+	defer os.Chdir(wd)
+
+	im := NewIgnoreMatcher(".")
+	mustT(t, im.AddPatterns("*.log", "/build/"))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchWithIgnore(".", c, im, Create))
+	defer Stop(c)
+
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "app.log"), nil, 0666))
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "build"), 0755))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "main.go"), nil, 0666))
+	ev := collect(c, 200*time.Millisecond)
+	if want := filepath.Join(tmpDir, "main.go"); len(ev) != 1 || ev[0].Path() != want {
+		t.Fatalf("want single event for %q, got %v", want, ev)
+	}
+}
+
+func TestWatchFunc(t *testing.T) {
+	tmpDir := t.TempDir()
+	var (
+		mu       sync.Mutex
+		paths    []string
+		inflight int32
+	)
+	stop, err := WatchFunc(tmpDir, func(ei EventInfo) {
+		if atomic.AddInt32(&inflight, 1) != 1 {
+			t.Error("callback called concurrently")
+		}
+		defer atomic.AddInt32(&inflight, -1)
+		time.Sleep(10 * time.Millisecond) // slow consumer
+		mu.Lock()
+		paths = append(paths, filepath.Base(ei.Path()))
+		mu.Unlock()
+	}, Create)
+	mustT(t, err)
+
+	for _, name := range []string{"a", "b", "c"} {
+		mustT(t, os.WriteFile(filepath.Join(tmpDir, name), nil, 0666))
+	}
+	time.Sleep(200 * time.Millisecond)
+	stop()
+	stop()
+	mu.Lock()
+	if len(paths) != 3 {
+		t.Errorf("want 3 events, got %v", paths)
+	}
+	n := len(paths)
+	mu.Unlock()
+
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "d"), nil, 0666))
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != n {
+		t.Errorf("want no events after stop, got %v", paths[n:])
+	}
+
+	// The watchpoint may be stopped from within the callback.
+	done := make(chan struct{})
+	stopc := make(chan func(), 1)
+	stop, err = WatchFunc(tmpDir, func(EventInfo) {
+		(<-stopc)()
+		close(done)
+	}, Create)
+	mustT(t, err)
+	stopc <- stop
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "e"), nil, 0666))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the callback")
+	}
+}
+
+func TestWatchMany(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	a, b := filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b")
+	mustT(t, os.Mkdir(a, 0755))
+	mustT(t, os.Mkdir(b, 0755))
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(tmpDir, c, Create))
+	defer Stop(c)
+
+	// A failing path rolls back the whole registration, but not the
+	// watchpoints set up before.
+	if err := WatchMany([]string{a, filepath.Join(tmpDir, "missing"), b}, c, Create); err == nil {
+		t.Fatal("want error for missing path")
+	}
+	if got, want := WatchList(), []WatchEntry{{Path: tmpDir, Events: Create}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	mustT(t, WatchMany([]string{a, b}, c, Create))
+	for _, dir := range []string{a, b} {
+		mustT(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0666))
+	}
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 2 {
+		t.Fatalf("want 2 events, got %v", ev)
+	}
+}
+
+func TestWatchFollow(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	log, rotated := filepath.Join(tmpDir, "app.log"), filepath.Join(tmpDir, "app.log.1")
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "other"), nil, 0666))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchFollow(log, c, Write))
+	defer Stop(c)
+
+	appendTo := func(path string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		mustT(t, err)
+		_, err = f.WriteString("line\n")
+		mustT(t, err)
+		mustT(t, f.Close())
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		var got []string
+		for _, ei := range collect(c, 200*time.Millisecond) {
+			got = append(got, ei.Event().String()+" "+filepath.Base(ei.Path()))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+	appendTo(log)
+	expect("notify.Write app.log")
+	appendTo(filepath.Join(tmpDir, "other"))
+	expect()
+
+	mustT(t, os.Rename(log, rotated))
+	expect()
+	appendTo(rotated)
+	expect()
+	appendTo(log)
+	expect("notify.Write app.log")
+}
+
+func TestVerifyWatch(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	file := filepath.Join(tmpDir, "file")
+	mustT(t, os.WriteFile(file, nil, 0666))
+	c1, c2 := make(chan EventInfo, 10), make(chan EventInfo, 10)
+	mustT(t, Watch(file, c1, Write))
+	defer Stop(c1)
+	mustT(t, WatchOpts(tmpDir, c2, WithEvents(Create), WithFilesOnly()))
+	defer Stop(c2)
+	for _, c := range []chan EventInfo{c1, c2, make(chan EventInfo)} {
+		if err := VerifyWatch(c); err != nil {
+			t.Fatalf("want no error, got %v", err)
+		}
+	}
+
+	mustT(t, os.Rename(file, filepath.Join(tmpDir, "moved")))
+	for deadline := time.Now().Add(time.Second); ; {
+		err := VerifyWatch(c1)
+		if e, ok := err.(*StaleWatchError); ok {
+			if !reflect.DeepEqual(e.Paths, []string{file}) {
+				t.Fatalf("want %q stale, got %q", file, e.Paths)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want *StaleWatchError, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := VerifyWatch(c2); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+// Package notifytest provides helpers for testing code which uses notify
+// package, so tests can wait for the events they expect instead of sleeping.
+package notifytest
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/raphamorim/notify"
+)
+
+// WaitFor receives events from c until one matching path and ev arrives, and
+// returns it. Events which do not match are discarded. An event matches if
+// its path is equal to path, after both are cleaned, and its event value has
+// any of the bits of ev set. An empty path matches every path, an ev of 0
+// matches every event.
+//
+// WaitFor fails if no matching event arrived within timeout, or if c was
+// closed.
+func WaitFor(c <-chan notify.EventInfo, path string, ev notify.Event, timeout time.Duration) (notify.EventInfo, error) {
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for {
+		select {
+		case ei, ok := <-c:
+			if !ok {
+				return nil, fmt.Errorf("notifytest: channel closed while waiting for %v on %q", ev, path)
+			}
+			if path != "" && filepath.Clean(ei.Path()) != path {
+				continue
+			}
+			if ev != 0 && ei.Event()&ev == 0 {
+				continue
+			}
+			return ei, nil
+		case <-t.C:
+			return nil, fmt.Errorf("notifytest: timed out after %v waiting for %v on %q", timeout, ev, path)
+		}
+	}
+}
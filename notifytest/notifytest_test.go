@@ -0,0 +1,45 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notifytest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raphamorim/notify"
+)
+
+type event struct {
+	path string
+	ev   notify.Event
+}
+
+func (e event) Event() notify.Event { return e.ev }
+func (e event) Path() string        { return e.path }
+func (e event) Sys() interface{}    { return nil }
+
+func TestWaitFor(t *testing.T) {
+	c := make(chan notify.EventInfo, 3)
+	c <- event{"/a", notify.Write}
+	c <- event{"/b", notify.Create}
+	c <- event{"/b", notify.Write}
+	ei, err := WaitFor(c, "/b/", notify.Write, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ei.Path() != "/b" || ei.Event() != notify.Write {
+		t.Fatalf("want Write on /b, got %v", ei)
+	}
+	if len(c) != 0 {
+		t.Fatalf("want all events received, %d left", len(c))
+	}
+	if _, err := WaitFor(c, "", 0, 10*time.Millisecond); err == nil {
+		t.Fatal("want error on timeout")
+	}
+	close(c)
+	if _, err := WaitFor(c, "", 0, time.Second); err == nil {
+		t.Fatal("want error on closed channel")
+	}
+}
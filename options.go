@@ -0,0 +1,548 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Option configures a single watchpoint created with WatchOpts.
+type Option func(*options)
+
+// options holds the per-watchpoint configuration gathered from Options.
+type options struct {
+	events   []Event
+	ctx      context.Context
+	im       *IgnoreMatcher
+	pred     func(EventInfo) bool
+	coalesce time.Duration
+	dirs     time.Duration
+	debounce time.Duration
+	maxDepth int
+	poll     time.Duration
+	dedup    time.Duration
+	scan     bool
+	follow   bool
+	rate     float64
+	buffer   int
+	policy   OverflowPolicy
+	report   bool
+	trim     bool
+	throttle time.Duration
+	noroot   bool
+	resume   bool
+	files    bool
+	latency  time.Duration
+	exclude  []string
+	unified  bool
+}
+
+// WithEvents adds events to the set of events the watchpoint is registered
+// for. It may be given multiple times, the events are joined.
+func WithEvents(events ...Event) Option {
+	return func(o *options) {
+		o.events = append(o.events, events...)
+	}
+}
+
+// WithContext removes the watchpoint when ctx is done, see WatchContext.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithIgnoreMatcher filters events of the watchpoint with im in addition to
+// the global ignore matcher, see WatchWithIgnore. A nil im is ignored.
+func WithIgnoreMatcher(im *IgnoreMatcher) Option {
+	return func(o *options) {
+		o.im = im
+	}
+}
+
+// WithFilter delivers only events for which pred returns true, see WatchFilter.
+func WithFilter(pred func(EventInfo) bool) Option {
+	return func(o *options) {
+		o.pred = pred
+	}
+}
+
+// WithCoalesce collapses short-lived sequences of events for a path within
+// window, see WatchCoalesced. A non-positive window disables coalescing.
+func WithCoalesce(window time.Duration) Option {
+	return func(o *options) {
+		o.coalesce = window
+	}
+}
+
+// WithDirectoryCoalescing reports changes by directory rather than by file,
+// for consumers which rescan a whole directory whenever anything in it
+// changed, like after a bulk checkout or extraction. Every event is mapped to
+// the parent directory of its path, and a single event per directory is
+// delivered window after the first change in it, with the path of the
+// directory and the events of all its changes joined in Event. The event
+// implements DirInfo, reporting a directory. Overflow events are delivered
+// as is. A non-positive window disables the coalescing, which is the default.
+func WithDirectoryCoalescing(window time.Duration) Option {
+	return func(o *options) {
+		o.dirs = window
+	}
+}
+
+// WithDebounce delivers only the last event of a burst of events for a path,
+// see WatchDebounced. A non-positive window disables debouncing.
+func WithDebounce(window time.Duration) Option {
+	return func(o *options) {
+		o.debounce = window
+	}
+}
+
+// WithThrottle delivers at most one event per interval for the whole
+// watchpoint, like for redrawing a view whenever anything changed. The first
+// event starts an interval and the last event which arrived within it is
+// delivered when the interval ends, standing for all of them. Unlike with
+// WithDebounce, continuous changes do not postpone the delivery, and since
+// the last event is always delivered, so is the final state after the
+// changes stop. A non-positive interval disables throttling, which is the
+// default.
+func WithThrottle(interval time.Duration) Option {
+	return func(o *options) {
+		o.throttle = interval
+	}
+}
+
+// DedupWindow is the window suggested for WithDedup. It is long enough to
+// catch duplicates reported for a single save, and short enough not to hide
+// distinct writes.
+const DedupWindow = 5 * time.Millisecond
+
+// WithDedup drops an event if an identical one, with the same path and event
+// value, was delivered less than window ago. Unlike WithDebounce, it does not
+// delay any events, it is meant for suppressing duplicates reported by the
+// OS for a single action, like two Write events for a single save, so window
+// should be very short, see DedupWindow. A non-positive window disables
+// deduplication, which is the default.
+func WithDedup(window time.Duration) Option {
+	return func(o *options) {
+		o.dedup = window
+	}
+}
+
+// WithMaxDepth limits a recursive watchpoint to n levels of directories below
+// the watched root: n=0 watches the root directory only, n=1 the root and its
+// immediate subdirectories and so on. Directories created later within the
+// limit are watched as well. Events in directories deeper than n are not
+// reported at all, since those directories are not watched. A negative n
+// means no limit, which is the default. The option has no effect on
+// non-recursive watchpoints.
+//
+// Depth-limited watchpoints are made of a separate watch per directory, so
+// unlike unlimited ones they do not benefit from native recursive watching
+// on platforms that support it.
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		o.maxDepth = n
+	}
+}
+
+// WithPollingFallback makes the watchpoint poll, every interval, the
+// directories which cannot be watched natively since the limit of watches or
+// open files is exhausted, like when inotify fails with ENOSPC after running
+// out of max_user_watches. Events of the polled directories are delivered
+// as described by WatchPoll, along with events of the natively watched ones.
+// A non-positive interval disables the fallback, which is the default.
+//
+// For recursive watchpoints the fallback applies to each subdirectory
+// separately, which requires watching them one by one, like with WithMaxDepth.
+func WithPollingFallback(interval time.Duration) Option {
+	return func(o *options) {
+		o.poll = interval
+	}
+}
+
+// WithInitialScan makes the watchpoint report every existing file and
+// directory within the watched path with a synthetic Create event, so files
+// present when the watch starts can be handled the same way as files created
+// later. The scan is done right after the watchpoint is set up, so no change
+// made in the meantime is missed, however a file created during the scan may
+// be reported twice. Paths are reported parents first, ignoring the paths
+// ignored by the global ignore matcher and the watchpoint's one.
+//
+// Unlike real events, synthetic ones are not dropped if c is not ready to
+// receive them, the scan waits for the receiver instead.
+//
+// The scan is done only if Create is among the watched events. Like the ones
+// of WatchPoll, synthetic events implement DirInfo and StatInfo, and their
+// Sys returns an os.FileInfo value.
+func WithInitialScan() Option {
+	return func(o *options) {
+		o.scan = true
+	}
+}
+
+// WithRateLimit limits the events delivered for each path to eventsPerSecond,
+// so a single path changed over and over, like a log file appended to
+// thousands of times a second, cannot flood c, while events of other paths
+// are delivered as usual. Surplus events of a path are dropped, except for
+// the most recent one, which is delivered as soon as the rate allows, so
+// the last change of a path is always reported. A non-positive rate disables
+// the limit, which is the default.
+//
+// Unlike WithDebounce, it does not delay events of a path which changes
+// rarely.
+func WithRateLimit(eventsPerSecond float64) Option {
+	return func(o *options) {
+		o.rate = eventsPerSecond
+	}
+}
+
+// WithTrimRoot makes the events of the watchpoint implement RelInfo, which
+// gives their paths relative to the watched path, so they can be matched or
+// displayed without repeating the work of trimming the root. Path is not
+// affected, it still gives the full path.
+func WithTrimRoot() Option {
+	return func(o *options) {
+		o.trim = true
+	}
+}
+
+// WithIgnoreRootEvents drops events of the watched path itself, like
+// metadata changes of the watched directory, for consumers interested only in
+// its contents. Remove and Rename events of the watched path are delivered
+// regardless, since after them the watchpoint needs to be set up again.
+//
+// Which events of the watched directory itself are reported depends on the
+// platform: inotify reports its metadata changes and its removal or move,
+// kqueue and FEN report Attrib, Remove and Rename, FSEvents reports all kinds
+// of events for it, and ReadDirectoryChangesW reports only events of the
+// directory contents, so the option has no effect under Windows.
+func WithIgnoreRootEvents() Option {
+	return func(o *options) {
+		o.noroot = true
+	}
+}
+
+// WithFilesOnly drops events of directories, like their creation or removal,
+// for consumers interested in files only. Unlike ignoring directories with
+// patterns, it does not stop the watchpoint from watching them, so events of
+// files within them are still delivered. Events for which it is not known
+// whether they concern a directory are delivered, see DirInfo.
+func WithFilesOnly() Option {
+	return func(o *options) {
+		o.files = true
+	}
+}
+
+// WithExcludePaths excludes the given directories, together with everything
+// below them, from the watchpoint. The paths are either absolute or relative
+// to the watched path. For recursive watchpoints the directories are not
+// walked nor watched, like directories pruned by ignore patterns, for all
+// watchpoints their events are dropped.
+//
+// It is meant for a known list of directories to skip, for which writing
+// ignore patterns would be awkward. The directories do not have to exist when
+// the watchpoint is set up. Directories pruned by the ignore matchers are
+// still pruned.
+func WithExcludePaths(paths []string) Option {
+	return func(o *options) {
+		o.exclude = append(o.exclude, paths...)
+	}
+}
+
+// WithUnifiedEvent delivers every event of the watchpoint as Any, for
+// consumers which only care that something changed at a path, rather than
+// what it was. The path of an event is kept, so are DirInfo, StatInfo,
+// Timestamp and RelInfo, RenamedInfo is not. Overflow events are delivered
+// as is, since they need to be handled differently.
+//
+// It only changes the delivered events: the watchpoint is still registered
+// for, and filtered, debounced etc. by, the events given with WithEvents.
+func WithUnifiedEvent() Option {
+	return func(o *options) {
+		o.unified = true
+	}
+}
+
+// WithResumeRescan makes the watchpoint report the changes of the files within
+// the watched path, which were missed while its channel was paused with Pause,
+// once it is resumed with Resume. The files are compared with a snapshot taken
+// on Pause, like Rescan does, so only Create, Remove and Write events are
+// reported, and a file changed back and forth in the meantime is not reported
+// at all.
+func WithResumeRescan() Option {
+	return func(o *options) {
+		o.resume = true
+	}
+}
+
+// OverflowPolicy tells what a watchpoint does with an event when its buffer,
+// see WithBuffer, is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the event which does not fit in the buffer.
+	// It is the default.
+	OverflowDropNewest OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest event in the buffer to make room for
+	// the new one.
+	OverflowDropOldest
+
+	// OverflowBlock waits until the receiver makes room in the buffer. Since
+	// the watcher never waits for a watchpoint, events which arrive meanwhile
+	// are dropped before they reach the buffer, like for a slow receiver
+	// without a buffer.
+	OverflowBlock
+)
+
+// WithBuffer queues up to n events of the watchpoint which c is not ready to
+// receive, instead of dropping them, so a receiver which falls behind during a
+// burst of events can catch up later. Events are sent to c from a separate
+// goroutine, so the buffer does not slow down the watcher. What happens when
+// the buffer is full is set with WithOverflowPolicy. A non-positive n disables
+// the buffer, which is the default.
+func WithBuffer(n int) Option {
+	return func(o *options) {
+		o.buffer = n
+	}
+}
+
+// WithOverflowPolicy sets what the watchpoint does when its buffer is full,
+// see OverflowPolicy. If report is true, an Overflow event with an empty path
+// is delivered after events were dropped, ahead of the buffered ones. The
+// option has no effect without WithBuffer.
+func WithOverflowPolicy(policy OverflowPolicy, report bool) Option {
+	return func(o *options) {
+		o.policy, o.report = policy, report
+	}
+}
+
+// WithFollowSymlinks makes a recursive watchpoint follow symlinks to
+// directories found within the watched path, including ones created later,
+// and watch the directories they point to as if they were regular
+// subdirectories. Events of such directories are reported under the paths of
+// the symlinks. The option has no effect on non-recursive watchpoints.
+//
+// By default symlinks within the watched path are not followed. If they are,
+// a directory reached through a symlink is watched only once, and a symlink
+// pointing at one of its parents is skipped rather than making the watchpoint
+// cycle, which is reported to the logger set with SetLogger. Regardless of the
+// option, the watched path itself is always resolved, see Watch.
+//
+// Like with WithMaxDepth, a watchpoint following symlinks is made of a
+// separate watch per directory.
+func WithFollowSymlinks() Option {
+	return func(o *options) {
+		o.follow = true
+	}
+}
+
+// stages builds the event pipeline for the options. The order of the stages
+// does not depend on the order of the options: events are first filtered by
+// the ignore matcher, the predicate and WithFilesOnly, then deduplicated,
+// coalesced, coalesced by directory, debounced, throttled, rate limited,
+// unified and finally buffered.
+func (o *options) stages() []stage {
+	var stages []stage
+	if o.im != nil {
+		stages = append(stages, ignoreStage(o.im))
+	}
+	if o.pred != nil {
+		stages = append(stages, filterStage(o.pred))
+	}
+	if o.files {
+		stages = append(stages, filesStage)
+	}
+	if o.dedup > 0 {
+		stages = append(stages, dedupStage(o.dedup))
+	}
+	if o.coalesce > 0 {
+		stages = append(stages, coalesceStage(o.coalesce))
+	}
+	if o.dirs > 0 {
+		stages = append(stages, dirCoalesceStage(o.dirs))
+	}
+	if o.debounce > 0 {
+		stages = append(stages, debounceStage(o.debounce))
+	}
+	if o.throttle > 0 {
+		stages = append(stages, throttleStage(o.throttle))
+	}
+	if o.rate > 0 {
+		stages = append(stages, rateLimitStage(o.rate))
+	}
+	if o.unified {
+		stages = append(stages, unifiedStage)
+	}
+	if o.buffer > 0 {
+		stages = append(stages, bufferStage(o.buffer, o.policy, o.report))
+	}
+	return stages
+}
+
+// WatchOpts sets up a watchpoint on path configured with the given options.
+// It works like Watch, which is equivalent to calling WatchOpts with
+// WithEvents only:
+//
+//	notify.WatchOpts(path, c,
+//		notify.WithEvents(notify.Create, notify.Write),
+//		notify.WithIgnoreMatcher(im),
+//		notify.WithDebounce(100*time.Millisecond),
+//	)
+//
+// Stop called on c removes all the watchpoints of c, regardless of how they
+// were created.
+func WatchOpts(path string, c chan<- EventInfo, opts ...Option) error {
+	o := options{maxDepth: -1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.latency > 0 {
+		defer withLatency(o.latency)()
+	}
+	stages := o.stages()
+	if o.trim {
+		stages = append([]stage{relStage(path)}, stages...)
+	}
+	if o.noroot {
+		stages = append([]stage{rootStage(path)}, stages...)
+	}
+	if len(o.exclude) != 0 {
+		stages = append([]stage{excludeStage(newExcludeSet(watchroot(path), o.exclude))}, stages...)
+	}
+	if link := linkStage(path); link != nil {
+		stages = append([]stage{link}, stages...)
+	}
+	managed := strings.HasSuffix(path, "...") && (o.maxDepth >= 0 || o.poll > 0 || o.follow || len(o.exclude) != 0)
+	if o.ctx == nil && len(stages) == 0 && !managed && o.poll <= 0 && !o.scan && !o.resume {
+		return watch(path, c, c, o.events)
+	}
+	if o.ctx != nil {
+		if err := o.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	var s *subscription
+	var err error
+	if managed {
+		s, err = o.subscribeTree(path, c, stages)
+	} else {
+		s, err = o.subscribe(path, c, stages)
+	}
+	if err != nil || s == nil {
+		return err
+	}
+	if o.scan {
+		o.initialScan(path, s)
+	}
+	if o.resume {
+		o.resumeRescan(path, s)
+	}
+	if o.ctx != nil {
+		go func() {
+			select {
+			case <-o.ctx.Done():
+				s.Stop()
+			case <-s.done:
+			}
+		}()
+	}
+	return nil
+}
+
+// initialScan feeds s with synthetic Create events for the files which exist
+// within path.
+func (o *options) initialScan(path string, s *subscription) {
+	if len(o.events) == 0 || joinevents(o.events)&Create == 0 {
+		return
+	}
+	root, isrec, err := cleanpath(path)
+	if err != nil {
+		return
+	}
+	max := 0
+	if isrec {
+		max = o.maxDepth
+	}
+	p := newPoller(root, max, Create)
+	ev := p.diff(nil, p.snap)
+	for _, ei := range ev {
+		ei.(*pollEvent).scan = true
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for _, ei := range ev {
+			select {
+			case s.in <- ei:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// subscribe sets up a watchpoint on path, which is polled if it cannot be
+// watched natively and the polling fallback is enabled.
+func (o *options) subscribe(path string, c chan<- EventInfo, stages []stage) (*subscription, error) {
+	s, err := subscribe(path, c, o.events, stages...)
+	if err == nil || o.poll <= 0 || !isWatchLimit(err) || len(o.events) == 0 {
+		return s, err
+	}
+	dbgprintf("watch %q failed: %v, polling instead", path, err)
+	root, isrec, err := cleanpath(path)
+	if err != nil {
+		return nil, err
+	}
+	max := 0
+	if isrec {
+		max = -1
+	}
+	s = newSubscription(c, stages)
+	newPoller(root, max, joinevents(o.events)).start(s, o.poll)
+	s.start()
+	return s, nil
+}
+
+// subscribeTree sets up a recursive watchpoint on path, which is maintained
+// by the subscription, see managedTree.
+func (o *options) subscribeTree(path string, c chan<- EventInfo, stages []stage) (*subscription, error) {
+	// Expanding with empty event set is a nop.
+	if len(o.events) == 0 {
+		return nil, nil
+	}
+	root, _, err := cleanpath(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &managedTree{
+		root:    root,
+		max:     o.maxDepth,
+		eset:    joinevents(o.events),
+		poll:    o.poll,
+		follow:  o.follow,
+		exclude: newExcludeSet(watchroot(path), o.exclude),
+	}
+	stages = append([]stage{m.stage}, stages...)
+	s, err := subscribe(root, c, []Event{m.eset | managedEvents}, stages...)
+	if err != nil {
+		if o.poll <= 0 || !isWatchLimit(err) {
+			return nil, err
+		}
+		dbgprintf("watch %q failed: %v, polling instead", root, err)
+		s = newSubscription(c, stages)
+		m.startPoll(root, 0)
+		s.start()
+		return s, nil
+	}
+	manage(s)
+	if err := m.watch(root, 0); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+//go:build darwin && !kqueue && cgo
+// +build darwin,!kqueue,cgo
+
+package notify
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	latencyMu     sync.Mutex // serializes watchpoints set up with WithFSEventsLatency
+	streamLatency int64      // latency of new streams in nanoseconds, accessed atomically
+)
+
+// WithFSEventsLatency sets the latency of the FSEvents stream created for the
+// watchpoint, that is how long FSEvents coalesces events before reporting
+// them. A low latency suits interactive tools, a high one lowers the number of
+// events reported for batch workloads. The default latency is zero.
+//
+// Only a newly created stream is affected - a path which is already watched,
+// directly or by a recursive watchpoint set up on one of its parents, keeps
+// the latency of its stream.
+//
+// The option is a nop on other platforms, so it can be used by cross-platform
+// code.
+func WithFSEventsLatency(d time.Duration) Option {
+	return func(o *options) {
+		o.latency = d
+	}
+}
+
+// withLatency makes the streams created until the returned func is called
+// use the latency d.
+func withLatency(d time.Duration) (restore func()) {
+	latencyMu.Lock()
+	atomic.StoreInt64(&streamLatency, int64(d))
+	return func() {
+		atomic.StoreInt64(&streamLatency, 0)
+		latencyMu.Unlock()
+	}
+}
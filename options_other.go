@@ -0,0 +1,22 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+//go:build !darwin || kqueue || !cgo
+// +build !darwin kqueue !cgo
+
+package notify
+
+import "time"
+
+// WithFSEventsLatency sets the latency of the FSEvents stream created for the
+// watchpoint on macOS. It is a nop on this platform, it is provided so
+// cross-platform code compiles.
+func WithFSEventsLatency(time.Duration) Option {
+	return func(*options) {}
+}
+
+// withLatency is a nop, there are no FSEvents streams on this platform.
+func withLatency(time.Duration) (restore func()) {
+	return func() {}
+}
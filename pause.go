@@ -0,0 +1,152 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	npaused int32        // len(paused), checked without the lock on every event
+	pauseMu sync.RWMutex // protects paused
+	paused  = make(map[chan<- EventInfo]struct{})
+)
+
+// Pause suspends delivery of events to c, without removing its watchpoints.
+// Events reported by the underlying watcher while c is paused are dropped,
+// until Resume is called.
+// It is meant for bulk operations known to be noisy, like a checkout of
+// a repository, for which stopping and setting up the watchpoints again would
+// be expensive.
+//
+// Once Pause returns, events received from the underlying watcher are no
+// longer delivered to c. Events held back by WithDebounce, WithCoalesce,
+// WithThrottle, WithRateLimit or WithBuffer before the call may still be
+// delivered.
+//
+// Watchpoints set up with WithResumeRescan take a snapshot of their files,
+// so the changes missed in the meantime are reported on Resume.
+//
+// Pausing a paused channel is a nop. Stop resumes the channel.
+func Pause(c chan<- EventInfo) {
+	pauseMu.Lock()
+	if _, ok := paused[c]; ok {
+		pauseMu.Unlock()
+		return
+	}
+	paused[c] = struct{}{}
+	atomic.AddInt32(&npaused, 1)
+	pauseMu.Unlock()
+	defaultTree.Flush()
+	for _, s := range subscriptions(c) {
+		s.mu.Lock()
+		pause := s.pause
+		s.mu.Unlock()
+		for _, fn := range pause {
+			fn()
+		}
+	}
+}
+
+// Resume resumes delivery of events to c paused with Pause. Resuming a channel
+// which is not paused is a nop.
+func Resume(c chan<- EventInfo) {
+	if !resume(c) {
+		return
+	}
+	for _, s := range subscriptions(c) {
+		s.mu.Lock()
+		res := s.resume
+		s.mu.Unlock()
+		for _, fn := range res {
+			fn()
+		}
+	}
+}
+
+// resume removes c from the paused channels, it reports whether c was paused.
+func resume(c chan<- EventInfo) bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	if _, ok := paused[c]; !ok {
+		return false
+	}
+	delete(paused, c)
+	atomic.AddInt32(&npaused, -1)
+	return true
+}
+
+// resetPaused resumes all the paused channels.
+func resetPaused() {
+	pauseMu.Lock()
+	paused = make(map[chan<- EventInfo]struct{})
+	atomic.StoreInt32(&npaused, 0)
+	pauseMu.Unlock()
+}
+
+// isPaused reports whether delivery of events to c is paused.
+func isPaused(c chan<- EventInfo) bool {
+	if atomic.LoadInt32(&npaused) == 0 {
+		return false
+	}
+	pauseMu.RLock()
+	_, ok := paused[c]
+	pauseMu.RUnlock()
+	return ok
+}
+
+// subscriptions gives a copy of the subscriptions delivering events to c.
+func subscriptions(c chan<- EventInfo) []*subscription {
+	subsMu.Lock()
+	defer subsMu.Unlock()
+	return append([]*subscription(nil), subs[c]...)
+}
+
+// resumeRescan makes s report the changes of the files within path, which
+// happened while its channel was paused, once it is resumed. The changes are
+// found by comparing snapshots of the files, like WatchPoll does.
+func (o *options) resumeRescan(path string, s *subscription) {
+	eset := joinevents(o.events) & (Create | Remove | Write)
+	if eset == 0 {
+		return
+	}
+	root, isrec, err := cleanpath(path)
+	if err != nil {
+		return
+	}
+	max := 0
+	if isrec {
+		max = o.maxDepth
+	}
+	var (
+		mu   sync.Mutex // protects snap
+		snap map[string]os.FileInfo
+	)
+	s.onPause(func() {
+		p := newPoller(root, max, eset)
+		mu.Lock()
+		snap = p.snap
+		mu.Unlock()
+	})
+	s.onResume(func() {
+		mu.Lock()
+		prev := snap
+		snap = nil
+		mu.Unlock()
+		if prev == nil {
+			return
+		}
+		p := newPoller(root, max, eset)
+		for _, ei := range p.diff(prev, p.snap) {
+			select {
+			case s.in <- ei:
+			case <-s.done:
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// defaultPollInterval is used by WatchPoll when non-positive interval is given.
+const defaultPollInterval = time.Second
+
+// WatchPoll works like Watch, but instead of the native filesystem
+// notification subsystem it detects changes by walking the watched path every
+// interval and comparing consecutive snapshots of the files. It is meant for
+// filesystems on which native notifications are not reported, like NFS, SMB
+// or some container mounts. A non-positive interval defaults to one second.
+//
+// Only Create, Remove and Write events are reported. A file is considered
+// written if its size or modification time changes, a rename is reported as
+// Remove of the old path and Create of the new one. Changes which are undone
+// within a single interval are not reported at all.
+//
+// Paths ignored by the global ignore matcher are not stat'ed, ignored
+// directories are not descended into.
+//
+// Events of a polling watchpoint implement DirInfo, StatInfo and Timestamp
+// interfaces, FileInfo returns the description of the file taken when the
+// event was detected. Their Sys returns the same os.FileInfo value, for Remove
+// events the last one seen.
+//
+// Stop called on c stops the polling.
+func WatchPoll(path string, c chan<- EventInfo, interval time.Duration, events ...Event) error {
+	if c == nil {
+		panic("notify: Watch using nil channel")
+	}
+	// Expanding with empty event set is a nop.
+	if len(events) == 0 {
+		return nil
+	}
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	root, isrec, err := cleanpath(path)
+	if err != nil {
+		return err
+	}
+	p := newPoller(root, 0, joinevents(events))
+	if isrec {
+		p.max = -1
+	}
+	s := newSubscription(c, nil)
+	p.start(s, interval)
+	s.start()
+	return nil
+}
+
+// poller detects changes of a file tree by diffing its snapshots.
+type poller struct {
+	root string
+	max  int // levels of subdirectories to descend into, -1 for no limit
+	eset Event
+	snap map[string]os.FileInfo
+	stop chan struct{} // closed to stop polling ahead of the subscription
+}
+
+// newPoller creates a poller of root and takes its initial snapshot.
+func newPoller(root string, max int, eset Event) *poller {
+	p := &poller{
+		root: root,
+		max:  max,
+		eset: eset,
+	}
+	p.snap = p.scan()
+	return p
+}
+
+// start runs the polling goroutine of s.
+func (p *poller) start(s *subscription, interval time.Duration) {
+	s.wg.Add(1)
+	go p.run(s, interval)
+}
+
+// run polls the file tree every interval and feeds the detected events to s
+// until it is stopped.
+func (p *poller) run(s *subscription, interval time.Duration) {
+	defer s.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.done:
+			return
+		case <-p.stop:
+			return
+		}
+		snap := p.scan()
+		for _, ei := range p.diff(p.snap, snap) {
+			select {
+			case s.in <- ei:
+			case <-s.done:
+				return
+			case <-p.stop:
+				return
+			}
+		}
+		p.snap = snap
+	}
+}
+
+// scan takes a snapshot of the watched path. If it is a directory, the
+// snapshot contains its content, without the directory itself.
+func (p *poller) scan() map[string]os.FileInfo {
+	snap := make(map[string]os.FileInfo)
+	fi, err := os.Lstat(p.root)
+	if err != nil {
+		return snap
+	}
+	if !fi.IsDir() {
+		snap[p.root] = fi
+		return snap
+	}
+	p.walk(p.root, 0, snap)
+	return snap
+}
+
+func (p *poller) walk(dir string, level int, snap map[string]os.FileInfo) {
+	de, err := os.ReadDir(dir)
+	if err != nil {
+		dbgprintf("poll %q failed: %v", dir, err)
+		return
+	}
+	for _, de := range de {
+		name := filepath.Join(dir, de.Name())
+		isdir := de.Type()&(fs.ModeSymlink|fs.ModeDir) == fs.ModeDir
+		if isdir && (p.max < 0 || level < p.max) && !shouldPrune(name) {
+			p.walk(name, level+1, snap)
+		}
+		if shouldIgnoreKind(name, direntKind(de)) {
+			continue
+		}
+		if fi, err := de.Info(); err == nil {
+			snap[name] = fi
+		}
+	}
+}
+
+// diff gives events describing the changes between the prev and next snapshots.
+// Removals are reported first, children before their parents, then creations
+// and writes, parents before their children.
+func (p *poller) diff(prev, next map[string]os.FileInfo) (ev []EventInfo) {
+	var removed, changed []string
+	for path, fi := range prev {
+		if nfi, ok := next[path]; !ok || nfi.Mode().Type() != fi.Mode().Type() {
+			removed = append(removed, path)
+		}
+	}
+	for path, fi := range next {
+		ofi, ok := prev[path]
+		if !ok || ofi.Mode().Type() != fi.Mode().Type() ||
+			!fi.IsDir() && (ofi.Size() != fi.Size() || !ofi.ModTime().Equal(fi.ModTime())) {
+			changed = append(changed, path)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(removed)))
+	sort.Strings(changed)
+	for _, path := range removed {
+		ev = p.appendEvent(ev, path, Remove, prev[path])
+	}
+	for _, path := range changed {
+		e := Write
+		if ofi, ok := prev[path]; !ok || ofi.Mode().Type() != next[path].Mode().Type() {
+			e = Create
+		}
+		ev = p.appendEvent(ev, path, e, next[path])
+	}
+	return ev
+}
+
+// appendEvent appends an event for path to ev, unless it is not requested or
+// it is ignored for the event type, see AddPatternForEvents.
+func (p *poller) appendEvent(ev []EventInfo, path string, e Event, fi os.FileInfo) []EventInfo {
+	if p.eset&e == 0 {
+		return ev
+	}
+	kind := kindFile
+	if fi.IsDir() {
+		kind = kindDir
+	}
+	if shouldIgnoreKindEvent(path, kind, e) {
+		return ev
+	}
+	return append(ev, &pollEvent{path: path, event: e, fi: fi, time: time.Now()})
+}
+
+// pollEvent is an EventInfo produced by the polling watcher.
+type pollEvent struct {
+	path  string
+	event Event
+	fi    os.FileInfo
+	time  time.Time
+	scan  bool // whether it comes from the initial scan, see WithInitialScan
+}
+
+var _ isDirer = (*pollEvent)(nil)
+var _ DirInfo = (*pollEvent)(nil)
+var _ StatInfo = (*pollEvent)(nil)
+var _ Timestamp = (*pollEvent)(nil)
+
+func (e *pollEvent) Event() Event         { return e.event }
+func (e *pollEvent) Path() string         { return e.path }
+func (e *pollEvent) Sys() interface{}     { return e.fi }
+func (e *pollEvent) isDir() (bool, error) { return e.fi.IsDir(), nil }
+
+// IsDir implements DirInfo interface.
+func (e *pollEvent) IsDir() bool { return e.fi.IsDir() }
+
+// Time implements Timestamp interface.
+func (e *pollEvent) Time() time.Time { return e.time }
+
+// FileInfo implements StatInfo interface.
+func (e *pollEvent) FileInfo() (os.FileInfo, error) {
+	if e.event == Remove {
+		return nil, &os.PathError{Op: "lstat", Path: e.path, Err: os.ErrNotExist}
+	}
+	return e.fi, nil
+}
+
+// String implements fmt.Stringer interface.
+func (e *pollEvent) String() string {
+	return e.event.String() + `: "` + e.path + `"`
+}
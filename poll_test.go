@@ -0,0 +1,52 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchPoll(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "dir"), 0755))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "file"), nil, 0666))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchPoll(filepath.Join(tmpDir, "..."), c, 20*time.Millisecond, All))
+	defer Stop(c)
+
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "dir", "new"), nil, 0666))
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "file"), []byte("data"), 0666))
+	mustT(t, os.Remove(filepath.Join(tmpDir, "dir", "new")))
+	mustT(t, os.RemoveAll(filepath.Join(tmpDir, "dir")))
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "sub"), 0755))
+
+	want := map[string]Event{
+		filepath.Join(tmpDir, "dir"):  Remove,
+		filepath.Join(tmpDir, "file"): Write,
+		filepath.Join(tmpDir, "sub"):  Create,
+	}
+	got := make(map[string]Event)
+	for _, ei := range collect(c, 200*time.Millisecond) {
+		if _, ok := ei.(StatInfo); !ok {
+			t.Errorf("%v does not implement StatInfo", ei)
+		}
+		got[ei.Path()] |= ei.Event()
+	}
+	for path, e := range want {
+		if got[path] != e {
+			t.Errorf("want %v for %s, got %v", e, path, got)
+		}
+	}
+
+	Stop(c)
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "file"), nil, 0666))
+	if ev := collect(c, 100*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events after Stop, got %v", ev)
+	}
+}
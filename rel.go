@@ -0,0 +1,126 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+var errNotWithinRoot = errors.New("notify: path is not within the watched root")
+
+// relEvent is an EventInfo which implements RelInfo for the root of the
+// watchpoint it was delivered for.
+type relEvent struct {
+	linkEvent
+	root string
+}
+
+var _ RelInfo = (*relEvent)(nil)
+
+// Rel implements RelInfo interface.
+func (e *relEvent) Rel() (string, error) {
+	return relpath(e.root, e.path)
+}
+
+// relRenamedEvent is a relEvent which implements RenamedInfo.
+type relRenamedEvent struct {
+	linkRenamedEvent
+	root string
+}
+
+var _ RelInfo = (*relRenamedEvent)(nil)
+
+// Rel implements RelInfo interface.
+func (e *relRenamedEvent) Rel() (string, error) {
+	return relpath(e.root, e.path)
+}
+
+// relpath gives path relative to root, slash-separated. It fails if path
+// does not lie within root.
+func relpath(root, path string) (string, error) {
+	if path == "" {
+		return "", errNotWithinRoot
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", errNotWithinRoot
+	}
+	return rel, nil
+}
+
+// unwrap gives the event reported by the watcher, which the stages may have
+// wrapped with relEvent or unifiedEvent.
+func unwrap(ei EventInfo) EventInfo {
+	switch e := ei.(type) {
+	case *relEvent:
+		return e.EventInfo
+	case *relRenamedEvent:
+		return e.EventInfo
+	case *unifiedEvent:
+		return e.EventInfo
+	case *unifiedRelEvent:
+		return e.EventInfo
+	}
+	return ei
+}
+
+// rootStage drops events of the watched path itself, except for Remove and
+// Rename.
+func rootStage(path string) stage {
+	root := watchroot(path)
+	return func(_ *subscription, next sink) sink {
+		return func(ei EventInfo) {
+			if ei.Path() == root && ei.Event()&(Remove|Rename) == 0 {
+				return
+			}
+			next(ei)
+		}
+	}
+}
+
+// watchroot gives the absolute path of the watchpoint set up on path, under
+// which its events are reported.
+func watchroot(path string) string {
+	path = strings.TrimSuffix(path, "...")
+	if root, err := filepath.Abs(path); err == nil {
+		return root
+	}
+	return filepath.Clean(path)
+}
+
+// relStage makes events implement RelInfo for the root of the watchpoint set
+// up on path. The root is the path as given by the user, events are reported
+// under it by linkStage even if it is a symlink.
+func relStage(path string) stage {
+	root := watchroot(path)
+	return func(_ *subscription, next sink) sink {
+		return func(ei EventInfo) {
+			path := ei.Path()
+			if e, ok := ei.(RenamedInfo); ok {
+				if e, ok := e.(*linkRenamedEvent); ok {
+					ei = e.EventInfo
+				}
+				next(&relRenamedEvent{
+					linkRenamedEvent: linkRenamedEvent{
+						linkEvent: linkEvent{EventInfo: ei, path: path},
+						oldpath:   e.OldPath(),
+					},
+					root: root,
+				})
+				return
+			}
+			if e, ok := ei.(*linkEvent); ok {
+				ei = e.EventInfo
+			}
+			next(&relEvent{linkEvent: linkEvent{EventInfo: ei, path: path}, root: root})
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"os"
+	"sync"
+)
+
+var (
+	rescanMu    sync.Mutex // protects rescanSnaps
+	rescanSnaps = make(map[string]map[string]os.FileInfo)
+)
+
+// Rescan walks the given path and delivers synthetic Create, Remove and Write
+// events describing the changes of the files within it since the previous
+// Rescan of the same path. It is meant for recovering after events were lost,
+// e.g. after receiving Overflow. Like with Watch, a path ending with "..."
+// is rescanned recursively.
+//
+// The events are dispatched like the ones reported by the OS, to every
+// watchpoint covering the changed paths, so e.g. the directories created in
+// the meantime become watched by recursive watchpoints. Paths ignored by the
+// global ignore matcher are skipped.
+//
+// Notify does not keep track of the files themselves, so the first Rescan of
+// a path has nothing to compare them to and reports every file and directory
+// within the path as created. Consumers should treat Create of an already
+// known file as a possible change of it.
+//
+// Like the ones of WatchPoll, synthetic events implement DirInfo and StatInfo,
+// and their Sys returns an os.FileInfo value.
+//
+// Rescan fails if the path is not covered by any watchpoint.
+func Rescan(path string) error {
+	root, isrec, err := cleanpath(path)
+	if err != nil {
+		return err
+	}
+	if !isWatched(root) {
+		return &os.PathError{Op: "rescan", Path: path, Err: errNotWatched}
+	}
+	key, max := root, 0
+	if isrec {
+		key, max = root+string(os.PathSeparator)+"...", -1
+	}
+	p := newPoller(root, max, Create|Remove|Write)
+	rescanMu.Lock()
+	prev := rescanSnaps[key]
+	rescanSnaps[key] = p.snap
+	rescanMu.Unlock()
+	for _, ei := range p.diff(prev, p.snap) {
+		defaultTree.Inject(ei)
+	}
+	return nil
+}
+
+// isWatched reports whether path or any of its parent directories is watched.
+func isWatched(path string) bool {
+	for _, e := range defaultTree.List() {
+		if e.Path == path || indexrel(e.Path, path) != -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// resetRescans forgets the state recorded by Rescan.
+func resetRescans() {
+	rescanMu.Lock()
+	for key := range rescanSnaps {
+		delete(rescanSnaps, key)
+	}
+	rescanMu.Unlock()
+}
@@ -0,0 +1,771 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sink is a single step of the per-watch event pipeline.
+type sink func(EventInfo)
+
+// stage wraps the next step of a pipeline with additional processing. It may
+// register cleanup functions, which are called when the subscription stops,
+// with (*subscription).onStop.
+type stage func(s *subscription, next sink) sink
+
+// subscription is a watchpoint which events are processed by a pipeline of
+// stages before they are delivered to the user channel. It owns an internal
+// channel registered in the tree in place of the user one.
+type subscription struct {
+	c       chan<- EventInfo // user channel
+	in      chan EventInfo   // channel registered in the tree
+	head    sink
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+	mu      sync.Mutex // protects stop, drain, pause, resume and stopped
+	stop    []func()
+	drain   []func()
+	pause   []func()
+	resume  []func()
+	stopped bool
+}
+
+var (
+	subsMu sync.Mutex // protects subs
+	subs   = make(map[chan<- EventInfo][]*subscription)
+)
+
+// subscribe sets up a watchpoint on path which events go through the given
+// stages, in order, before they are sent to c.
+func subscribe(path string, c chan<- EventInfo, events []Event, stages ...stage) (*subscription, error) {
+	s := newSubscription(c, stages)
+	if err := watch(path, s.in, c, events); err != nil {
+		s.close()
+		return nil, err
+	}
+	s.start()
+	return s, nil
+}
+
+// newSubscription creates a subscription delivering events to c through
+// the given stages. Events are fed to the pipeline by sending them to s.in
+// once the subscription is started.
+func newSubscription(c chan<- EventInfo, stages []stage) *subscription {
+	s := &subscription{
+		c:    c,
+		in:   make(chan EventInfo, buffer),
+		done: make(chan struct{}),
+	}
+	s.head = s.deliver
+	for i := len(stages) - 1; i >= 0; i-- {
+		s.head = stages[i](s, s.head)
+	}
+	return s
+}
+
+// start runs the pump goroutine and registers the subscription for its user
+// channel, so it is stopped by Stop.
+func (s *subscription) start() {
+	s.wg.Add(1)
+	go s.pump()
+	subsMu.Lock()
+	subs[s.c] = append(subs[s.c], s)
+	subsMu.Unlock()
+}
+
+// unsubscribe stops all the subscriptions delivering events to c.
+func unsubscribe(c chan<- EventInfo) {
+	subsMu.Lock()
+	ss := subs[c]
+	delete(subs, c)
+	subsMu.Unlock()
+	for _, s := range ss {
+		s.shutdown()
+	}
+}
+
+// Stop removes the subscription's watchpoint and stops it. It does not affect
+// other subscriptions of the user channel.
+func (s *subscription) Stop() {
+	subsMu.Lock()
+	ss := subs[s.c]
+	for i := range ss {
+		if ss[i] == s {
+			ss = append(ss[:i:i], ss[i+1:]...)
+			break
+		}
+	}
+	if len(ss) == 0 {
+		delete(subs, s.c)
+	} else {
+		subs[s.c] = ss
+	}
+	subsMu.Unlock()
+	s.shutdown()
+}
+
+// shutdown waits for the pump goroutine to finish, removes the internal
+// channel from the tree and closes the subscription. The pump is stopped
+// first, so stages which add watchpoints cannot add them after the channel
+// was removed. It is safe to call it multiple times.
+func (s *subscription) shutdown() {
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		defaultTree.Stop(s.in)
+		s.close()
+	})
+}
+
+// pump feeds events received from the tree to the pipeline.
+func (s *subscription) pump() {
+	defer s.wg.Done()
+	for {
+		select {
+		case ei := <-s.in:
+			if f, ok := ei.(*flushEvent); ok {
+				s.mu.Lock()
+				drain := s.drain
+				s.mu.Unlock()
+				for _, fn := range drain {
+					fn()
+				}
+				close(f.done)
+				continue
+			}
+			if isPaused(s.c) {
+				continue
+			}
+			s.head(ei)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// deliver is the last step of each pipeline, it sends ei to the user channel.
+// Like the tree does, it drops the event if the receiver is too slow. It is
+// a nop after the subscription was stopped, so stages with their own timers
+// or goroutines cannot deliver events after Stop returns.
+func (s *subscription) deliver(ei EventInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	// Events of the initial scan are produced all at once, dropping them
+	// would make the scan useless.
+	if e, ok := unwrap(ei).(*pollEvent); ok && e.scan {
+		select {
+		case s.c <- ei:
+		case <-s.done:
+		}
+		return
+	}
+	select {
+	case s.c <- ei:
+	default:
+		dbgprintf("dropped %s on %q: receiver too slow", ei.Event(), ei.Path())
+	}
+}
+
+// flush waits until the events the subscription received before it was
+// called went through the pipeline, see onDrain. It returns early if the
+// subscription stops in the meantime.
+func (s *subscription) flush() {
+	f := &flushEvent{done: make(chan struct{})}
+	select {
+	case s.in <- f:
+	case <-s.done:
+		return
+	}
+	select {
+	case <-f.done:
+	case <-s.done:
+	}
+}
+
+// onDrain registers fn to be called by flush once the events received before
+// went through the pipeline. Stages which hold events back, to deliver them
+// from their own goroutines, use it to wait until they are delivered.
+func (s *subscription) onDrain(fn func()) {
+	s.mu.Lock()
+	s.drain = append(s.drain, fn)
+	s.mu.Unlock()
+}
+
+// onPause registers fn to be called when the user channel is paused.
+func (s *subscription) onPause(fn func()) {
+	s.mu.Lock()
+	s.pause = append(s.pause, fn)
+	s.mu.Unlock()
+}
+
+// onResume registers fn to be called when the user channel is resumed.
+func (s *subscription) onResume(fn func()) {
+	s.mu.Lock()
+	s.resume = append(s.resume, fn)
+	s.mu.Unlock()
+}
+
+// onStop registers fn to be called when the subscription stops.
+func (s *subscription) onStop(fn func()) {
+	s.mu.Lock()
+	s.stop = append(s.stop, fn)
+	s.mu.Unlock()
+}
+
+// close marks the subscription as stopped and calls the registered cleanup
+// functions.
+func (s *subscription) close() {
+	s.mu.Lock()
+	s.stopped = true
+	stop := s.stop
+	s.stop = nil
+	s.mu.Unlock()
+	for _, fn := range stop {
+		fn()
+	}
+}
+
+// ignoreStage drops events for paths ignored by im.
+func ignoreStage(im *IgnoreMatcher) stage {
+	return func(_ *subscription, next sink) sink {
+		return func(ei EventInfo) {
+			if ignoreEnabled() && im.shouldIgnore(eventPath(ei), eventKind(ei), ei.Event()) {
+				logf("ignored %v", ei)
+				return
+			}
+			next(ei)
+		}
+	}
+}
+
+// debounceStage delays each event by window and drops it if another event for
+// the same path arrives in the meantime, so only the last one of a burst is
+// delivered.
+func debounceStage(window time.Duration) stage {
+	return func(s *subscription, next sink) sink {
+		type pending struct {
+			ei EventInfo
+			t  *time.Timer
+		}
+		var (
+			mu sync.Mutex
+			m  = make(map[string]*pending)
+		)
+		s.onStop(func() {
+			mu.Lock()
+			for path, p := range m {
+				p.t.Stop()
+				delete(m, path)
+			}
+			mu.Unlock()
+		})
+		return func(ei EventInfo) {
+			path := eventPath(ei)
+			mu.Lock()
+			defer mu.Unlock()
+			if p, ok := m[path]; ok && p.t.Stop() {
+				p.ei = ei
+				p.t.Reset(window)
+				return
+			}
+			p := &pending{ei: ei}
+			p.t = time.AfterFunc(window, func() {
+				mu.Lock()
+				if m[path] == p {
+					delete(m, path)
+				}
+				ei := p.ei
+				mu.Unlock()
+				next(ei)
+			})
+			m[path] = p
+		}
+	}
+}
+
+// throttleStage delivers at most one event per interval for the whole
+// watchpoint: the first event starts the interval, and the last event which
+// arrived within it is delivered when it ends. Unlike debounceStage, further
+// events do not postpone the delivery.
+func throttleStage(interval time.Duration) stage {
+	return func(s *subscription, next sink) sink {
+		var (
+			mu      sync.Mutex
+			pending EventInfo
+			t       *time.Timer
+		)
+		s.onStop(func() {
+			mu.Lock()
+			if t != nil {
+				t.Stop()
+				t, pending = nil, nil
+			}
+			mu.Unlock()
+		})
+		return func(ei EventInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if pending != nil {
+				dbgprintf("dropped %v: throttled", pending)
+			}
+			pending = ei
+			if t != nil {
+				return
+			}
+			t = time.AfterFunc(interval, func() {
+				mu.Lock()
+				ei := pending
+				t, pending = nil, nil
+				mu.Unlock()
+				if ei != nil {
+					next(ei)
+				}
+			})
+		}
+	}
+}
+
+// coalesceStage collects events for each path for window since the first one
+// and delivers them collapsed. Arrival order of events dispatched by the tree
+// is not guaranteed, so the decision is made upon the whole collected set:
+// a Create together with Remove or Rename is dropped, a Create together with
+// Write is delivered as the last Write, otherwise all events are delivered in
+// the order they arrived.
+func coalesceStage(window time.Duration) stage {
+	return func(s *subscription, next sink) sink {
+		type pending struct {
+			ev  []EventInfo
+			set Event
+			t   *time.Timer
+		}
+		var (
+			mu sync.Mutex
+			m  = make(map[string]*pending)
+		)
+		s.onStop(func() {
+			mu.Lock()
+			for path, p := range m {
+				p.t.Stop()
+				delete(m, path)
+			}
+			mu.Unlock()
+		})
+		flush := func(path string, p *pending) {
+			mu.Lock()
+			if m[path] == p {
+				delete(m, path)
+			}
+			mu.Unlock()
+			switch {
+			case p.set&Create != 0 && p.set&(Remove|Rename) != 0:
+			case p.set&Create != 0 && p.set&Write != 0:
+				for i := len(p.ev) - 1; i >= 0; i-- {
+					if p.ev[i].Event() == Write {
+						next(p.ev[i])
+						break
+					}
+				}
+			default:
+				for _, ei := range p.ev {
+					next(ei)
+				}
+			}
+		}
+		return func(ei EventInfo) {
+			path := eventPath(ei)
+			mu.Lock()
+			defer mu.Unlock()
+			p, ok := m[path]
+			if !ok {
+				p = &pending{}
+				p.t = time.AfterFunc(window, func() { flush(path, p) })
+				m[path] = p
+			}
+			p.ev = append(p.ev, ei)
+			p.set |= ei.Event()
+		}
+	}
+}
+
+// dirCoalesceStage joins the events of the files within a directory, which
+// happened within window since the first of them, into a single dirEvent for
+// the directory.
+func dirCoalesceStage(window time.Duration) stage {
+	return func(s *subscription, next sink) sink {
+		type pending struct {
+			set Event
+			t   *time.Timer
+		}
+		var (
+			mu sync.Mutex
+			m  = make(map[string]*pending)
+		)
+		s.onStop(func() {
+			mu.Lock()
+			for dir, p := range m {
+				p.t.Stop()
+				delete(m, dir)
+			}
+			mu.Unlock()
+		})
+		flush := func(dir string, p *pending) {
+			mu.Lock()
+			if m[dir] == p {
+				delete(m, dir)
+			}
+			set := p.set
+			mu.Unlock()
+			next(&dirEvent{path: dir, event: set, time: time.Now()})
+		}
+		return func(ei EventInfo) {
+			if ei.Event() == Overflow {
+				next(ei)
+				return
+			}
+			dir := filepath.Dir(eventPath(ei))
+			mu.Lock()
+			defer mu.Unlock()
+			p, ok := m[dir]
+			if !ok {
+				p = &pending{}
+				p.t = time.AfterFunc(window, func() { flush(dir, p) })
+				m[dir] = p
+			}
+			p.set |= ei.Event()
+		}
+	}
+}
+
+// dirEvent is the event delivered by dirCoalesceStage for a directory, its
+// value joins the events of the files within it.
+type dirEvent struct {
+	path  string
+	event Event
+	time  time.Time
+}
+
+var _ isDirer = (*dirEvent)(nil)
+var _ DirInfo = (*dirEvent)(nil)
+var _ Timestamp = (*dirEvent)(nil)
+
+func (e *dirEvent) Event() Event         { return e.event }
+func (e *dirEvent) Path() string         { return e.path }
+func (e *dirEvent) Sys() interface{}     { return nil }
+func (e *dirEvent) isDir() (bool, error) { return true, nil }
+
+// IsDir implements DirInfo interface.
+func (e *dirEvent) IsDir() bool { return true }
+
+// Time implements Timestamp interface.
+func (e *dirEvent) Time() time.Time { return e.time }
+
+// String implements fmt.Stringer interface.
+func (e *dirEvent) String() string {
+	return e.event.String() + `: "` + e.path + `"`
+}
+
+// dedupStage drops an event if an event with the same path and value was
+// delivered less than window ago.
+func dedupStage(window time.Duration) stage {
+	return func(_ *subscription, next sink) sink {
+		type key struct {
+			path string
+			e    Event
+		}
+		var (
+			mu     sync.Mutex
+			m      = make(map[key]time.Time)
+			pruned time.Time
+		)
+		return func(ei EventInfo) {
+			now := time.Now()
+			k := key{eventPath(ei), ei.Event()}
+			mu.Lock()
+			if now.Sub(pruned) >= window {
+				for k, t := range m {
+					if now.Sub(t) >= window {
+						delete(m, k)
+					}
+				}
+				pruned = now
+			}
+			t, ok := m[k]
+			if ok && now.Sub(t) < window {
+				mu.Unlock()
+				return
+			}
+			m[k] = now
+			mu.Unlock()
+			next(ei)
+		}
+	}
+}
+
+// rateLimitStage limits events for each path to rate per second with a token
+// bucket, which holds a single token. Surplus events are dropped, except for
+// the most recent one, which is delivered once the next token is available,
+// so the last state of a path is never lost.
+func rateLimitStage(rate float64) stage {
+	return func(s *subscription, next sink) sink {
+		type bucket struct {
+			tokens  float64
+			last    time.Time
+			pending EventInfo
+			t       *time.Timer
+		}
+		var (
+			mu     sync.Mutex
+			m      = make(map[string]*bucket)
+			pruned time.Time
+		)
+		refill := func(b *bucket, now time.Time) {
+			b.tokens += now.Sub(b.last).Seconds() * rate
+			if b.tokens > 1 {
+				b.tokens = 1
+			}
+			b.last = now
+		}
+		s.onStop(func() {
+			mu.Lock()
+			for path, b := range m {
+				if b.t != nil {
+					b.t.Stop()
+				}
+				delete(m, path)
+			}
+			mu.Unlock()
+		})
+		return func(ei EventInfo) {
+			path, now := eventPath(ei), time.Now()
+			mu.Lock()
+			if now.Sub(pruned) >= time.Second {
+				for path, b := range m {
+					if b.pending == nil && now.Sub(b.last).Seconds()*rate >= 1 {
+						delete(m, path)
+					}
+				}
+				pruned = now
+			}
+			b, ok := m[path]
+			if !ok {
+				b = &bucket{tokens: 1, last: now}
+				m[path] = b
+			}
+			refill(b, now)
+			if b.pending == nil && b.tokens >= 1 {
+				b.tokens--
+				mu.Unlock()
+				next(ei)
+				return
+			}
+			defer mu.Unlock()
+			if b.pending != nil {
+				dbgprintf("dropped %v: rate limit exceeded", b.pending)
+			}
+			b.pending = ei
+			if b.t != nil {
+				return
+			}
+			wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+			b.t = time.AfterFunc(wait, func() {
+				mu.Lock()
+				if m[path] != b {
+					mu.Unlock()
+					return
+				}
+				refill(b, time.Now())
+				b.tokens--
+				ei := b.pending
+				b.pending, b.t = nil, nil
+				mu.Unlock()
+				next(ei)
+			})
+		}
+	}
+}
+
+// filterStage drops events for which pred returns false or panics.
+func filterStage(pred func(EventInfo) bool) stage {
+	return func(_ *subscription, next sink) sink {
+		return func(ei EventInfo) {
+			if safePred(pred, ei) {
+				next(ei)
+			}
+		}
+	}
+}
+
+// filesStage drops events of directories.
+func filesStage(_ *subscription, next sink) sink {
+	return func(ei EventInfo) {
+		if ei.Event() == Overflow || eventKind(ei) != kindDir {
+			next(ei)
+		}
+	}
+}
+
+// safePred calls pred recovering from a panic, in which case it returns false.
+func safePred(pred func(EventInfo) bool, ei EventInfo) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			dbgprintf("predicate panicked on %v: %v", ei, v)
+			ok = false
+		}
+	}()
+	return pred(ei)
+}
+
+// overflowEvent is the Overflow event delivered by bufferStage after it
+// dropped events.
+type overflowEvent struct {
+	time time.Time
+}
+
+var _ isDirer = (*overflowEvent)(nil)
+var _ DirInfo = (*overflowEvent)(nil)
+var _ StatInfo = (*overflowEvent)(nil)
+var _ Timestamp = (*overflowEvent)(nil)
+
+func (e *overflowEvent) Event() Event         { return Overflow }
+func (e *overflowEvent) Path() string         { return "" }
+func (e *overflowEvent) Sys() interface{}     { return nil }
+func (e *overflowEvent) isDir() (bool, error) { return false, nil }
+
+// IsDir implements DirInfo interface.
+func (e *overflowEvent) IsDir() bool { return false }
+
+// FileInfo implements StatInfo interface. It always fails, since the event
+// does not concern any file.
+func (e *overflowEvent) FileInfo() (os.FileInfo, error) {
+	return nil, &os.PathError{Op: "lstat", Path: "", Err: os.ErrNotExist}
+}
+
+// Time implements Timestamp interface.
+func (e *overflowEvent) Time() time.Time { return e.time }
+
+// String implements fmt.Stringer interface.
+func (e *overflowEvent) String() string { return Overflow.String() + `: ""` }
+
+// bufferStage queues up to n events for the user channel and sends them from
+// a separate goroutine, waiting for the receiver instead of dropping them.
+// When the queue is full, policy tells which event is dropped, or whether to
+// wait for the room in the queue. If report is true, an Overflow event is
+// delivered ahead of the queued events after any event was dropped.
+//
+// It must be the last stage of a pipeline, since it sends events to the user
+// channel itself rather than passing them to next.
+func bufferStage(n int, policy OverflowPolicy, report bool) stage {
+	return func(s *subscription, _ sink) sink {
+		var (
+			mu    sync.Mutex
+			q     []EventInfo
+			lost  bool
+			busy  bool // whether an event taken off q is being sent
+			ready = make(chan struct{}, 1)
+			room  = make(chan struct{}, 1)
+			sent  = make(chan struct{}, 1)
+			quit  = make(chan struct{})
+		)
+		signal := func(c chan struct{}) {
+			select {
+			case c <- struct{}{}:
+			default:
+			}
+		}
+		s.onStop(func() { close(quit) })
+		s.onDrain(func() {
+			for {
+				mu.Lock()
+				empty := len(q) == 0 && !lost && !busy
+				mu.Unlock()
+				if empty {
+					return
+				}
+				select {
+				case <-sent:
+				case <-s.done:
+					return
+				case <-quit:
+					return
+				}
+			}
+		})
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for {
+				var ei EventInfo
+				mu.Lock()
+				switch {
+				case lost:
+					ei, lost = &overflowEvent{time: time.Now()}, false
+				case len(q) != 0:
+					ei, q[0], q = q[0], nil, q[1:]
+					signal(room)
+				}
+				busy = ei != nil
+				mu.Unlock()
+				if ei == nil {
+					select {
+					case <-ready:
+						continue
+					case <-s.done:
+					case <-quit:
+					}
+					return
+				}
+				select {
+				case s.c <- ei:
+				case <-s.done:
+					return
+				case <-quit:
+					return
+				}
+				mu.Lock()
+				busy = false
+				mu.Unlock()
+				signal(sent)
+			}
+		}()
+		return func(ei EventInfo) {
+			// Events of the initial scan are never dropped, see deliver.
+			e, ok := unwrap(ei).(*pollEvent)
+			wait := policy == OverflowBlock || (ok && e.scan)
+			mu.Lock()
+			for wait && len(q) >= n {
+				mu.Unlock()
+				select {
+				case <-room:
+				case <-s.done:
+					return
+				case <-quit:
+					return
+				}
+				mu.Lock()
+			}
+			switch {
+			case len(q) < n:
+				q = append(q, ei)
+			case policy == OverflowDropOldest:
+				dbgprintf("dropped %v: buffer full", q[0])
+				q = append(q[1:], ei)
+				lost = lost || report
+			default:
+				dbgprintf("dropped %v: buffer full", ei)
+				lost = lost || report
+			}
+			mu.Unlock()
+			signal(ready)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedupStage(t *testing.T) {
+	c := make(chan EventInfo, 10)
+	s := newSubscription(c, []stage{dedupStage(50 * time.Millisecond)})
+	defer s.close()
+
+	write := &Call{P: "/file", E: Write}
+	s.head(write)
+	time.Sleep(time.Millisecond)
+	s.head(write)
+	s.head(&Call{P: "/file", E: Create})
+	s.head(&Call{P: "/other", E: Write})
+	time.Sleep(60 * time.Millisecond)
+	s.head(write)
+
+	want := []EventInfo{
+		write,
+		&Call{P: "/file", E: Create},
+		&Call{P: "/other", E: Write},
+		write,
+	}
+	ev := collect(c, 10*time.Millisecond)
+	if len(ev) != len(want) {
+		t.Fatalf("want %v, got %v", want, ev)
+	}
+	for i := range want {
+		if ev[i].Path() != want[i].Path() || ev[i].Event() != want[i].Event() {
+			t.Errorf("want %v, got %v (i=%d)", want[i], ev[i], i)
+		}
+	}
+}
+
+func TestRateLimitStage(t *testing.T) {
+	c := make(chan EventInfo, 10)
+	s := newSubscription(c, []stage{rateLimitStage(10)})
+	defer s.close()
+
+	for i := 0; i < 4; i++ {
+		s.head(&Call{P: "/hot", E: Write})
+	}
+	s.head(&Call{P: "/hot", E: Remove})
+	s.head(&Call{P: "/cold", E: Write})
+
+	ev := collect(c, 50*time.Millisecond)
+	if len(ev) != 2 || ev[0].Path() != "/hot" || ev[1].Path() != "/cold" {
+		t.Fatalf("want first events of both paths, got %v", ev)
+	}
+	// Only the most recent of the surplus events is delivered later.
+	ev = collect(c, 150*time.Millisecond)
+	if len(ev) != 1 || ev[0].Path() != "/hot" || ev[0].Event() != Remove {
+		t.Fatalf("want the last event of /hot, got %v", ev)
+	}
+}
+
+func TestThrottleStage(t *testing.T) {
+	c := make(chan EventInfo, 10)
+	s := newSubscription(c, []stage{throttleStage(50 * time.Millisecond)})
+	defer s.close()
+
+	// Events keep coming more often than the interval, yet one is delivered
+	// every interval.
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		s.head(&Call{P: "/a", E: Write})
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.head(&Call{P: "/b", E: Remove})
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Skipf("too slow to check the cadence, took %v", elapsed)
+	}
+	ev := collect(c, 100*time.Millisecond)
+	if len(ev) < 2 || len(ev) > 4 {
+		t.Fatalf("want an event per interval, got %v", ev)
+	}
+	if last := ev[len(ev)-1]; last.Path() != "/b" {
+		t.Fatalf("want the last event delivered, got %v", ev)
+	}
+}
+
+func eventPaths(ev []EventInfo) string {
+	var s []string
+	for _, ei := range ev {
+		s = append(s, ei.Path())
+	}
+	return strings.Join(s, ",")
+}
+
+func TestBufferStageDropOldest(t *testing.T) {
+	c := make(chan EventInfo)
+	s := newSubscription(c, []stage{bufferStage(2, OverflowDropOldest, true)})
+	defer s.close()
+	for _, p := range []string{"/1", "/2", "/3", "/4"} {
+		s.head(&Call{P: p, E: Write})
+	}
+	ev := collect(c, 50*time.Millisecond)
+	// The first event may have been taken off the buffer already.
+	if p := eventPaths(ev); !strings.HasSuffix(p, "/3,/4") || strings.Contains(p, "/2") {
+		t.Fatalf("want the newest events delivered, got %v", ev)
+	}
+	overflow := false
+	for _, ei := range ev {
+		overflow = overflow || ei.Event() == Overflow
+	}
+	if !overflow {
+		t.Fatalf("want Overflow delivered, got %v", ev)
+	}
+}
+
+func TestBufferStageDropNewest(t *testing.T) {
+	c := make(chan EventInfo)
+	s := newSubscription(c, []stage{bufferStage(1, OverflowDropNewest, false)})
+	defer s.close()
+	for _, p := range []string{"/1", "/2", "/3"} {
+		s.head(&Call{P: p, E: Write})
+	}
+	ev := collect(c, 50*time.Millisecond)
+	if p := eventPaths(ev); p != "/1" && p != "/1,/2" {
+		t.Fatalf("want the oldest events delivered, got %v", ev)
+	}
+}
+
+func TestBufferStageBlock(t *testing.T) {
+	c := make(chan EventInfo)
+	s := newSubscription(c, []stage{bufferStage(1, OverflowBlock, true)})
+	defer s.close()
+	go func() {
+		for _, p := range []string{"/1", "/2", "/3"} {
+			s.head(&Call{P: p, E: Write})
+		}
+	}()
+	ev := collect(c, 50*time.Millisecond)
+	if p := eventPaths(ev); p != "/1,/2,/3" {
+		t.Fatalf("want all events delivered, got %v", ev)
+	}
+}
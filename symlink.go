@@ -0,0 +1,131 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// linkEvent is an EventInfo reported under a path other than the one the
+// underlying watcher reported it for, like a path within a symlink to the
+// watched directory.
+type linkEvent struct {
+	EventInfo
+	path string
+}
+
+var _ isDirer = (*linkEvent)(nil)
+var _ DirInfo = (*linkEvent)(nil)
+var _ StatInfo = (*linkEvent)(nil)
+var _ Timestamp = (*linkEvent)(nil)
+
+func (e *linkEvent) Path() string { return e.path }
+
+func (e *linkEvent) isDir() (bool, error) {
+	if d, ok := e.EventInfo.(isDirer); ok {
+		return d.isDir()
+	}
+	return false, &os.PathError{Op: "isdir", Path: e.path, Err: os.ErrInvalid}
+}
+
+// IsDir implements DirInfo interface.
+func (e *linkEvent) IsDir() bool {
+	ok, err := e.isDir()
+	return ok && err == nil
+}
+
+// FileInfo implements StatInfo interface.
+func (e *linkEvent) FileInfo() (os.FileInfo, error) {
+	if s, ok := e.EventInfo.(StatInfo); ok {
+		return s.FileInfo()
+	}
+	return os.Lstat(e.path)
+}
+
+// Time implements Timestamp interface.
+func (e *linkEvent) Time() time.Time {
+	if t, ok := e.EventInfo.(Timestamp); ok {
+		return t.Time()
+	}
+	return time.Time{}
+}
+
+// String implements fmt.Stringer interface.
+func (e *linkEvent) String() string {
+	return e.Event().String() + `: "` + e.path + `"`
+}
+
+// linkRenamedEvent is a linkEvent which implements RenamedInfo.
+type linkRenamedEvent struct {
+	linkEvent
+	oldpath string
+}
+
+var _ RenamedInfo = (*linkRenamedEvent)(nil)
+
+// OldPath implements RenamedInfo interface.
+func (e *linkRenamedEvent) OldPath() string { return e.oldpath }
+
+// relink gives path, which lies within from, moved to within to. If path is
+// not within from, it is returned unchanged.
+func relink(path, from, to string) (string, bool) {
+	if path == from {
+		return to, true
+	}
+	if i := indexrel(from, path); i != -1 {
+		return filepath.Join(to, path[i:]), true
+	}
+	return path, false
+}
+
+// rebase gives ei reported for paths within from as if it was reported for
+// paths within to.
+func rebase(ei EventInfo, from, to string) EventInfo {
+	if _, ok := relink(eventPath(ei), from, to); !ok {
+		return ei
+	}
+	path, _ := relink(ei.Path(), from, to)
+	switch e := ei.(type) {
+	case *pollEvent:
+		pe := *e
+		pe.path = path
+		return &pe
+	case *linkEvent:
+		return &linkEvent{EventInfo: e.EventInfo, path: path}
+	case RenamedInfo:
+		oldpath, _ := relink(e.OldPath(), from, to)
+		if e, ok := e.(*linkRenamedEvent); ok {
+			ei = e.EventInfo
+		}
+		return &linkRenamedEvent{
+			linkEvent: linkEvent{EventInfo: ei, path: path},
+			oldpath:   oldpath,
+		}
+	}
+	return &linkEvent{EventInfo: ei, path: path}
+}
+
+// linkStage reports events under path as given by the user, if it differs
+// from the real path which is watched, since it is, or lies within, a symlink
+// to a directory. It gives nil if the paths do not differ.
+func linkStage(path string) stage {
+	path = strings.TrimSuffix(path, "...")
+	link, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	real, err := canonical(link)
+	if err != nil || real == link {
+		return nil
+	}
+	return func(_ *subscription, next sink) sink {
+		return func(ei EventInfo) {
+			next(rebase(ei, real, link))
+		}
+	}
+}
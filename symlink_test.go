@@ -0,0 +1,128 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+//go:build darwin || linux || freebsd || dragonfly || netbsd || openbsd || solaris
+// +build darwin linux freebsd dragonfly netbsd openbsd solaris
+
+package notify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchSymlinkRoot(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	target := filepath.Join(tmpDir, "target")
+	link := filepath.Join(tmpDir, "link")
+	mustT(t, os.Mkdir(target, 0755))
+	mustT(t, os.Symlink(target, link))
+
+	im := NewIgnoreMatcher(link)
+	mustT(t, im.AddPattern("*.tmp"))
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchWithIgnore(link, c, im, Create))
+	defer Stop(c)
+
+	mustT(t, os.WriteFile(filepath.Join(target, "file.tmp"), nil, 0666))
+	mustT(t, os.WriteFile(filepath.Join(target, "file"), nil, 0666))
+	ev := collect(c, 200*time.Millisecond)
+	if len(ev) != 1 {
+		t.Fatalf("want single event, got %v", ev)
+	}
+	if want := filepath.Join(link, "file"); ev[0].Path() != want {
+		t.Fatalf("want event for %q, got %v", want, ev[0])
+	}
+	if ev[0].(DirInfo).IsDir() {
+		t.Fatalf("want event for a file, got %v", ev[0])
+	}
+	if _, err := ev[0].(StatInfo).FileInfo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchFollowSymlinks(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	root := filepath.Join(tmpDir, "root")
+	target := filepath.Join(tmpDir, "target")
+	mustT(t, os.Mkdir(root, 0755))
+	mustT(t, os.Mkdir(target, 0755))
+	mustT(t, os.Symlink(target, filepath.Join(root, "link")))
+
+	c := make(chan EventInfo, 10)
+	mustT(t, Watch(filepath.Join(root, "..."), c, Create))
+	defer Stop(c)
+	cf := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(filepath.Join(root, "..."), cf, WithEvents(Create), WithFollowSymlinks()))
+	defer Stop(cf)
+
+	mustT(t, os.WriteFile(filepath.Join(target, "file"), nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 0 {
+		t.Fatalf("want no events without following symlinks, got %v", ev)
+	}
+	ev := collect(cf, 200*time.Millisecond)
+	if want := filepath.Join(root, "link", "file"); len(ev) != 1 || ev[0].Path() != want {
+		t.Fatalf("want single event for %q, got %v", want, ev)
+	}
+
+	// Symlinks created later are followed as well.
+	mustT(t, os.Mkdir(filepath.Join(target, "dir"), 0755))
+	mustT(t, os.Symlink(filepath.Join(target, "dir"), filepath.Join(root, "later")))
+	collect(cf, 200*time.Millisecond)
+	mustT(t, os.WriteFile(filepath.Join(target, "dir", "file"), nil, 0666))
+	ev = collect(cf, 200*time.Millisecond)
+	found := false
+	for _, ei := range ev {
+		if ei.Path() == filepath.Join(root, "later", "file") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("want event for file within the later symlink, got %v", ev)
+	}
+}
+
+func TestWatchFollowSymlinksCycle(t *testing.T) {
+	tmpDir, err := canonical(t.TempDir())
+	mustT(t, err)
+	mustT(t, os.Mkdir(filepath.Join(tmpDir, "a"), 0755))
+	mustT(t, os.Symlink(tmpDir, filepath.Join(tmpDir, "a", "loop")))
+	mustT(t, os.Symlink(filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "dup")))
+
+	var mu sync.Mutex
+	var logs []string
+	SetLogger(func(format string, args ...interface{}) {
+		mu.Lock()
+		logs = append(logs, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	})
+	defer SetLogger(nil)
+
+	c := make(chan EventInfo, 10)
+	mustT(t, WatchOpts(filepath.Join(tmpDir, "..."), c, WithEvents(Create), WithFollowSymlinks()))
+	defer Stop(c)
+
+	mu.Lock()
+	var skipped []string
+	for _, s := range logs {
+		if strings.Contains(s, "cycle") {
+			skipped = append(skipped, s)
+		}
+	}
+	mu.Unlock()
+	if len(skipped) != 2 {
+		t.Fatalf("want the loop and the duplicate skipped, got %v", skipped)
+	}
+	mustT(t, os.WriteFile(filepath.Join(tmpDir, "a", "file"), nil, 0666))
+	if ev := collect(c, 200*time.Millisecond); len(ev) != 1 {
+		t.Fatalf("want single event, got %v", ev)
+	}
+}
@@ -4,11 +4,23 @@
 
 package notify
 
+import (
+	"os"
+	"sort"
+)
+
 const buffer = 128
 
 type tree interface {
 	Watch(string, chan<- EventInfo, ...Event) error
 	Stop(chan<- EventInfo)
+	Unwatch(string, chan<- EventInfo)
+	StopAll()
+	List() []WatchEntry
+	Stats() Stats
+	Verify([]chan<- EventInfo) error
+	Inject(EventInfo)
+	Flush()
 	Close() error
 }
 
@@ -20,3 +32,126 @@ func newTree() tree {
 	}
 	return newNonrecursiveTree(w, c, make(chan EventInfo, buffer))
 }
+
+// flushEvent is queued for dispatching by Flush. It marks the point up to which
+// the events have to be dispatched, it is not delivered to any channel.
+type flushEvent struct {
+	done chan struct{}
+}
+
+func (*flushEvent) Event() Event     { return 0 }
+func (*flushEvent) Path() string     { return "" }
+func (*flushEvent) Sys() interface{} { return nil }
+
+// flush queues a flushEvent to c and waits until it is dispatched.
+func flush(c chan<- EventInfo) {
+	f := &flushEvent{done: make(chan struct{})}
+	c <- f
+	<-f.done
+}
+
+// watchEntries returns entries for nd and all its descendants which are being
+// watched, sorted by path.
+func watchEntries(nd node) []WatchEntry {
+	var entries []WatchEntry
+	nd.Walk(func(nd node) error {
+		if e := nd.Watch.Total(); e != 0 {
+			entries = append(entries, WatchEntry{
+				Path:      nd.Name,
+				Events:    e,
+				Recursive: nd.Watch.IsRecursive() || isManaged(nd.Watch),
+			})
+		}
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// watchedPaths returns the paths of nd and its descendants which are watched
+// for any of chans, sorted. The paths watched below a recursive watchpoint of
+// any of chans are included as well.
+func watchedPaths(nd node, chans []chan<- EventInfo) []string {
+	set := make(map[string]struct{})
+	nd.Walk(func(nd node) error {
+		for _, wp := range []watchpoint{nd.Watch, nd.Child[""].Watch} {
+			for _, c := range chans {
+				e, ok := wp[c]
+				if !ok {
+					continue
+				}
+				set[nd.Name] = struct{}{}
+				if e&recursive != 0 {
+					nd.Walk(func(nd node) error {
+						if nd.Watch.Total() != 0 {
+							set[nd.Name] = struct{}{}
+						}
+						return nil
+					})
+				}
+			}
+		}
+		return nil
+	})
+	paths := make([]string, 0, len(set))
+	for path := range set {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// verify checks whether the watches of paths held by w are still valid, see
+// VerifyWatch. Unless w can tell it, a watch is valid if its path exists.
+func verify(w interface{}, paths []string) error {
+	var stale []string
+	for _, path := range paths {
+		if v, ok := w.(watchVerifier); ok {
+			if !v.verifyWatch(path) {
+				stale = append(stale, path)
+			}
+		} else if _, err := os.Stat(path); err != nil {
+			stale = append(stale, path)
+		}
+	}
+	if len(stale) != 0 {
+		return &StaleWatchError{Paths: stale}
+	}
+	return nil
+}
+
+// broadcast sends ei to all the channels, dropping it for the ones which are
+// not ready to receive or paused.
+func broadcast(chans []chan<- EventInfo, ei EventInfo) {
+	for _, c := range chans {
+		if isPaused(c) {
+			continue
+		}
+		select {
+		case c <- ei:
+		default:
+			dbgprintf("dropped %s on %q: receiver too slow", ei.Event(), ei.Path())
+		}
+	}
+}
+
+// watchedChans returns all the channels registered in the watchpoints of nd
+// and its descendants, including the inactive ones.
+func watchedChans(nd node) []chan<- EventInfo {
+	set := make(map[chan<- EventInfo]struct{})
+	nd.Walk(func(nd node) error {
+		for _, wp := range []watchpoint{nd.Watch, nd.Child[""].Watch} {
+			for c := range wp {
+				if c != nil {
+					set[c] = struct{}{}
+				}
+			}
+		}
+		return nil
+	})
+	chans := make([]chan<- EventInfo, 0, len(set))
+	for c := range set {
+		chans = append(chans, c)
+	}
+	return chans
+}
@@ -8,11 +8,22 @@ import "sync"
 
 // nonrecursiveTree TODO(rjeczalik)
 type nonrecursiveTree struct {
-	rw   sync.RWMutex // protects root
-	root root
-	w    watcher
-	c    chan EventInfo
-	rec  chan EventInfo
+	rw      sync.RWMutex   // protects root
+	wg      sync.WaitGroup // running dispatches
+	root    root
+	w       watcher
+	c       chan EventInfo
+	rec     chan EventInfo
+	heldMu  sync.Mutex // protects heldDir and heldEvs
+	heldDir string     // directory which content events are held back, see hold
+	heldEvs []heldEvent
+}
+
+// heldEvent is an event held back by hold, together with whether it is
+// ignored.
+type heldEvent struct {
+	ei      EventInfo
+	ignored bool
 }
 
 // newNonrecursiveTree TODO(rjeczalik)
@@ -34,94 +45,217 @@ func newNonrecursiveTree(w watcher, c, rec chan EventInfo) *nonrecursiveTree {
 // dispatch TODO(rjeczalik)
 func (t *nonrecursiveTree) dispatch(c <-chan EventInfo) {
 	for ei := range c {
-		dbgprintf("dispatching %v on %q", ei.Event(), ei.Path())
-		// Check if this path should be ignored
-		if defaultIgnore != nil && defaultIgnore.ShouldIgnore(ei.Path()) {
+		if f, ok := ei.(*flushEvent); ok {
+			t.wg.Wait()
+			close(f.done)
 			continue
 		}
-		go func(ei EventInfo) {
-			var nd node
-			var isrec bool
-			dir, base := split(ei.Path())
-			fn := func(it node, isbase bool) error {
-				isrec = isrec || it.Watch.IsRecursive()
-				if isbase {
-					nd = it
-				} else {
-					it.Watch.Dispatch(ei, recursive)
-				}
-				return nil
-			}
+		dbgprintf("dispatching %v on %q", ei.Event(), ei.Path())
+		if ei.Event() == Overflow {
 			t.rw.RLock()
-			// Notify recursive watchpoints found on the path.
-			if err := t.root.WalkPath(dir, fn); err != nil {
-				dbgprint("dispatch did not reach leaf:", err)
-				t.rw.RUnlock()
-				return
-			}
-			// Notify parent watchpoint.
-			nd.Watch.Dispatch(ei, 0)
-			isrec = isrec || nd.Watch.IsRecursive()
-			// If leaf watchpoint exists, notify it.
-			if nd, ok := nd.Child[base]; ok {
-				isrec = isrec || nd.Watch.IsRecursive()
-				nd.Watch.Dispatch(ei, 0)
-			}
+			chans := watchedChans(t.root.nd)
 			t.rw.RUnlock()
-			// If the event describes newly leaf directory created within
-			if !isrec || ei.Event()&(Create|Remove) == 0 {
-				return
+			for i := range chans {
+				if chans[i] == t.rec {
+					chans = append(chans[:i], chans[i+1:]...)
+					break
+				}
 			}
-			if ok, err := ei.(isDirer).isDir(); !ok || err != nil {
+			broadcast(chans, ei)
+			continue
+		}
+		// Check if this path should be ignored. Ignored directories which are
+		// not pruned, like ones ignored for some events only, still need to be
+		// watched by recursive watchpoints.
+		ignored := shouldIgnoreEvent(ei)
+		if ignored {
+			logf("ignored %v", ei)
+		}
+		if ignored && (ei.Event()&(Create|Remove) == 0 || eventKind(ei) != kindDir || shouldPrune(eventPath(ei))) {
+			continue
+		}
+		t.wg.Add(1)
+		go func(ei EventInfo) {
+			defer t.wg.Done()
+			if t.held(ei, ignored) {
 				return
 			}
-			t.rec <- ei
+			if t.deliver(ei, ignored) {
+				t.rec <- ei
+			}
 		}(ei)
 	}
 }
 
+// deliver dispatches ei to the watchpoints of its path, unless it is ignored.
+// It reports whether ei describes a directory created or removed within
+// a recursive watchpoint, which needs to be passed to internal.
+func (t *nonrecursiveTree) deliver(ei EventInfo, ignored bool) bool {
+	var nd node
+	var isrec bool
+	dir, base := split(eventPath(ei))
+	fn := func(it node, isbase bool) error {
+		isrec = isrec || it.Watch.IsRecursive()
+		if isbase {
+			nd = it
+		} else if !ignored {
+			it.Watch.Dispatch(ei, recursive)
+		}
+		return nil
+	}
+	t.rw.RLock()
+	// Notify recursive watchpoints found on the path.
+	if err := t.root.WalkPath(dir, fn); err != nil {
+		dbgprint("dispatch did not reach leaf:", err)
+		t.rw.RUnlock()
+		return false
+	}
+	// Notify parent watchpoint.
+	if !ignored {
+		nd.Watch.Dispatch(ei, 0)
+	}
+	isrec = isrec || nd.Watch.IsRecursive()
+	// If leaf watchpoint exists, notify it. Its recursive watchpoints are
+	// kept when the path itself is removed, so they are set up again if it
+	// is recreated.
+	if nd, ok := nd.Child[base]; ok {
+		isrec = isrec || nd.Watch.IsRecursive() && ei.Event()&Remove == 0
+		if !ignored {
+			nd.Watch.Dispatch(ei, 0)
+		}
+	}
+	t.rw.RUnlock()
+	// If the event describes newly leaf directory created within
+	if !isrec || ei.Event()&(Create|Remove) == 0 {
+		return false
+	}
+	ok, err := ei.(isDirer).isDir()
+	return ok && err == nil
+}
+
 // internal TODO(rjeczalik)
 func (t *nonrecursiveTree) internal(rec <-chan EventInfo) {
 	for ei := range rec {
-		t.rw.Lock()
-		if ei.Event() == Remove {
-			nd, err := t.root.Get(ei.Path())
-			if err != nil {
-				t.rw.Unlock()
-				continue
-			}
-			t.walkWatchpoint(nd, func(_ Event, nd node) error {
-				t.w.Unwatch(nd.Name)
-				return nil
-			})
-			t.root.Del(ei.Path())
+		for queue := []EventInfo{ei}; len(queue) != 0; {
+			ei, queue = queue[0], queue[1:]
+			queue = append(queue, t.internalEvent(ei)...)
+		}
+	}
+}
+
+// internalEvent sets up or removes the watches for the directory created or
+// removed within a recursive watchpoint. It returns the events of
+// directories within the created one, which were held back while it was
+// scanned and need to be handled next.
+func (t *nonrecursiveTree) internalEvent(ei EventInfo) []EventInfo {
+	t.rw.Lock()
+	if ei.Event() == Remove {
+		nd, err := t.root.Get(ei.Path())
+		if err != nil {
 			t.rw.Unlock()
-			continue
+			return nil
 		}
-		var nd node
-		var eset = internal
-		t.root.WalkPath(ei.Path(), func(it node, _ bool) error {
-			if e := it.Watch[t.rec]; e != 0 && e > eset {
-				eset = e
-			}
-			nd = it
+		t.walkWatchpoint(nd, 0, func(_ Event, nd node) error {
+			t.w.Unwatch(nd.Name)
 			return nil
 		})
-		if eset == internal {
-			t.rw.Unlock()
-			continue
-		}
-		if ei.Path() != nd.Name {
-			nd = nd.Add(ei.Path())
+		t.root.Del(ei.Path())
+		t.rw.Unlock()
+		return nil
+	}
+	var nd node
+	var eset = internal
+	t.root.WalkPath(ei.Path(), func(it node, _ bool) error {
+		if e := it.Watch[t.rec]; e != 0 && e > eset {
+			eset = e
 		}
-		err := nd.AddDir(t.recFunc(eset))
+		nd = it
+		return nil
+	})
+	if eset == internal {
 		t.rw.Unlock()
-		if err != nil {
-			dbgprintf("internal(%p) error: %v", rec, err)
+		return nil
+	}
+	if ei.Path() != nd.Name {
+		nd = nd.Add(ei.Path())
+	}
+	// Synthetic events, like the ones of Rescan, come together with the
+	// events of the directory content already.
+	_, synthetic := ei.(*pollEvent)
+	if !synthetic {
+		t.hold(ei.Path())
+	}
+	err := nd.AddDir(t.recFunc(eset))
+	t.rw.Unlock()
+	if err != nil {
+		dbgprintf("internal(%p) error: %v", t.rec, err)
+		reportError(ei.Path(), err)
+	}
+	if synthetic {
+		return nil
+	}
+	if err == nil {
+		t.scanCreated(ei.Path())
+	}
+	return t.release()
+}
+
+// hold makes events of the paths within dir held back until release is
+// called, so the events of the directory content found by scanCreated are
+// delivered before the ones reported by the watches of its subdirectories,
+// and a directory is always reported before its content.
+func (t *nonrecursiveTree) hold(dir string) {
+	t.heldMu.Lock()
+	t.heldDir = dir
+	t.heldMu.Unlock()
+}
+
+// held holds ei back if it lies within the directory passed to hold, and
+// reports whether it did.
+func (t *nonrecursiveTree) held(ei EventInfo, ignored bool) bool {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
+	if t.heldDir == "" || indexrel(t.heldDir, eventPath(ei)) == -1 {
+		return false
+	}
+	t.heldEvs = append(t.heldEvs, heldEvent{ei: ei, ignored: ignored})
+	return true
+}
+
+// release delivers the events held back since hold was called, in order, and
+// stops holding them. It returns the ones which need to be handled by
+// internal.
+func (t *nonrecursiveTree) release() (rec []EventInfo) {
+	for {
+		t.heldMu.Lock()
+		evs := t.heldEvs
+		t.heldEvs = nil
+		if len(evs) == 0 {
+			t.heldDir = ""
+			t.heldMu.Unlock()
+			return rec
+		}
+		t.heldMu.Unlock()
+		for _, e := range evs {
+			if t.deliver(e.ei, e.ignored) {
+				rec = append(rec, e.ei)
+			}
 		}
 	}
 }
 
+// scanCreated reports Create events for the content of the directory created
+// within a recursive watchpoint. Files and directories created within it
+// before its watch was armed would not be reported otherwise, like the ones
+// of "mkdir -p a/b/c". A path created after the watch was armed, but before
+// the directory is walked, may be reported twice.
+func (t *nonrecursiveTree) scanCreated(dir string) {
+	p := newPoller(dir, -1, Create)
+	for _, ei := range p.diff(nil, p.snap) {
+		t.deliver(ei, false)
+	}
+}
+
 // watchAdd TODO(rjeczalik)
 func (t *nonrecursiveTree) watchAdd(nd node, c chan<- EventInfo, e Event) eventDiff {
 	if e&recursive != 0 {
@@ -255,12 +389,12 @@ func (t *nonrecursiveTree) watchrec(nd node, c chan<- EventInfo, e Event) error
 
 type walkWatchpointFunc func(Event, node) error
 
-func (t *nonrecursiveTree) walkWatchpoint(nd node, fn walkWatchpointFunc) error {
+func (t *nonrecursiveTree) walkWatchpoint(nd node, min Event, fn walkWatchpointFunc) error {
 	type minode struct {
 		min Event
 		nd  node
 	}
-	mnd := minode{nd: nd}
+	mnd := minode{min: min, nd: nd}
 	stack := []minode{mnd}
 Traverse:
 	for n := len(stack); n != 0; n = len(stack) {
@@ -286,6 +420,37 @@ Traverse:
 
 // Stop TODO(rjeczalik)
 func (t *nonrecursiveTree) Stop(c chan<- EventInfo) {
+	t.rw.Lock()
+	err := t.stop(t.root.nd, 0, c) // TODO(rjeczalik): store max root per c
+	t.rw.Unlock()
+	dbgprintf("Stop(%p) error: %v\n", c, err)
+}
+
+// Unwatch removes the watchpoints of c on path and on the paths below it,
+// leaving its other watchpoints intact. The path is expected in the form the
+// events are reported in, it is not resolved, since it may not exist anymore.
+func (t *nonrecursiveTree) Unwatch(path string, c chan<- EventInfo) {
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	nd, err := t.root.Get(path)
+	if err != nil {
+		return
+	}
+	// The internal watchpoints of the subtree are bounded by the ones of the
+	// parent, like when Stop walks down to it.
+	var min Event
+	t.root.WalkPath(path, func(it node, isbase bool) error {
+		if !isbase {
+			min = it.Watch[t.rec]
+		}
+		return nil
+	})
+	dbgprintf("Unwatch(%q, %p) error: %v\n", path, c, t.stop(nd, min, c))
+}
+
+// stop removes the watchpoints of c on nd and its descendants. It expects
+// t.rw to be locked.
+func (t *nonrecursiveTree) stop(nd node, min Event, c chan<- EventInfo) error {
 	fn := func(min Event, nd node) error {
 		// TODO(rjeczalik): aggregate watcher errors and retry; in worst case
 		// forward to the user.
@@ -299,10 +464,54 @@ func (t *nonrecursiveTree) Stop(c chan<- EventInfo) {
 		}
 		return nil
 	}
-	t.rw.Lock()
-	err := t.walkWatchpoint(t.root.nd, fn) // TODO(rjeczalik): store max root per c
-	t.rw.Unlock()
-	dbgprintf("Stop(%p) error: %v\n", c, err)
+	return t.walkWatchpoint(nd, min, fn)
+}
+
+// StopAll stops all the channels which have any watchpoints in the tree.
+func (t *nonrecursiveTree) StopAll() {
+	t.rw.RLock()
+	chans := watchedChans(t.root.nd)
+	t.rw.RUnlock()
+	for _, c := range chans {
+		if c != t.rec {
+			t.Stop(c)
+		}
+	}
+}
+
+// List returns a snapshot of the watched paths.
+func (t *nonrecursiveTree) List() []WatchEntry {
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	return watchEntries(t.root.nd)
+}
+
+// Stats gives the number of watches held by the watcher, if it can tell it,
+// or the number of watched paths otherwise.
+func (t *nonrecursiveTree) Stats() Stats {
+	if wc, ok := t.w.(watchCounter); ok {
+		return Stats{Watches: wc.watchCount()}
+	}
+	return Stats{Watches: len(t.List())}
+}
+
+// Verify checks the watches of the paths watched for chans.
+func (t *nonrecursiveTree) Verify(chans []chan<- EventInfo) error {
+	t.rw.RLock()
+	paths := watchedPaths(t.root.nd, chans)
+	t.rw.RUnlock()
+	return verify(t.w, paths)
+}
+
+// Inject dispatches ei as if it was reported by the watcher.
+func (t *nonrecursiveTree) Inject(ei EventInfo) {
+	t.c <- ei
+}
+
+// Flush waits until the events which were reported by the watcher before it
+// was called are dispatched.
+func (t *nonrecursiveTree) Flush() {
+	flush(t.c)
 }
 
 // Close TODO(rjeczalik)
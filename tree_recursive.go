@@ -96,7 +96,8 @@ func watchIsRecursive(nd node) bool {
 
 // recursiveTree TODO(rjeczalik)
 type recursiveTree struct {
-	rw   sync.RWMutex // protects root
+	rw   sync.RWMutex   // protects root
+	wg   sync.WaitGroup // running dispatches
 	root root
 	// TODO(rjeczalik): merge watcher + recursiveWatcher after #5 and #6
 	w interface {
@@ -123,14 +124,28 @@ func newRecursiveTree(w recursiveWatcher, c chan EventInfo) *recursiveTree {
 // dispatch TODO(rjeczalik)
 func (t *recursiveTree) dispatch() {
 	for ei := range t.c {
+		if f, ok := ei.(*flushEvent); ok {
+			t.wg.Wait()
+			close(f.done)
+			continue
+		}
 		dbgprintf("dispatching %v on %q", ei.Event(), ei.Path())
+		if ei.Event() == Overflow {
+			t.rw.RLock()
+			broadcast(watchedChans(t.root.nd), ei)
+			t.rw.RUnlock()
+			continue
+		}
 		// Check if this path should be ignored
-		if defaultIgnore != nil && defaultIgnore.ShouldIgnore(ei.Path()) {
+		if shouldIgnoreEvent(ei) {
+			logf("ignored %v", ei)
 			continue
 		}
+		t.wg.Add(1)
 		go func(ei EventInfo) {
+			defer t.wg.Done()
 			nd, ok := node{}, false
-			dir, base := split(ei.Path())
+			dir, base := split(eventPath(ei))
 			fn := func(it node, isbase bool) error {
 				if isbase {
 					nd = it
@@ -308,6 +323,25 @@ func (t *recursiveTree) Watch(path string, c chan<- EventInfo, events ...Event)
 // if parent is no longer needed. This carries a risk that underlying
 // watcher calls could fail - reconsider if it's worth the effort.
 func (t *recursiveTree) Stop(c chan<- EventInfo) {
+	t.rw.Lock()
+	err := t.stop("", c) // TODO(rjeczalik): use max root per c
+	t.rw.Unlock()
+	dbgprintf("Stop(%p) error: %v\n", c, err)
+}
+
+// Unwatch removes the watchpoints of c on path and on the paths below it,
+// leaving its other watchpoints intact. The path is expected in the form the
+// events are reported in, it is not resolved, since it may not exist anymore.
+func (t *recursiveTree) Unwatch(path string, c chan<- EventInfo) {
+	t.rw.Lock()
+	err := t.stop(path, c)
+	t.rw.Unlock()
+	dbgprintf("Unwatch(%q, %p) error: %v\n", path, c, err)
+}
+
+// stop removes the watchpoints of c on path and its descendants. It expects
+// t.rw to be locked.
+func (t *recursiveTree) stop(path string, c chan<- EventInfo) error {
 	var err error
 	fn := func(nd node) (e error) {
 		diff := watchDel(nd, c, all)
@@ -341,13 +375,51 @@ func (t *recursiveTree) Stop(c chan<- EventInfo) {
 		// vie Error event?
 		return errSkip
 	}
-	t.rw.Lock()
-	e := t.root.Walk("", fn) // TODO(rjeczalik): use max root per c
-	t.rw.Unlock()
-	if e != nil {
+	if e := t.root.Walk(path, fn); e != nil {
 		err = nonil(err, e)
 	}
-	dbgprintf("Stop(%p) error: %v\n", c, err)
+	return err
+}
+
+// StopAll stops all the channels which have any watchpoints in the tree.
+func (t *recursiveTree) StopAll() {
+	t.rw.RLock()
+	chans := watchedChans(t.root.nd)
+	t.rw.RUnlock()
+	for _, c := range chans {
+		t.Stop(c)
+	}
+}
+
+// List returns a snapshot of the watched paths.
+func (t *recursiveTree) List() []WatchEntry {
+	t.rw.RLock()
+	defer t.rw.RUnlock()
+	return watchEntries(t.root.nd)
+}
+
+// Stats gives the number of watched paths.
+func (t *recursiveTree) Stats() Stats {
+	return Stats{Watches: len(t.List())}
+}
+
+// Verify checks the watches of the paths watched for chans.
+func (t *recursiveTree) Verify(chans []chan<- EventInfo) error {
+	t.rw.RLock()
+	paths := watchedPaths(t.root.nd, chans)
+	t.rw.RUnlock()
+	return verify(t.w, paths)
+}
+
+// Inject dispatches ei as if it was reported by the watcher.
+func (t *recursiveTree) Inject(ei EventInfo) {
+	t.c <- ei
+}
+
+// Flush waits until the events which were reported by the watcher before it
+// was called are dispatched.
+func (t *recursiveTree) Flush() {
+	flush(t.c)
 }
 
 // Close TODO(rjeczalik)
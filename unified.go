@@ -0,0 +1,58 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+// unifiedEvent is an EventInfo delivered as Any, see WithUnifiedEvent.
+type unifiedEvent struct {
+	linkEvent
+}
+
+func (e *unifiedEvent) Event() Event { return Any }
+
+// String implements fmt.Stringer interface.
+func (e *unifiedEvent) String() string {
+	return Any.String() + `: "` + e.path + `"`
+}
+
+// unifiedRelEvent is a relEvent delivered as Any.
+type unifiedRelEvent struct {
+	relEvent
+}
+
+func (e *unifiedRelEvent) Event() Event { return Any }
+
+// String implements fmt.Stringer interface.
+func (e *unifiedRelEvent) String() string {
+	return Any.String() + `: "` + e.path + `"`
+}
+
+// unifiedStage delivers every event as Any, keeping its path and the
+// interfaces it implements, apart from RenamedInfo. A file moved out of the
+// watched directories is reported under its old path. Overflow events are
+// delivered as is.
+func unifiedStage(_ *subscription, next sink) sink {
+	return func(ei EventInfo) {
+		if ei.Event() == Overflow {
+			next(ei)
+			return
+		}
+		switch e := ei.(type) {
+		case *relEvent:
+			next(&unifiedRelEvent{*e})
+		case *relRenamedEvent:
+			le := e.linkEvent
+			le.path = eventPath(e)
+			next(&unifiedRelEvent{relEvent{linkEvent: le, root: e.root}})
+		case *linkEvent:
+			next(&unifiedEvent{*e})
+		case *linkRenamedEvent:
+			le := e.linkEvent
+			le.path = eventPath(e)
+			next(&unifiedEvent{le})
+		default:
+			next(&unifiedEvent{linkEvent{EventInfo: ei, path: eventPath(ei)}})
+		}
+	}
+}
@@ -73,7 +73,7 @@ func canonical(p string) (string, error) {
 		if depth > 128 {
 			return "", &os.PathError{Op: "canonical", Path: p, Err: errDepth}
 		}
-		if j = strings.IndexRune(p[i:], '/'); j == -1 {
+		if j = indexSep(p[i:]); j == -1 {
 			j, i = i, len(p)
 		} else {
 			j, i = i, i+j
@@ -88,7 +88,7 @@ func canonical(p string) (string, error) {
 				return "", err
 			}
 			if filepath.IsAbs(s) {
-				p = "/" + s + p[i:]
+				p = s + p[i:]
 			} else {
 				p = p[:j] + s + p[i:]
 			}
@@ -98,6 +98,30 @@ func canonical(p string) (string, error) {
 	return filepath.Clean(p), nil
 }
 
+// fileID identifies a file by its device and inode numbers.
+type fileID struct {
+	dev, ino uint64
+}
+
+// dirset is a set of directories visited during a recursive walk, used for
+// detecting cycles caused by symlinks or bind mounts.
+type dirset map[fileID]struct{}
+
+// visit adds the directory described by fi to the set. It reports false if it
+// was visited already. Directories which IDs are not known, since the platform
+// does not provide them, are never considered visited.
+func (ds dirset) visit(fi os.FileInfo) bool {
+	id, ok := fileid(fi)
+	if !ok {
+		return true
+	}
+	if _, ok := ds[id]; ok {
+		return false
+	}
+	ds[id] = struct{}{}
+	return true
+}
+
 func joinevents(events []Event) (e Event) {
 	if len(events) == 0 {
 		e = All
@@ -44,6 +44,19 @@ type watcher interface {
 	Close() error
 }
 
+// watchCounter is implemented by watchers which can tell the number of watches
+// they hold in the OS, like inotify watch descriptors.
+type watchCounter interface {
+	watchCount() int
+}
+
+// watchVerifier is implemented by watchers which can tell whether the watch of
+// path is still valid in the OS, e.g. whether it still refers to the file at
+// the path, see VerifyWatch.
+type watchVerifier interface {
+	verifyWatch(path string) bool
+}
+
 // RecursiveWatcher is an interface for a Watcher for those OS, which do support
 // recursive watching over directories.
 type recursiveWatcher interface {
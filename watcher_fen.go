@@ -130,10 +130,16 @@ func init() {
 		// monitored for Create, dir will be rescanned and Create events will
 		// be generated and returned for new files. In case of files,
 		// if not requested FileModified event is reported, it will be ignored.
-		o = int64(e &^ Create)
+		o = int64(e &^ Create &^ CloseWrite &^ Truncate)
 		if (e&Create != 0 && dir) || e&Write != 0 {
 			o = (o &^ int64(Write)) | int64(FileModified)
 		}
+		if e&Attrib != 0 {
+			o = (o &^ int64(Attrib)) | int64(FileAttrib)
+		}
+		if e&Truncate != 0 {
+			o |= int64(FileTrunc)
+		}
 		// Following events are 'exception events' and as such cannot be requested
 		// explicitly for monitoring or filtered out. If the will be reported
 		// by FEN and not subscribed with by user, they will be filtered out by
@@ -147,16 +153,18 @@ func init() {
 		FileRenameFrom: Rename,
 		FileDelete:     Remove,
 		FileAccess:     Event(0),
-		FileAttrib:     Event(0),
+		FileAttrib:     Attrib,
 		FileRenameTo:   Event(0),
-		FileTrunc:      Event(0),
+		FileTrunc:      Truncate,
 		FileNoFollow:   Event(0),
 		Unmounted:      Event(0),
 		MountedOver:    Event(0),
 	}
 	not2nat = map[Event]Event{
-		Write:  FileModified,
-		Rename: FileRenameFrom,
-		Remove: FileDelete,
+		Write:    FileModified,
+		Rename:   FileRenameFrom,
+		Remove:   FileDelete,
+		Attrib:   FileAttrib,
+		Truncate: FileTrunc,
 	}
 }
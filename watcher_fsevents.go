@@ -11,6 +11,7 @@ import (
 	"errors"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
 const (
@@ -119,6 +120,7 @@ func (w *watch) strip(base string, set uint32) uint32 {
 func (w *watch) Dispatch(ev []FSEvent) {
 	events := atomic.LoadUint32(&w.events)
 	isrec := (atomic.LoadInt32(&w.isrec) == 1)
+	now := time.Now()
 	for i := range ev {
 		if ev[i].Flags&FSEventsHistoryDone != 0 {
 			w.flushed = true
@@ -131,6 +133,12 @@ func (w *watch) Dispatch(ev []FSEvent) {
 			ev[i].Flags, ev[i].Path, i, ev[i].ID, len(ev))
 		if ev[i].Flags&failure != 0 && failure&events == 0 {
 			// TODO(rjeczalik): missing error handling
+			logf("fsevents: events of %q dropped (flags 0x%x)", w.path, ev[i].Flags)
+			w.c <- &event{
+				fse:   ev[i],
+				event: Overflow,
+				time:  now,
+			}
 			continue
 		}
 		if !strings.HasPrefix(ev[i].Path, w.path) {
@@ -157,6 +165,7 @@ func (w *watch) Dispatch(ev []FSEvent) {
 			w.c <- &event{
 				fse:   ev[i],
 				event: Event(e),
+				time:  now,
 			}
 		}
 	}
@@ -202,6 +211,7 @@ func (fse *fsevents) watch(path string, event Event, isrec int32) (err error) {
 		return err
 	}
 	fse.watches[path] = w
+	logf("fsevents: stream started for %q", path)
 	return nil
 }
 
@@ -212,6 +222,7 @@ func (fse *fsevents) unwatch(path string) (err error) {
 	}
 	w.stream.Stop()
 	delete(fse.watches, path)
+	logf("fsevents: stream for %q stopped", path)
 	return nil
 }
 
@@ -27,16 +27,17 @@ import (
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
 var nilstream C.FSEventStreamRef
 
-// Default arguments for FSEventStreamCreate function.
+// Default arguments for FSEventStreamCreate function. The latency is set
+// with WithFSEventsLatency, see streamLatency.
 var (
-	latency C.CFTimeInterval
-	flags   = C.FSEventStreamCreateFlags(C.kFSEventStreamCreateFlagFileEvents | C.kFSEventStreamCreateFlagNoDefer)
-	since   = uint64(C.FSEventsGetCurrentEventId())
+	flags = C.FSEventStreamCreateFlags(C.kFSEventStreamCreateFlagFileEvents | C.kFSEventStreamCreateFlagNoDefer)
+	since = uint64(C.FSEventsGetCurrentEventId())
 )
 
 // global dispatch queue which all streams are registered with
@@ -115,17 +116,19 @@ func (r *streamFuncRegistry) delete(id uintptr) {
 
 // Stream represents a single watch-point which listens for events scheduled on the global dispatch queue.
 type stream struct {
-	path string
-	ref  C.FSEventStreamRef
-	info uintptr
+	path    string
+	ref     C.FSEventStreamRef
+	info    uintptr
+	latency C.CFTimeInterval
 }
 
 // NewStream creates a stream for given path, listening for file events and
 // calling fn upon receiving any.
 func newStream(path string, fn streamFunc) *stream {
 	return &stream{
-		path: path,
-		info: streamFuncs.add(fn),
+		path:    path,
+		info:    streamFuncs.add(fn),
+		latency: C.CFTimeInterval(time.Duration(atomic.LoadInt64(&streamLatency)).Seconds()),
 	}
 }
 
@@ -138,7 +141,7 @@ func (s *stream) Start() error {
 	p := C.CFStringCreateWithCStringNoCopy(C.kCFAllocatorDefault, C.CString(s.path), C.kCFStringEncodingUTF8, C.kCFAllocatorDefault)
 	path := C.CFArrayCreate(C.kCFAllocatorDefault, (*unsafe.Pointer)(unsafe.Pointer(&p)), 1, nil)
 	ctx := C.FSEventStreamContext{}
-	ref := C.EventStreamCreate(&ctx, C.uintptr_t(s.info), path, C.FSEventStreamEventId(atomic.LoadUint64(&since)), latency, flags)
+	ref := C.EventStreamCreate(&ctx, C.uintptr_t(s.info), path, C.FSEventStreamEventId(atomic.LoadUint64(&since)), s.latency, flags)
 	if ref == nilstream {
 		return errCreate
 	}
@@ -10,10 +10,12 @@ package notify
 import (
 	"bytes"
 	"errors"
+	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -32,10 +34,14 @@ const consumersCount = 2
 const invalidDescriptor = -1
 
 // watched is a pair of file path and inotify mask used as a value in
-// watched files map.
+// watched files map. The device and inode numbers of the watched file tell
+// whether the watch still refers to the file at path.
 type watched struct {
 	path string
 	mask uint32
+	dev  uint64
+	ino  uint64
+	dir  bool // whether the watched file is a directory
 }
 
 // inotify implements Watcher interface.
@@ -49,6 +55,8 @@ type inotify struct {
 	buffer       [eventBufferSize]byte // inotify event buffer
 	wg           sync.WaitGroup        // wait group used to close main loop
 	c            chan<- EventInfo      // event dispatcher channel
+	sizesMu      sync.Mutex            // protects inotify.sizes map
+	sizes        map[string]int64      // last seen sizes of files watched for Truncate
 }
 
 // NewWatcher creates new non-recursive inotify backed by inotify.
@@ -60,6 +68,7 @@ func newWatcher(c chan<- EventInfo) watcher {
 		epfd:   invalidDescriptor,
 		epes:   make([]unix.EpollEvent, 0),
 		c:      c,
+		sizes:  make(map[string]int64),
 	}
 	runtime.SetFinalizer(i, func(i *inotify) {
 		i.epollclose()
@@ -84,27 +93,175 @@ func (i *inotify) Rewatch(path string, _, newevent Event) error {
 // one. If called for the first time, this function initializes inotify filesystem
 // monitor and starts producer-consumers goroutines.
 func (i *inotify) watch(path string, e Event) (err error) {
-	if e&^(All|Event(unix.IN_ALL_EVENTS)) != 0 {
+	if e&^(All|Attrib|CloseWrite|Truncate|Event(unix.IN_ALL_EVENTS)) != 0 {
 		return errors.New("notify: unknown event")
 	}
 	if err = i.lazyinit(); err != nil {
 		return
 	}
-	iwd, err := unix.InotifyAddWatch(int(i.fd), path, encode(e))
+	i.Lock()
+	defer i.Unlock()
+	return i.watchLocked(path, e)
+}
+
+// watchLocked adds a watch for path with the write lock held. IN_MOVE_SELF is
+// always requested, regardless of e, so the watch can be re-armed when the
+// watched file is moved, see rearm.
+func (i *inotify) watchLocked(path string, e Event) error {
+	iwd, err := unix.InotifyAddWatch(int(i.fd), path, encode(e)|unix.IN_MOVE_SELF)
 	if err != nil {
-		return
+		return err
 	}
-	i.Lock()
+	dev, ino, dir := inode(path)
 	if wd, ok := i.m[int32(iwd)]; !ok {
-		i.m[int32(iwd)] = &watched{path: path, mask: uint32(e)}
+		i.m[int32(iwd)] = &watched{path: path, mask: uint32(e), dev: dev, ino: ino, dir: dir}
 	} else {
 		wd.path = path
 		wd.mask = uint32(e)
+		wd.dev, wd.ino, wd.dir = dev, ino, dir
 	}
-	i.Unlock()
+	if e&Truncate != 0 {
+		i.seedSizes(path, dir)
+	}
+	logf("inotify: watch %d added for %q", iwd, path)
 	return nil
 }
 
+// seedSizes records the size of the file at path or, if it is a directory, of
+// the files within it, so their first truncation can be reported.
+func (i *inotify) seedSizes(path string, dir bool) {
+	sizes := make(map[string]int64)
+	if dir {
+		des, _ := os.ReadDir(path)
+		for _, de := range des {
+			if !de.Type().IsRegular() {
+				continue
+			}
+			if fi, err := de.Info(); err == nil {
+				sizes[filepath.Join(path, de.Name())] = fi.Size()
+			}
+		}
+	} else if fi, err := os.Stat(path); err == nil {
+		sizes[path] = fi.Size()
+	}
+	i.sizesMu.Lock()
+	for p, size := range sizes {
+		i.sizes[p] = size
+	}
+	i.sizesMu.Unlock()
+}
+
+// forgetSizes drops the sizes recorded for path and the files within it.
+func (i *inotify) forgetSizes(path string) {
+	i.sizesMu.Lock()
+	for p := range i.sizes {
+		if p == path || filepath.Dir(p) == path {
+			delete(i.sizes, p)
+		}
+	}
+	i.sizesMu.Unlock()
+}
+
+// truncated turns the Write event e into Truncate when the file shrank since
+// the previous event, it is called for watches which requested Truncate.
+// The Write is skipped when it was not requested. The size is forgotten when
+// the file is created, moved or removed, since it is no longer the same file.
+func (i *inotify) truncated(mask Event, e *event) {
+	const reset = unix.IN_CREATE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM |
+		unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+	if e.sys.Mask&reset != 0 {
+		i.sizesMu.Lock()
+		delete(i.sizes, e.path)
+		i.sizesMu.Unlock()
+		return
+	}
+	if e.event != Write {
+		return
+	}
+	if mask&Write == 0 {
+		e.event = 0
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(e.path, &st); err != nil {
+		return
+	}
+	i.sizesMu.Lock()
+	prev, ok := i.sizes[e.path]
+	i.sizes[e.path] = st.Size
+	i.sizesMu.Unlock()
+	if ok && st.Size < prev {
+		e.event = Truncate
+	}
+}
+
+// inode gives the device and inode numbers of the file at path and whether it
+// is a directory, zeros if it cannot be stat'ed.
+func inode(path string) (dev, ino uint64, dir bool) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, 0, false
+	}
+	return uint64(st.Dev), st.Ino, st.Mode&unix.S_IFMT == unix.S_IFDIR
+}
+
+// stale reports whether the watch no longer refers to the file at its path,
+// since the file was moved, removed or replaced.
+func (wd *watched) stale() bool {
+	dev, ino, _ := inode(wd.path)
+	return ino == 0 || dev != wd.dev || ino != wd.ino
+}
+
+// rearm re-registers the watches made stale by their files being moved or
+// removed, if a file exists at their paths again, like when a directory was
+// replaced with another one or removed and quickly recreated. The moved are
+// descriptors which received IN_MOVE_SELF, the removed ones which received
+// IN_IGNORED. The watches of the files below a moved directory are checked as
+// well, since they follow the directory. A watch which cannot be re-armed,
+// since nothing exists at its path, is reported with ErrWatchRemoved. Moved
+// watches are not re-armed at the new paths of their files, inotify does not
+// tell them.
+func (i *inotify) rearm(moved, removed []int32) {
+	i.Lock()
+	defer i.Unlock()
+	if atomic.LoadInt32(&i.fd) == invalidDescriptor {
+		return
+	}
+	for _, iwd := range removed {
+		wd, ok := i.m[iwd]
+		if !ok {
+			continue
+		}
+		logf("inotify: watch %d for %q removed by the kernel", iwd, wd.path)
+		delete(i.m, iwd)
+		if err := i.watchLocked(wd.path, Event(wd.mask)); err != nil {
+			reportError(wd.path, ErrWatchRemoved)
+		}
+	}
+	for _, iwd := range moved {
+		wd, ok := i.m[iwd]
+		if !ok || !wd.stale() {
+			continue
+		}
+		dir := wd.path
+		for iwd, wd := range i.m {
+			if wd.path != dir && indexrel(dir, wd.path) == -1 || !wd.stale() {
+				continue
+			}
+			logf("inotify: watch %d for %q moved away", iwd, wd.path)
+			if err := removeInotifyWatch(i.fd, iwd); err != nil {
+				dbgprintf("inotify: removing watch %d failed: %v", iwd, err)
+			}
+			delete(i.m, iwd)
+			switch err := i.watchLocked(wd.path, Event(wd.mask)); {
+			case os.IsNotExist(err):
+				reportError(wd.path, ErrWatchRemoved)
+			case err != nil:
+				reportError(wd.path, err)
+			}
+		}
+	}
+}
+
 // lazyinit sets up all required file descriptors and starts 1+consumersCount
 // goroutines. The producer goroutine blocks until file-system notifications
 // occur. Then, all events are read from system buffer and sent to consumer
@@ -178,6 +335,10 @@ func (i *inotify) epollclose() (err error) {
 	return
 }
 
+// moveTimeout is the time loop waits for the IN_MOVED_TO event which pairs with
+// an IN_MOVED_FROM event that was the last one read from inotify.
+const moveTimeout = 10 * time.Millisecond
+
 // loop blocks until either inotify or pipe file descriptor is ready for I/O.
 // All read operations triggered by filesystem notifications are forwarded to
 // one of the event's consumers. If pipe fd became ready, loop function closes
@@ -185,12 +346,29 @@ func (i *inotify) epollclose() (err error) {
 func (i *inotify) loop(esch chan<- []*event) {
 	epes := make([]unix.EpollEvent, 1)
 	fd := atomic.LoadInt32(&i.fd)
+	// held is an IN_MOVED_FROM event which pair may be read next.
+	var held *event
 	for {
-		switch _, err := unix.EpollWait(i.epfd, epes, -1); err {
+		timeout := -1
+		if held != nil {
+			timeout = int(moveTimeout / time.Millisecond)
+		}
+		switch n, err := unix.EpollWait(i.epfd, epes, timeout); err {
 		case nil:
+			if n == 0 {
+				esch <- []*event{held}
+				held = nil
+				continue
+			}
 			switch epes[0].Fd {
 			case fd:
-				esch <- i.read()
+				es := i.read()
+				if held != nil {
+					es = append([]*event{held}, es...)
+				}
+				if es, held = pair(es); len(es) != 0 {
+					esch <- es
+				}
 				epes[0].Fd = 0
 			case int32(i.pipefd[0]):
 				i.Lock()
@@ -213,6 +391,29 @@ func (i *inotify) loop(esch chan<- []*event) {
 	}
 }
 
+// pair links IN_MOVED_FROM and IN_MOVED_TO events of a single move, which
+// share the same cookie. Since inotify queues the two events one after another,
+// only the last event may miss its pair, which is going to be read next. If it
+// is an IN_MOVED_FROM event, it is cut off from es and returned as held.
+func pair(es []*event) (_ []*event, held *event) {
+	from := make(map[uint32]*event)
+	for _, e := range es {
+		switch {
+		case e.sys.Mask&unix.IN_MOVED_FROM != 0:
+			from[e.sys.Cookie] = e
+		case e.sys.Mask&unix.IN_MOVED_TO != 0:
+			if f, ok := from[e.sys.Cookie]; ok {
+				f.pair, e.pair = e, f
+				delete(from, e.sys.Cookie)
+			}
+		}
+	}
+	if n := len(es); n != 0 && es[n-1].sys.Mask&unix.IN_MOVED_FROM != 0 && es[n-1].pair == nil {
+		return es[:n-1], es[n-1]
+	}
+	return es, nil
+}
+
 // read reads events from an inotify file descriptor. It does not handle errors
 // returned from read(2) function since they are not critical to watcher logic.
 func (i *inotify) read() (es []*event) {
@@ -221,6 +422,7 @@ func (i *inotify) read() (es []*event) {
 		return
 	}
 	var sys *unix.InotifyEvent
+	now := time.Now()
 	nmin := n - unix.SizeofInotifyEvent
 	for pos, path := 0, ""; pos <= nmin; {
 		sys = (*unix.InotifyEvent)(unsafe.Pointer(&i.buffer[pos]))
@@ -237,6 +439,7 @@ func (i *inotify) read() (es []*event) {
 				Cookie: sys.Cookie,
 			},
 			path: path,
+			time: now,
 		})
 	}
 	return
@@ -249,7 +452,7 @@ func (i *inotify) send(esch <-chan []*event) {
 	for es := range esch {
 		for _, e := range i.transform(es) {
 			if e != nil {
-				i.c <- e
+				i.c <- e.info()
 			}
 		}
 	}
@@ -262,28 +465,59 @@ func (i *inotify) send(esch <-chan []*event) {
 // when system-dependent result is required.
 func (i *inotify) transform(es []*event) []*event {
 	var multi []*event
+	var moved, removed []int32
 	i.RLock()
 	for idx, e := range es {
-		if e.sys.Mask&(unix.IN_IGNORED|unix.IN_Q_OVERFLOW) != 0 {
+		if e.sys.Mask&unix.IN_Q_OVERFLOW != 0 {
+			logf("inotify: event queue overflowed")
+			e.event = Overflow
+			continue
+		}
+		if e.sys.Mask&unix.IN_IGNORED != 0 {
+			if _, ok := i.m[e.sys.Wd]; ok {
+				removed = append(removed, e.sys.Wd)
+			}
 			es[idx] = nil
 			continue
 		}
+		if e.sys.Mask&unix.IN_MOVE_SELF != 0 {
+			moved = append(moved, e.sys.Wd)
+		}
 		wd, ok := i.m[e.sys.Wd]
-		if !ok || e.sys.Mask&encode(Event(wd.mask)) == 0 {
+		if !ok {
+			if e.pair != nil {
+				e.pair.pair = nil
+			}
 			es[idx] = nil
 			continue
 		}
 		if e.path == "" {
-			e.path = wd.path
+			// The kernel does not set IN_ISDIR for events of the watched
+			// directory itself, like IN_DELETE_SELF.
+			e.path, e.dir = wd.path, wd.dir
 		} else {
 			e.path = filepath.Join(wd.path, e.path)
 		}
-		multi = append(multi, decode(Event(wd.mask), e))
+		if e.sys.Mask&encode(Event(wd.mask)) == 0 {
+			es[idx] = nil
+			continue
+		}
+		syse := decode(Event(wd.mask), e)
+		if Event(wd.mask)&Truncate != 0 {
+			i.truncated(Event(wd.mask), e)
+		}
+		if syse != nil {
+			syse.pair = e.pair
+		}
+		multi = append(multi, syse)
 		if e.event == 0 {
 			es[idx] = nil
 		}
 	}
 	i.RUnlock()
+	if len(moved) != 0 || len(removed) != 0 {
+		i.rearm(moved, removed)
+	}
 	es = append(es, multi...)
 	return es
 }
@@ -303,6 +537,16 @@ func encode(e Event) uint32 {
 	if e&Rename != 0 {
 		e = (e ^ Rename) | InMovedFrom | InMoveSelf
 	}
+	if e&Attrib != 0 {
+		e = (e ^ Attrib) | InAttrib
+	}
+	if e&CloseWrite != 0 {
+		e = (e ^ CloseWrite) | InCloseWrite
+	}
+	if e&Truncate != 0 {
+		// Creations reset the size, which tells Truncate from Write.
+		e = (e ^ Truncate) | InModify | InCreate | InMovedTo
+	}
 	return uint32(e)
 }
 
@@ -317,7 +561,7 @@ func decode(mask Event, e *event) (syse *event) {
 			Wd:     e.sys.Wd,
 			Mask:   e.sys.Mask,
 			Cookie: e.sys.Cookie,
-		}, event: Event(sysmask), path: e.path}
+		}, event: Event(sysmask), path: e.path, dir: e.dir, time: e.time}
 	}
 	imask := encode(mask)
 	switch {
@@ -325,10 +569,15 @@ func decode(mask Event, e *event) (syse *event) {
 		e.event = Create
 	case mask&Remove != 0 && imask&uint32(InDelete|InDeleteSelf)&e.sys.Mask != 0:
 		e.event = Remove
-	case mask&Write != 0 && imask&uint32(InModify)&e.sys.Mask != 0:
+	case mask&(Write|Truncate) != 0 && imask&uint32(InModify)&e.sys.Mask != 0:
+		// Truncate is told apart from Write by inotify.truncated.
 		e.event = Write
 	case mask&Rename != 0 && imask&uint32(InMovedFrom|InMoveSelf)&e.sys.Mask != 0:
 		e.event = Rename
+	case mask&Attrib != 0 && imask&uint32(InAttrib)&e.sys.Mask != 0:
+		e.event = Attrib
+	case mask&CloseWrite != 0 && imask&uint32(InCloseWrite)&e.sys.Mask != 0:
+		e.event = CloseWrite
 	default:
 		e.event = 0
 	}
@@ -341,27 +590,53 @@ func decode(mask Event, e *event) (syse *event) {
 // delete identical path.
 func (i *inotify) Unwatch(path string) (err error) {
 	iwd := int32(invalidDescriptor)
-	i.RLock()
+	// The watch is looked up and removed under a single lock, so it cannot be
+	// re-armed in the meantime, and the IN_IGNORED event generated by the
+	// removal is not mistaken for the kernel removing the watch.
+	i.Lock()
 	for iwdkey, wd := range i.m {
 		if wd.path == path {
 			iwd = iwdkey
 			break
 		}
 	}
-	i.RUnlock()
 	if iwd == invalidDescriptor {
+		i.Unlock()
 		return errors.New("notify: path " + path + " is already watched")
 	}
 	fd := atomic.LoadInt32(&i.fd)
 	if err = removeInotifyWatch(fd, iwd); err != nil {
+		i.Unlock()
 		return
 	}
-	i.Lock()
 	delete(i.m, iwd)
 	i.Unlock()
+	i.forgetSizes(path)
+	logf("inotify: watch %d for %q closed", iwd, path)
 	return nil
 }
 
+// watchCount implements notify.watchCounter interface. It gives the number of
+// watch descriptors currently held.
+func (i *inotify) watchCount() int {
+	i.RLock()
+	defer i.RUnlock()
+	return len(i.m)
+}
+
+// verifyWatch implements notify.watchVerifier interface. A watch is valid if
+// it still refers to the file at its path.
+func (i *inotify) verifyWatch(path string) bool {
+	i.RLock()
+	defer i.RUnlock()
+	for _, wd := range i.m {
+		if wd.path == path {
+			return !wd.stale()
+		}
+	}
+	return false
+}
+
 // Close implements notify.watcher interface. It removes all existing watch
 // descriptors and wakes up producer goroutine by sending data to the write end
 // of the pipe. The function waits for a signal from producer which means that
@@ -396,5 +671,8 @@ func removeInotifyWatch(fd int32, iwd int32) (err error) {
 	if _, err = unix.InotifyRmWatch(int(fd), uint32(iwd)); err != nil && err != unix.EINVAL {
 		return
 	}
+	if err == unix.EINVAL {
+		logf("inotify: watch %d already removed: %v", iwd, err)
+	}
 	return nil
 }
@@ -173,16 +173,24 @@ func init() {
 		// and Create events will be generated and returned for new files.
 		// In case of files, if not requested NoteRename event is reported,
 		// it will be ignored.
-		o = int64(e &^ Create)
+		o = int64(e &^ Create &^ CloseWrite &^ Truncate)
 		if (e&Create != 0 && dir) || e&Write != 0 {
 			o = (o &^ int64(Write)) | int64(NoteWrite)
 		}
+		// There is no Truncate event for files, instead it is synthesized
+		// from the change of their size, see trg.process.
+		if e&Truncate != 0 && !dir {
+			o |= int64(NoteWrite | NoteExtend | NoteAttrib)
+		}
 		if e&Rename != 0 {
 			o = (o &^ int64(Rename)) | int64(NoteRename)
 		}
 		if e&Remove != 0 {
 			o = (o &^ int64(Remove)) | int64(NoteDelete)
 		}
+		if e&Attrib != 0 {
+			o = (o &^ int64(Attrib)) | int64(NoteAttrib)
+		}
 		return
 	}
 	nat2not = map[Event]Event{
@@ -190,7 +198,7 @@ func init() {
 		NoteRename: Rename,
 		NoteDelete: Remove,
 		NoteExtend: Event(0),
-		NoteAttrib: Event(0),
+		NoteAttrib: Attrib,
 		NoteRevoke: Event(0),
 		NoteLink:   Event(0),
 	}
@@ -198,5 +206,6 @@ func init() {
 		Write:  NoteWrite,
 		Rename: NoteRename,
 		Remove: NoteDelete,
+		Attrib: NoteAttrib,
 	}
 }
@@ -13,6 +13,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -295,7 +296,7 @@ func (r *readdcw) RecursiveWatch(path string, event Event) error {
 // already exists, function tries to rewatch it with new filters(NOT VALID). Moreover,
 // watch starts the main event loop goroutine when called for the first time.
 func (r *readdcw) watch(path string, event Event, recursive bool) error {
-	if event&^(All|fileNotifyChangeAll) != 0 {
+	if event&^(All|Attrib|CloseWrite|Truncate|fileNotifyChangeAll) != 0 {
 		return errors.New("notify: unknown event")
 	}
 
@@ -319,6 +320,7 @@ func (r *readdcw) watch(path string, event Event, recursive bool) error {
 
 	r.m[path] = wd
 	dbgprint("watch: new watch added")
+	logf("readdcw: watch added for %q", path)
 
 	return nil
 }
@@ -368,6 +370,7 @@ func (r *readdcw) loop() {
 		}
 		if err = overEx.parent.readDirChanges(); err != nil {
 			// TODO: error handling
+			reportError(syscall.UTF16ToString(overEx.parent.pathw), err)
 		}
 		r.loopstate(overEx)
 	}
@@ -390,6 +393,7 @@ func (r *readdcw) loopstate(overEx *overlappedEx) {
 			dbgprint("loopstate unwatch")
 			overEx.parent.parent.closeHandle()
 			delete(r.m, syscall.UTF16ToString(overEx.parent.pathw))
+			logf("readdcw: watch for %q closed", syscall.UTF16ToString(overEx.parent.pathw))
 		case stateCPClose:
 		default:
 			panic(`notify: windows loopstate logic error`)
@@ -400,6 +404,7 @@ func (r *readdcw) loopstate(overEx *overlappedEx) {
 // TODO(pknap) : doc
 func (r *readdcw) loopevent(n uint32, overEx *overlappedEx) {
 	events := []*event{}
+	now := time.Now()
 	var currOffset uint32
 	for {
 		raw := (*syscall.FileNotifyInformation)(unsafe.Pointer(&overEx.parent.buffer[currOffset]))
@@ -409,6 +414,7 @@ func (r *readdcw) loopevent(n uint32, overEx *overlappedEx) {
 			filter: overEx.parent.filter,
 			action: raw.Action,
 			name:   name,
+			time:   now,
 		})
 		if raw.NextEntryOffset == 0 {
 			break
@@ -446,6 +452,7 @@ func (r *readdcw) send(es []*event) {
 				action: e.action,
 				filter: e.filter,
 				e:      syse,
+				time:   e.time,
 			}
 		}
 		r.c <- e
@@ -471,7 +478,7 @@ func (r *readdcw) RecursiveRewatch(oldpath, newpath string, oldevent,
 
 // TODO : (pknap) doc.
 func (r *readdcw) rewatch(path string, oldevent, newevent uint32, recursive bool) (err error) {
-	if Event(newevent)&^(All|fileNotifyChangeAll) != 0 {
+	if Event(newevent)&^(All|Attrib|CloseWrite|Truncate|fileNotifyChangeAll) != 0 {
 		return errors.New("notify: unknown event")
 	}
 	var wd *watched
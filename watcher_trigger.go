@@ -30,6 +30,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 // trigger is to be implemented by platform implementation like FEN or kqueue.
@@ -114,6 +115,22 @@ func newWatcher(c chan<- EventInfo) watcher {
 	return t
 }
 
+// verifyWatch implements watchVerifier. A watch is valid if the watched file
+// is still the one at its path.
+func (t *trg) verifyWatch(path string) bool {
+	var watched os.FileInfo
+	t.Lock()
+	if w, ok := t.pthLkp[path]; ok {
+		watched = w.fi
+	}
+	t.Unlock()
+	if watched == nil {
+		return false
+	}
+	fi, err := os.Stat(path)
+	return err == nil && os.SameFile(watched, fi)
+}
+
 // Close implements watcher.
 func (t *trg) Close() (err error) {
 	t.Lock()
@@ -142,7 +159,9 @@ func (t *trg) Close() (err error) {
 
 // send reported events one by one through chan.
 func (t *trg) send(evn []event) {
+	now := time.Now()
 	for i := range evn {
+		evn[i].time = now
 		t.c <- &evn[i]
 	}
 }
@@ -172,6 +191,7 @@ func (t *trg) singlewatch(p string, e Event, direct mode, fi os.FileInfo) (err e
 	}
 	if !ok {
 		t.t.Record(w)
+		logf("trigger: watch added for %q", p)
 		return nil
 	}
 	return errAlreadyWatched
@@ -295,7 +315,7 @@ func (t *trg) Rewatch(p string, _, e Event) error {
 }
 
 func (*trg) file(w *watched, n interface{}, e Event) (evn []event) {
-	evn = append(evn, event{w.p, e, w.fi.IsDir(), n})
+	evn = append(evn, event{p: w.p, e: e, d: w.fi.IsDir(), pe: n})
 	return
 }
 
@@ -310,7 +330,7 @@ func (t *trg) dir(w *watched, n interface{}, e, ge Event) (evn []event) {
 	if (ge & (not2nat[Rename] | not2nat[Remove])) != 0 {
 		// Write is reported also for Remove on directory. Because of that
 		// we have to filter it out explicitly.
-		evn = append(evn, event{w.p, e & ^Write & ^not2nat[Write], true, n})
+		evn = append(evn, event{p: w.p, e: e & ^Write & ^not2nat[Write], d: true, pe: n})
 		if ge&not2nat[Rename] != 0 {
 			for p := range t.pthLkp {
 				if strings.HasPrefix(p, w.p+string(os.PathSeparator)) {
@@ -321,8 +341,8 @@ func (t *trg) dir(w *watched, n interface{}, e, ge Event) (evn []event) {
 					}
 					if (w.eDir|w.eNonDir)&(not2nat[Rename]|Rename) != 0 {
 						evn = append(evn, event{
-							p, (w.eDir | w.eNonDir) & e &^ Write &^ not2nat[Write],
-							w.fi.IsDir(), nil,
+							p: p, e: (w.eDir | w.eNonDir) & e &^ Write &^ not2nat[Write],
+							d: w.fi.IsDir(),
 						})
 					}
 				}
@@ -331,17 +351,21 @@ func (t *trg) dir(w *watched, n interface{}, e, ge Event) (evn []event) {
 		t.t.Del(w)
 		return
 	}
+	if e&Attrib != 0 {
+		evn = append(evn, event{p: w.p, e: Attrib, d: true, pe: n})
+	}
 	if (ge & not2nat[Write]) != 0 {
 		switch err := t.walk(w.p, func(fi os.FileInfo) error {
 			p := filepath.Join(w.p, fi.Name())
 			switch err := t.singlewatch(p, w.eDir, ndir, fi); {
 			case os.IsNotExist(err) && ((w.eDir & Remove) != 0):
-				evn = append(evn, event{p, Remove, fi.IsDir(), n})
+				evn = append(evn, event{p: p, e: Remove, d: fi.IsDir(), pe: n})
 			case err == errAlreadyWatched:
 			case err != nil:
 				dbgprintf("trg: watching %q failed: %q", p, err)
+				reportError(p, err)
 			case (w.eDir & Create) != 0:
-				evn = append(evn, event{p, Create, fi.IsDir(), n})
+				evn = append(evn, event{p: p, e: Create, d: fi.IsDir(), pe: n})
 			default:
 			}
 			return nil
@@ -392,6 +416,7 @@ func (t *trg) singleunwatch(p string, direct mode) error {
 		}
 	} else {
 		t.t.Del(w)
+		logf("trigger: watch for %q closed", p)
 	}
 	return nil
 }
@@ -433,6 +458,15 @@ func (t *trg) process(n interface{}) (evn []event) {
 			if err = t.t.Watch(fi, w, encode(w.eDir|w.eNonDir, fi.IsDir())); err != nil {
 				dbgprintf("trg: %q is no longer watched: %q", w.p, err)
 				t.t.Del(w)
+				reportError(w.p, err)
+			} else if !fi.IsDir() && !w.fi.IsDir() {
+				// Truncate is reported when the file shrank since the last
+				// event, unless the platform reported it already.
+				if (w.eDir|w.eNonDir)&Truncate != 0 && e&Truncate == 0 &&
+					fi.Size() < w.fi.Size() {
+					e = (e &^ Write) | Truncate
+				}
+				w.fi = fi
 			}
 		}
 	}
@@ -448,6 +482,7 @@ func (t *trg) process(n interface{}) (evn []event) {
 	}
 	if Event(ge)&(not2nat[Remove]|not2nat[Rename]) != 0 {
 		t.t.Del(w)
+		reportError(w.p, ErrWatchRemoved)
 	}
 	t.Unlock()
 	return
@@ -0,0 +1,97 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// ErrWatchRemoved is reported by WatchErr when the watched path was removed
+// by the system, like when the watched directory is deleted or the
+// filesystem it lives on is unmounted. No further events are delivered for
+// the path.
+var ErrWatchRemoved = errors.New("notify: watch removed by the system")
+
+// errWatch describes a subscription set up with WatchErr.
+type errWatch struct {
+	root  string
+	isrec bool
+	errc  chan<- error
+}
+
+var (
+	errWatchesMu sync.Mutex // protects errWatches
+	errWatches   = make(map[*subscription]errWatch)
+)
+
+// WatchErr works like Watch, but additionally reports to errc the errors
+// which the watcher encounters after the watchpoint was set up, which are
+// otherwise only visible as events that stop arriving. Typical errors are:
+//
+//   - ErrWatchRemoved, when the watched path was deleted or moved away out
+//     from under the watchpoint, reported only for the watched path itself,
+//     not for its subdirectories removed from a recursive watchpoint,
+//   - failures to watch a directory created or moved within a recursive
+//     watchpoint, or to re-arm a watch after a change.
+//
+// Each error is an *os.PathError describing the affected path. Like events,
+// errors are dropped if errc is not ready to receive them. A nil errc makes
+// WatchErr equivalent to Watch. The semantics of c are not changed.
+//
+// Stop called on c stops reporting errors to errc as well.
+func WatchErr(path string, c chan<- EventInfo, errc chan<- error, events ...Event) error {
+	if c == nil {
+		panic("notify: Watch using nil channel")
+	}
+	if errc == nil {
+		return Watch(path, c, events...)
+	}
+	root, isrec, err := cleanpath(path)
+	if err != nil {
+		return err
+	}
+	var stages []stage
+	if link := linkStage(path); link != nil {
+		stages = append(stages, link)
+	}
+	s, err := subscribe(path, c, events, stages...)
+	if err != nil || s == nil {
+		return err
+	}
+	errWatchesMu.Lock()
+	errWatches[s] = errWatch{root: root, isrec: isrec, errc: errc}
+	errWatchesMu.Unlock()
+	s.onStop(func() {
+		errWatchesMu.Lock()
+		delete(errWatches, s)
+		errWatchesMu.Unlock()
+	})
+	return nil
+}
+
+// reportError sends err, which the watcher encountered asynchronously for
+// path, to the error channels of the watchpoints covering path. It never
+// blocks.
+func reportError(path string, err error) {
+	logf("watching %q failed: %v", path, err)
+	if _, ok := err.(*os.PathError); !ok {
+		err = &os.PathError{Op: "watch", Path: path, Err: err}
+	}
+	removed := errors.Is(err, ErrWatchRemoved)
+	errWatchesMu.Lock()
+	defer errWatchesMu.Unlock()
+	for _, w := range errWatches {
+		if path != w.root && (removed || !w.isrec || indexrel(w.root, path) == -1) {
+			continue
+		}
+		select {
+		case w.errc <- err:
+		default:
+			dbgprintf("dropped error %v: receiver too slow", err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package notify
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isWatchLimit reports whether err was caused by exhausting the limit of
+// watches or open files.
+func isWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE)
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+//go:build plan9
+// +build plan9
+
+package notify
+
+// isWatchLimit stub.
+func isWatchLimit(error) bool {
+	return false
+}
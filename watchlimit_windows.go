@@ -0,0 +1,25 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package notify
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Win32 error codes, which are not defined by syscall package.
+const (
+	errorTooManyOpenFiles = syscall.Errno(4)    // ERROR_TOO_MANY_OPEN_FILES
+	errorNotEnoughQuota   = syscall.Errno(1816) // ERROR_NOT_ENOUGH_QUOTA
+)
+
+// isWatchLimit reports whether err was caused by exhausting the limit of
+// watches or open files.
+func isWatchLimit(err error) bool {
+	return errors.Is(err, errorTooManyOpenFiles) || errors.Is(err, errorNotEnoughQuota)
+}
@@ -84,7 +84,7 @@ func (wp watchpoint) Dispatch(ei EventInfo, extra Event) {
 		return
 	}
 	for ch, eset := range wp {
-		if ch != nil && matches(eset, e) {
+		if ch != nil && matches(eset, e) && !isPaused(ch) {
 			select {
 			case ch <- ei:
 			default: // Drop event if receiver is too slow
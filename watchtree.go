@@ -0,0 +1,201 @@
+// Copyright (c) 2014-2015 The Notify Authors. All rights reserved.
+// Use of this source code is governed by the MIT license that can be
+// found in the LICENSE file.
+
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Tree is a read-only view of the files within a path watched with
+// WatchTree. It is safe for concurrent use.
+type Tree interface {
+	// Exists reports whether path is in the tree.
+	Exists(path string) bool
+
+	// Children gives the names of the files and directories within dir,
+	// sorted. It gives nil if dir is not a directory in the tree.
+	Children(dir string) []string
+}
+
+// WatchTree sets up a watchpoint on path like Watch does and returns a view of
+// the files within the path, which is kept in sync with the events of the
+// watchpoint, so consumers which display the watched tree, like file
+// browsers, do not need to build their own mirror of it. The view is
+// populated by walking the path once the watchpoint is set up, like
+// WithInitialScan does. A recursive watchpoint covers the whole subtree,
+// otherwise only the immediate content of path is there.
+//
+// The tree is updated before the events are delivered to c, so when an event
+// is received, the tree already reflects it. The tree watches Create, Remove
+// and Rename in addition to events, but only events are delivered to c.
+// After an Overflow the tree is walked again. Paths ignored by the global
+// ignore matcher are not in the tree.
+//
+// Paths are given to the tree under path, as passed to WatchTree, the events
+// are reported with symlinks resolved, like for Watch. Stop called on c
+// removes the watchpoint, after which the tree is no longer updated.
+func WatchTree(path string, c chan<- EventInfo, events ...Event) (Tree, error) {
+	if c == nil {
+		panic("notify: Watch using nil channel")
+	}
+	root, isrec, err := cleanpath(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &liveTree{
+		user:  watchroot(path),
+		root:  root,
+		nodes: make(map[string]os.FileInfo),
+		kids:  make(map[string]map[string]struct{}),
+	}
+	if isrec {
+		t.max = -1
+	}
+	eset := joinevents(events)
+	if _, err := subscribe(path, c, []Event{eset | Create | Remove | Rename}, t.stage(eset)); err != nil {
+		return nil, err
+	}
+	t.rescan()
+	return t, nil
+}
+
+// liveTree is a Tree updated by the events of its watchpoint.
+type liveTree struct {
+	user string // root as given by the user
+	root string // real path of the root
+	max  int    // levels of subdirectories to descend into, -1 for no limit
+
+	upd   sync.Mutex   // serializes updates, which stat files before locking mu
+	mu    sync.RWMutex // protects nodes and kids
+	nodes map[string]os.FileInfo
+	kids  map[string]map[string]struct{}
+}
+
+// Exists implements Tree interface.
+func (t *liveTree) Exists(path string) bool {
+	path = t.realpath(path)
+	t.mu.RLock()
+	_, ok := t.nodes[path]
+	t.mu.RUnlock()
+	return ok
+}
+
+// Children implements Tree interface.
+func (t *liveTree) Children(dir string) []string {
+	dir = t.realpath(dir)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if fi, ok := t.nodes[dir]; !ok || !fi.IsDir() {
+		return nil
+	}
+	names := make([]string, 0, len(t.kids[dir]))
+	for name := range t.kids[dir] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// realpath gives path, which is under the root as given by the user, under
+// the real root, in which the events are reported.
+func (t *liveTree) realpath(path string) string {
+	if p, err := filepath.Abs(path); err == nil {
+		path = p
+	}
+	path, _ = relink(filepath.Clean(path), t.user, t.root)
+	return path
+}
+
+// stage updates the tree with every event before passing on the ones of eset.
+func (t *liveTree) stage(eset Event) stage {
+	return func(_ *subscription, next sink) sink {
+		return func(ei EventInfo) {
+			t.update(ei)
+			if ei.Event() == Overflow || ei.Event()&eset != 0 {
+				next(ei)
+			}
+		}
+	}
+}
+
+// update brings the path of ei in the tree up to date with the filesystem,
+// or the whole tree after an Overflow.
+func (t *liveTree) update(ei EventInfo) {
+	path := ei.Path()
+	if ei.Event() == Overflow || path == "" {
+		t.rescan()
+		return
+	}
+	t.upd.Lock()
+	defer t.upd.Unlock()
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.mu.Lock()
+		t.remove(path)
+		t.mu.Unlock()
+		return
+	}
+	var snap map[string]os.FileInfo
+	if fi.IsDir() && path != t.root && t.max < 0 {
+		snap = newPoller(path, -1, 0).snap
+	}
+	t.mu.Lock()
+	t.add(path, fi)
+	for path, fi := range snap {
+		t.add(path, fi)
+	}
+	t.mu.Unlock()
+}
+
+// rescan walks the root and replaces the tree with the outcome.
+func (t *liveTree) rescan() {
+	t.upd.Lock()
+	defer t.upd.Unlock()
+	fi, err := os.Lstat(t.root)
+	var snap map[string]os.FileInfo
+	if err == nil {
+		snap = newPoller(t.root, t.max, 0).snap
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nodes = make(map[string]os.FileInfo)
+	t.kids = make(map[string]map[string]struct{})
+	if err != nil {
+		return
+	}
+	t.add(t.root, fi)
+	for path, fi := range snap {
+		t.add(path, fi)
+	}
+}
+
+// add puts path in the tree. It expects t.mu to be locked.
+func (t *liveTree) add(path string, fi os.FileInfo) {
+	t.nodes[path] = fi
+	if path == t.root {
+		return
+	}
+	dir := filepath.Dir(path)
+	if t.kids[dir] == nil {
+		t.kids[dir] = make(map[string]struct{})
+	}
+	t.kids[dir][filepath.Base(path)] = struct{}{}
+}
+
+// remove removes path together with its content from the tree. It expects
+// t.mu to be locked.
+func (t *liveTree) remove(path string) {
+	for name := range t.kids[path] {
+		t.remove(filepath.Join(path, name))
+	}
+	delete(t.kids, path)
+	delete(t.nodes, path)
+	if kids, ok := t.kids[filepath.Dir(path)]; ok {
+		delete(kids, filepath.Base(path))
+	}
+}